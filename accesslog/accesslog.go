@@ -0,0 +1,127 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"github.com/1f349/violet/logger"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var Logger = logger.Logger.WithPrefix("Violet Access Log")
+
+// Format selects how entries are written to an output.
+type Format string
+
+const (
+	FormatJSON     Format = "json"
+	FormatCommon   Format = "common"
+	FormatCombined Format = "combined"
+)
+
+// Entry contains the details of a single request.
+type Entry struct {
+	Time           time.Time
+	RequestId      string
+	Remote         string
+	Method         string
+	URI            string
+	Proto          string
+	Host           string
+	Status         int
+	BytesIn        int64
+	BytesOut       int64
+	Referer        string
+	UserAgent      string
+	Route          string        // source of the matched route or redirect
+	Upstream       string        // destination url of the proxied request
+	UpstreamStatus int           // status code returned by the upstream
+	Duration       time.Duration // total time taken to respond
+	RouteTime      time.Duration // time taken before sending the upstream request
+	UpstreamTime   time.Duration // time waiting for the upstream response headers
+}
+
+// Output configures where and how entries are written. The path may be
+// "stdout" or "stderr", otherwise the file is rotated once it reaches MaxSize
+// megabytes or after RotateEvery has passed.
+type Output struct {
+	Format      Format
+	Path        string
+	MaxSize     int           // maximum size in megabytes before rotating, defaults to 100
+	MaxBackups  int           // maximum number of rotated files to keep, zero keeps all
+	MaxAge      int           // maximum number of days to keep rotated files, zero keeps all
+	RotateEvery time.Duration // rotate the file on this interval, zero disables time rotation
+}
+
+// AccessLog writes entries to all the configured outputs.
+type AccessLog struct {
+	outputs []*output
+}
+
+type output struct {
+	mu     sync.Mutex
+	format Format
+	w      io.Writer
+	closer io.Closer
+}
+
+// New opens all the outputs, an error is returned if an output has an unknown
+// format.
+func New(outputs ...Output) (*AccessLog, error) {
+	a := &AccessLog{outputs: make([]*output, 0, len(outputs))}
+	for _, o := range outputs {
+		switch o.Format {
+		case FormatJSON, FormatCommon, FormatCombined:
+		case "":
+			o.Format = FormatCombined
+		default:
+			_ = a.Close()
+			return nil, fmt.Errorf("unknown access log format: '%s'", o.Format)
+		}
+
+		out := &output{format: o.Format}
+		switch o.Path {
+		case "", "stdout":
+			out.w = os.Stdout
+		case "stderr":
+			out.w = os.Stderr
+		default:
+			r := newRotator(o)
+			out.w = r
+			out.closer = r
+		}
+		a.outputs = append(a.outputs, out)
+	}
+	return a, nil
+}
+
+// Log writes the entry to all outputs, a nil AccessLog does nothing.
+func (a *AccessLog) Log(e Entry) {
+	if a == nil {
+		return
+	}
+	for _, o := range a.outputs {
+		o.mu.Lock()
+		err := writeEntry(o.w, o.format, e)
+		o.mu.Unlock()
+		if err != nil {
+			Logger.Warn("Failed to write access log", "err", err)
+		}
+	}
+}
+
+// Close closes all the file outputs.
+func (a *AccessLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	var errs []error
+	for _, o := range a.outputs {
+		if o.closer != nil {
+			errs = append(errs, o.closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
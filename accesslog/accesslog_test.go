@@ -0,0 +1,95 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testEntry = Entry{
+	Time:           time.Date(2026, time.October, 19, 10, 30, 0, 0, time.UTC),
+	RequestId:      "abc123",
+	Remote:         "127.0.0.1:1447",
+	Method:         "GET",
+	URI:            "/hello?a=b",
+	Proto:          "HTTP/1.1",
+	Host:           "example.com",
+	Status:         200,
+	BytesIn:        3,
+	BytesOut:       5,
+	Referer:        "https://example.org",
+	UserAgent:      "test-agent",
+	Route:          "example.com",
+	Upstream:       "http://127.0.0.1:8080/hello?a=b",
+	UpstreamStatus: 200,
+	Duration:       25 * time.Millisecond,
+	RouteTime:      time.Millisecond,
+	UpstreamTime:   20 * time.Millisecond,
+}
+
+func TestWriteEntry(t *testing.T) {
+	var b bytes.Buffer
+	assert.NoError(t, writeEntry(&b, FormatCommon, testEntry))
+	assert.Equal(t, `127.0.0.1 - - [19/Oct/2026:10:30:00 +0000] "GET /hello?a=b HTTP/1.1" 200 5 request_id="abc123" host="example.com" route="example.com" upstream="http://127.0.0.1:8080/hello?a=b" upstream_status=200 duration=0.025 route_time=0.001 upstream_time=0.020`+"\n", b.String())
+
+	b.Reset()
+	assert.NoError(t, writeEntry(&b, FormatCombined, testEntry))
+	assert.Equal(t, `127.0.0.1 - - [19/Oct/2026:10:30:00 +0000] "GET /hello?a=b HTTP/1.1" 200 5 "https://example.org" "test-agent" request_id="abc123" host="example.com" route="example.com" upstream="http://127.0.0.1:8080/hello?a=b" upstream_status=200 duration=0.025 route_time=0.001 upstream_time=0.020`+"\n", b.String())
+
+	b.Reset()
+	assert.NoError(t, writeEntry(&b, FormatJSON, testEntry))
+	var j map[string]any
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &j))
+	assert.Equal(t, "abc123", j["request_id"])
+	assert.Equal(t, "example.com", j["route"])
+	assert.Equal(t, "http://127.0.0.1:8080/hello?a=b", j["upstream"])
+	assert.Equal(t, 200.0, j["upstream_status"])
+	assert.Equal(t, 0.025, j["duration"])
+	assert.Equal(t, 0.02, j["upstream_time"])
+}
+
+func TestClfHost(t *testing.T) {
+	assert.Equal(t, "127.0.0.1", clfHost("127.0.0.1:1447"))
+	assert.Equal(t, "::1", clfHost("[::1]:1447"))
+	assert.Equal(t, "127.0.0.1", clfHost("127.0.0.1"))
+	assert.Equal(t, "", clfHost(""))
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Output{Format: "xml"})
+	assert.Error(t, err)
+
+	p := filepath.Join(t.TempDir(), "access.log")
+	a, err := New(Output{Format: FormatCommon, Path: p, RotateEvery: time.Hour})
+	assert.NoError(t, err)
+	a.Log(testEntry)
+	a.Log(testEntry)
+	assert.NoError(t, a.Close())
+
+	raw, err := os.ReadFile(p)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, bytes.Count(raw, []byte("\n")))
+}
+
+func TestRotator_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	a, err := New(Output{Format: FormatJSON, Path: filepath.Join(dir, "access.log"), RotateEvery: 50 * time.Millisecond})
+	assert.NoError(t, err)
+	defer a.Close()
+	a.Log(testEntry)
+
+	assert.Eventually(t, func() bool {
+		files, err := os.ReadDir(dir)
+		return err == nil && len(files) > 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAccessLog_Nil(t *testing.T) {
+	var a *AccessLog
+	a.Log(testEntry)
+	assert.NoError(t, a.Close())
+}
@@ -0,0 +1,123 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clfTime is the time layout used by the common log format
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+// jsonEntry is the JSON representation of an Entry, durations are output in
+// seconds.
+type jsonEntry struct {
+	Time           time.Time `json:"time"`
+	RequestId      string    `json:"request_id,omitempty"`
+	Remote         string    `json:"remote"`
+	Method         string    `json:"method"`
+	URI            string    `json:"uri"`
+	Proto          string    `json:"proto"`
+	Host           string    `json:"host"`
+	Status         int       `json:"status"`
+	BytesIn        int64     `json:"bytes_in"`
+	BytesOut       int64     `json:"bytes_out"`
+	Referer        string    `json:"referer,omitempty"`
+	UserAgent      string    `json:"user_agent,omitempty"`
+	Route          string    `json:"route,omitempty"`
+	Upstream       string    `json:"upstream,omitempty"`
+	UpstreamStatus int       `json:"upstream_status,omitempty"`
+	Duration       float64   `json:"duration"`
+	RouteTime      float64   `json:"route_time"`
+	UpstreamTime   float64   `json:"upstream_time"`
+}
+
+// writeEntry writes a single line for the entry in the format.
+func writeEntry(w io.Writer, format Format, e Entry) error {
+	if format == FormatJSON {
+		return json.NewEncoder(w).Encode(jsonEntry{
+			Time:           e.Time,
+			RequestId:      e.RequestId,
+			Remote:         e.Remote,
+			Method:         e.Method,
+			URI:            e.URI,
+			Proto:          e.Proto,
+			Host:           e.Host,
+			Status:         e.Status,
+			BytesIn:        e.BytesIn,
+			BytesOut:       e.BytesOut,
+			Referer:        e.Referer,
+			UserAgent:      e.UserAgent,
+			Route:          e.Route,
+			Upstream:       e.Upstream,
+			UpstreamStatus: e.UpstreamStatus,
+			Duration:       e.Duration.Seconds(),
+			RouteTime:      e.RouteTime.Seconds(),
+			UpstreamTime:   e.UpstreamTime.Seconds(),
+		})
+	}
+
+	var b strings.Builder
+	b.WriteString(clfValue(clfHost(e.Remote)))
+	b.WriteString(" - - [")
+	b.WriteString(e.Time.Format(clfTime))
+	b.WriteString("] ")
+	b.WriteString(strconv.Quote(e.Method + " " + e.URI + " " + e.Proto))
+	b.WriteByte(' ')
+	b.WriteString(strconv.Itoa(e.Status))
+	b.WriteByte(' ')
+	if e.BytesOut == 0 {
+		b.WriteByte('-')
+	} else {
+		b.WriteString(strconv.FormatInt(e.BytesOut, 10))
+	}
+	if format == FormatCombined {
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(e.Referer))
+		b.WriteByte(' ')
+		b.WriteString(strconv.Quote(e.UserAgent))
+	}
+
+	// violet specific fields are appended after the standard fields
+	_, _ = fmt.Fprintf(&b, " request_id=%s host=%s route=%s upstream=%s upstream_status=%s duration=%.3f route_time=%.3f upstream_time=%.3f\n",
+		strconv.Quote(e.RequestId),
+		strconv.Quote(e.Host),
+		strconv.Quote(e.Route),
+		strconv.Quote(e.Upstream),
+		clfStatus(e.UpstreamStatus),
+		e.Duration.Seconds(),
+		e.RouteTime.Seconds(),
+		e.UpstreamTime.Seconds(),
+	)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// clfValue replaces an empty value with a dash
+func clfValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// clfHost removes the port from the remote address as the common log format
+// only contains the client host
+func clfHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// clfStatus outputs a dash when no upstream status is available
+func clfStatus(status int) string {
+	if status == 0 {
+		return "-"
+	}
+	return strconv.Itoa(status)
+}
@@ -0,0 +1,54 @@
+package accesslog
+
+import (
+	"gopkg.in/natefinch/lumberjack.v2"
+	"time"
+)
+
+// rotator wraps a lumberjack logger which handles size based rotation and
+// triggers a rotation on a fixed interval.
+type rotator struct {
+	*lumberjack.Logger
+	t    *time.Ticker
+	stop chan struct{}
+}
+
+func newRotator(o Output) *rotator {
+	r := &rotator{
+		Logger: &lumberjack.Logger{
+			Filename:   o.Path,
+			MaxSize:    o.MaxSize,
+			MaxBackups: o.MaxBackups,
+			MaxAge:     o.MaxAge,
+			LocalTime:  true,
+		},
+		stop: make(chan struct{}),
+	}
+	if o.RotateEvery > 0 {
+		r.t = time.NewTicker(o.RotateEvery)
+		go r.rotateLoop()
+	}
+	return r
+}
+
+func (r *rotator) rotateLoop() {
+	for {
+		select {
+		case <-r.t.C:
+			if err := r.Rotate(); err != nil {
+				Logger.Warn("Failed to rotate access log", "file", r.Filename, "err", err)
+			}
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// Close stops the rotation timer and closes the file.
+func (r *rotator) Close() error {
+	if r.t != nil {
+		r.t.Stop()
+		close(r.stop)
+	}
+	return r.Logger.Close()
+}
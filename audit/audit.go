@@ -0,0 +1,186 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/utils"
+	"time"
+)
+
+var Logger = logger.Logger.WithPrefix("Violet Audit")
+
+// Entry is a single configuration change made through the API
+type Entry struct {
+	ID     int64     `json:"id"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`  // subject of the token
+	Action string    `json:"action"` // e.g. create, update, delete or compile
+	Type   string    `json:"type"`   // e.g. domain, route or redirect
+	Key    string    `json:"key"`    // domain, source or host which was changed
+	Domain string    `json:"domain,omitempty"`
+
+	// Before and After are the JSON values before and after the change
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// Log stores the audit entries in the database. A nil Log records nothing.
+type Log struct {
+	db     *database.Queries
+	notify []func(Entry)
+}
+
+// New creates an audit log backed by the database
+func New(db *database.Queries) *Log {
+	return &Log{db: db}
+}
+
+// Record stores an entry for a change which has already been made. A failure
+// to record is logged instead of returned so the change isn't reported as
+// failed. The entry is still recorded if the request context is cancelled.
+func (l *Log) Record(ctx context.Context, actor, action, t, key string, before, after any) {
+	if l == nil {
+		return
+	}
+	l.add(context.WithoutCancel(ctx), actor, action, t, key, before, after)
+}
+
+// Change is a single item changed by a batch of changes
+type Change struct {
+	Action string
+	Type   string
+	Key    string
+	Before any
+	After  any
+}
+
+// RecordChanges stores an entry with the values before and after each change
+// made by an import
+func (l *Log) RecordChanges(ctx context.Context, actor string, changes []snapshot.Difference) {
+	batch := make([]Change, 0, len(changes))
+	for _, c := range changes {
+		batch = append(batch, Change{Action: string(c.Action), Type: c.Type, Key: c.Key, Before: c.Before, After: c.After})
+	}
+	l.RecordBatch(ctx, actor, batch)
+}
+
+// RecordBatch stores an entry for each change
+func (l *Log) RecordBatch(ctx context.Context, actor string, changes []Change) {
+	if l == nil || len(changes) == 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	for _, c := range changes {
+		l.add(ctx, actor, c.Action, c.Type, c.Key, c.Before, c.After)
+	}
+}
+
+// OnRecord adds a function called with each recorded entry, this must be
+// called before the log is used
+func (l *Log) OnRecord(fn func(Entry)) {
+	l.notify = append(l.notify, fn)
+}
+
+func (l *Log) add(ctx context.Context, actor, action, t, key string, before, after any) {
+	params := database.AddAuditEntryParams{
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+		Type:   t,
+		Key:    key,
+		Domain: KeyDomain(key),
+		Before: encodeValue(before),
+		After:  encodeValue(after),
+	}
+	err := l.db.AddAuditEntry(ctx, params)
+	if err != nil {
+		Logger.Warn("Failed to record audit entry", "action", action, "type", t, "key", key, "err", err)
+	}
+
+	if len(l.notify) == 0 {
+		return
+	}
+	e := Entry{
+		Time:   params.Time,
+		Actor:  actor,
+		Action: action,
+		Type:   t,
+		Key:    key,
+		Domain: params.Domain,
+		Before: rawValue(params.Before),
+		After:  rawValue(params.After),
+	}
+	for _, fn := range l.notify {
+		fn(e)
+	}
+}
+
+// List returns up to limit entries for the domains, newest first. Global
+// entries such as compiles are included if domains contains the empty string.
+// Only entries older than beforeId are returned if beforeId is not zero.
+func (l *Log) List(ctx context.Context, domains []string, beforeId int64, limit int) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	if l == nil || len(domains) == 0 {
+		return entries, nil
+	}
+	rows, err := l.db.GetAuditEntries(ctx, database.GetAuditEntriesParams{
+		Domains:  domains,
+		BeforeID: beforeId,
+		Limit:    int64(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		entries = append(entries, Entry{
+			ID:     row.ID,
+			Time:   row.Time,
+			Actor:  row.Actor,
+			Action: row.Action,
+			Type:   row.Type,
+			Key:    row.Key,
+			Domain: row.Domain,
+			Before: rawValue(row.Before),
+			After:  rawValue(row.After),
+		})
+	}
+	return entries, nil
+}
+
+// KeyDomain returns the top level domain of a host or source, global keys
+// return an empty string. Hosts without a public suffix such as localhost are
+// returned as they are so they aren't treated as global.
+func KeyDomain(key string) string {
+	if key == "" {
+		return ""
+	}
+	host, _ := utils.SplitHostPath(key)
+	if fqdn, ok := utils.GetTopFqdn(host); ok {
+		return fqdn
+	}
+	return host
+}
+
+// rawValue returns the stored JSON value or nil for NULL
+func rawValue(v sql.NullString) json.RawMessage {
+	if !v.Valid {
+		return nil
+	}
+	return json.RawMessage(v.String)
+}
+
+// encodeValue converts the value to JSON, nil is stored as NULL
+func encodeValue(v any) sql.NullString {
+	if v == nil {
+		return sql.NullString{}
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(raw), Valid: true}
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/1f349/violet"
+	"github.com/1f349/violet/snapshot"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLog_Record(t *testing.T) {
+	db, err := violet.InitDB("file:TestLog_Record?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	log := New(db)
+
+	log.Record(ctx, "alice", "create", "route", "example.com/a", nil, map[string]string{"dst": "127.0.0.1:8080"})
+	log.Record(ctx, "bob", "update", "route", "www.example.com/a", map[string]string{"dst": "127.0.0.1:8080"}, map[string]string{"dst": "127.0.0.1:8081"})
+	log.Record(ctx, "bob", "delete", "redirect", "example.org", nil, nil)
+	log.Record(ctx, "alice", "compile", "compile", "", nil, nil)
+
+	entries, err := log.List(ctx, []string{"example.com"}, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		// newest first
+		assert.Equal(t, "bob", entries[0].Actor)
+		assert.Equal(t, "update", entries[0].Action)
+		assert.Equal(t, "www.example.com/a", entries[0].Key)
+		assert.Equal(t, "example.com", entries[0].Domain)
+		assert.JSONEq(t, `{"dst":"127.0.0.1:8080"}`, string(entries[0].Before))
+		assert.JSONEq(t, `{"dst":"127.0.0.1:8081"}`, string(entries[0].After))
+		assert.False(t, entries[0].Time.IsZero())
+
+		assert.Equal(t, "create", entries[1].Action)
+		assert.Nil(t, entries[1].Before)
+	}
+
+	// global entries use an empty domain
+	entries, err = log.List(ctx, []string{"example.org", ""}, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "compile", entries[0].Action)
+		assert.Equal(t, "example.org", entries[1].Key)
+	}
+
+	// page through the entries
+	entries, err = log.List(ctx, []string{"example.com", "example.org", ""}, 0, 3)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+	entries, err = log.List(ctx, []string{"example.com", "example.org", ""}, entries[2].ID, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "example.com/a", entries[0].Key)
+	}
+
+	entries, err = log.List(ctx, nil, 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	raw, err := json.Marshal(entries)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", string(raw))
+}
+
+func TestLog_RecordChanges(t *testing.T) {
+	db, err := violet.InitDB("file:TestLog_RecordChanges?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	log := New(db)
+
+	r, err := snapshot.Import(ctx, db, &snapshot.Snapshot{Routes: []snapshot.Route{{Src: "example.com/a", Dst: "127.0.0.1:8080", Active: true}}}, nil, snapshot.ImportOptions{})
+	assert.NoError(t, err)
+	log.RecordChanges(ctx, "alice", r.Differences)
+	r, err = snapshot.Import(ctx, db, &snapshot.Snapshot{Routes: []snapshot.Route{{Src: "example.com/a", Dst: "127.0.0.1:8081", Active: true}}}, nil, snapshot.ImportOptions{})
+	assert.NoError(t, err)
+	log.RecordChanges(ctx, "bob", r.Differences)
+
+	// the values before and after each change are stored
+	entries, err := log.List(ctx, []string{"example.com"}, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "update", entries[0].Action)
+		assert.JSONEq(t, `{"src":"example.com/a","dst":"127.0.0.1:8080","active":true}`, string(entries[0].Before))
+		assert.JSONEq(t, `{"src":"example.com/a","dst":"127.0.0.1:8081","active":true}`, string(entries[0].After))
+		assert.Equal(t, "create", entries[1].Action)
+		assert.Nil(t, entries[1].Before)
+		assert.JSONEq(t, `{"src":"example.com/a","dst":"127.0.0.1:8080","active":true}`, string(entries[1].After))
+	}
+}
+
+func TestLog_Nil(t *testing.T) {
+	var log *Log
+	log.Record(context.Background(), "alice", "compile", "compile", "", nil, nil)
+	entries, err := log.List(context.Background(), []string{""}, 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestKeyDomain(t *testing.T) {
+	assert.Equal(t, "example.com", KeyDomain("example.com"))
+	assert.Equal(t, "example.com", KeyDomain("www.example.com/a/b"))
+	assert.Equal(t, "example.com", KeyDomain("*.example.com"))
+	assert.Equal(t, "localhost", KeyDomain("localhost/a"))
+	assert.Equal(t, "", KeyDomain(""))
+}
@@ -5,12 +5,14 @@ import (
 	"crypto/x509/pkix"
 	"fmt"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
 	"github.com/1f349/violet/utils"
 	"github.com/mrmelon54/certgen"
 	"github.com/mrmelon54/rescheduler"
 	"io/fs"
 	"math/big"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -31,6 +33,17 @@ type Certs struct {
 	r    *rescheduler.Rescheduler
 	t    *time.Ticker
 	ts   chan struct{}
+	*utils.CompileTracker
+
+	// notify is called with the certificates changed by each compile
+	notify []func(CertChange)
+}
+
+// CertChange describes a certificate loaded, replaced or removed by a compile
+type CertChange struct {
+	Domain   string    `json:"domain"`
+	Action   string    `json:"action"` // create, update, delete or expiring
+	NotAfter time.Time `json:"not_after,omitempty"`
 }
 
 // New creates a new cert list
@@ -42,6 +55,8 @@ func New(certDir fs.FS, keyDir fs.FS, selfCert bool) *Certs {
 		s:    &sync.RWMutex{},
 		m:    make(map[string]*tls.Certificate),
 		ts:   make(chan struct{}, 1),
+
+		CompileTracker: utils.NewCompileTracker("certs"),
 	}
 
 	if !selfCert {
@@ -121,6 +136,23 @@ func (c *Certs) GetCertForDomain(domain string) *tls.Certificate {
 	return nil
 }
 
+// CountCertsForDomain returns the number of loaded certificates which contain
+// the domain or any subdomain as a DNS name.
+func (c *Certs) CountCertsForDomain(domain string) int {
+	// safety read lock
+	c.s.RLock()
+	defer c.s.RUnlock()
+
+	// certificates are stored under each DNS name so count unique certificates
+	found := make(map[*tls.Certificate]struct{})
+	for name, cert := range c.m {
+		if utils.IsDomainOrSubdomain(name, domain) {
+			found[cert] = struct{}{}
+		}
+	}
+	return len(found)
+}
+
 // Compile loads the certificates and keys from the directories.
 //
 // This method makes use of the rescheduler instead of just ignoring multiple
@@ -128,6 +160,7 @@ func (c *Certs) GetCertForDomain(domain string) *tls.Certificate {
 func (c *Certs) Compile() {
 	// don't bother compiling in self-signed mode
 	if c.ss {
+		c.FinishCompile(time.Now(), 0, nil)
 		return
 	}
 	c.r.Run()
@@ -141,20 +174,86 @@ func (c *Certs) Stop() {
 }
 
 func (c *Certs) threadCompile() {
+	start := time.Now()
+
 	// new map
 	certMap := make(map[string]*tls.Certificate)
 
 	// compile map and check errors
 	err := c.internalCompile(certMap)
+	metrics.ObserveCompile("certs", start, err)
 	if err != nil {
 		Logger.Infof("Compile failed: %s\n", err)
+		c.FinishCompile(start, 0, err)
 		return
 	}
 
 	// lock while replacing the map
 	c.s.Lock()
+	changes := certChanges(c.m, certMap)
 	c.m = certMap
+	notify := c.notify
 	c.s.Unlock()
+	c.FinishCompile(start, len(certMap), nil)
+
+	for _, change := range changes {
+		for _, fn := range notify {
+			fn(change)
+		}
+	}
+
+	// update the expiry times of the loaded certificates
+	metrics.CertificateExpiry.Reset()
+	for domain, cert := range certMap {
+		metrics.CertificateExpiry.WithLabelValues(domain).Set(float64(certgen.TlsLeaf(cert).NotAfter.Unix()))
+	}
+}
+
+// OnChange adds a function called for each certificate loaded, replaced or
+// removed by a compile
+func (c *Certs) OnChange(fn func(CertChange)) {
+	c.s.Lock()
+	defer c.s.Unlock()
+	c.notify = append(c.notify, fn)
+}
+
+// certChanges compares the certificates loaded for each domain
+func certChanges(prev, next map[string]*tls.Certificate) []CertChange {
+	var changes []CertChange
+	for domain, cert := range next {
+		leaf := certgen.TlsLeaf(cert)
+		old, ok := prev[domain]
+		switch {
+		case !ok:
+			changes = append(changes, CertChange{Domain: domain, Action: "create", NotAfter: leaf.NotAfter})
+		case certgen.TlsLeaf(old).SerialNumber.Cmp(leaf.SerialNumber) != 0:
+			changes = append(changes, CertChange{Domain: domain, Action: "update", NotAfter: leaf.NotAfter})
+		}
+	}
+	for domain := range prev {
+		if _, ok := next[domain]; !ok {
+			changes = append(changes, CertChange{Domain: domain, Action: "delete"})
+		}
+	}
+	slices.SortFunc(changes, func(a, b CertChange) int { return strings.Compare(a.Domain, b.Domain) })
+	return changes
+}
+
+// ExpiringBefore returns the loaded certificates which expire before the time
+// sorted by domain
+func (c *Certs) ExpiringBefore(t time.Time) []CertChange {
+	// safety read lock
+	c.s.RLock()
+	defer c.s.RUnlock()
+
+	var expiring []CertChange
+	for domain, cert := range c.m {
+		if notAfter := certgen.TlsLeaf(cert).NotAfter; notAfter.Before(t) {
+			expiring = append(expiring, CertChange{Domain: domain, Action: "expiring", NotAfter: notAfter})
+		}
+	}
+	slices.SortFunc(expiring, func(a, b CertChange) int { return strings.Compare(a.Domain, b.Domain) })
+	return expiring
 }
 
 // internalCompile is a hidden internal method for loading the certificate and
@@ -1,11 +1,14 @@
 package certs
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
 	"github.com/mrmelon54/certgen"
 	"github.com/stretchr/testify/assert"
 	"math/big"
+	"sync"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -60,6 +63,9 @@ func TestCertsNew_Lookup(t *testing.T) {
 
 	// this cert doesn't exist
 	assert.Nil(t, certs.GetCertForDomain("notexample.com"))
+
+	assert.Equal(t, 1, certs.CountCertsForDomain("example.com"))
+	assert.Equal(t, 0, certs.CountCertsForDomain("notexample.com"))
 }
 
 func TestCertsNew_SelfSigned(t *testing.T) {
@@ -76,3 +82,35 @@ func TestCertsNew_SelfSigned(t *testing.T) {
 	leaf2 := certgen.TlsLeaf(cc2)
 	assert.Equal(t, []string{"notexample.com"}, leaf2.DNSNames)
 }
+
+func TestCertChanges(t *testing.T) {
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	cert := func(sn int64) *tls.Certificate {
+		return &tls.Certificate{Leaf: &x509.Certificate{SerialNumber: big.NewInt(sn), NotAfter: expiry}}
+	}
+	a, b := cert(1), cert(2)
+
+	prev := map[string]*tls.Certificate{"example.com": a, "www.example.com": a, "old.example.com": a}
+	next := map[string]*tls.Certificate{"example.com": a, "www.example.com": b, "new.example.com": b}
+	assert.Equal(t, []CertChange{
+		{Domain: "new.example.com", Action: "create", NotAfter: expiry},
+		{Domain: "old.example.com", Action: "delete"},
+		{Domain: "www.example.com", Action: "update", NotAfter: expiry},
+	}, certChanges(prev, next))
+	assert.Empty(t, certChanges(next, next))
+}
+
+func TestCerts_ExpiringBefore(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	cert := func(notAfter time.Time) *tls.Certificate {
+		return &tls.Certificate{Leaf: &x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: notAfter}}
+	}
+	soon, later := cert(now.Add(24*time.Hour)), cert(now.Add(90*24*time.Hour))
+
+	c := &Certs{s: &sync.RWMutex{}, m: map[string]*tls.Certificate{"www.example.com": soon, "example.com": soon, "example.org": later}}
+	assert.Equal(t, []CertChange{
+		{Domain: "example.com", Action: "expiring", NotAfter: now.Add(24 * time.Hour)},
+		{Domain: "www.example.com", Action: "expiring", NotAfter: now.Add(24 * time.Hour)},
+	}, c.ExpiringBefore(now.Add(14*24*time.Hour)))
+	assert.Empty(t, c.ExpiringBefore(now))
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/domains"
+	"github.com/1f349/violet/proxy"
+	"github.com/1f349/violet/proxy/websocket"
+	"github.com/1f349/violet/router"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/utils"
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/google/subcommands"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type applyCmd struct {
+	configPath string
+	apiUrl     string
+	token      string
+	prune      bool
+	planOnly   bool
+	yes        bool
+}
+
+func (a *applyCmd) Name() string     { return "apply" }
+func (a *applyCmd) Synopsis() string { return "Apply a declarative routing configuration" }
+func (a *applyCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&a.configPath, "conf", "", "/path/to/config.json : path to the config file of a local database")
+	f.StringVar(&a.apiUrl, "api", "", "url of a remote Violet API to apply the changes through")
+	f.StringVar(&a.token, "token", os.Getenv("VIOLET_TOKEN"), "bearer token for the remote API (defaults to $VIOLET_TOKEN)")
+	f.BoolVar(&a.prune, "prune", false, "delete domains, routes and redirects missing from the file")
+	f.BoolVar(&a.planOnly, "plan", false, "only print the plan")
+	f.BoolVar(&a.yes, "yes", false, "apply the plan without asking")
+}
+func (a *applyCmd) Usage() string {
+	return `apply (-conf <config file> | -api <url> [-token <token>]) [-prune] [-plan] [-yes] <yaml file>
+  Compare the domains, routes and redirects in the file against the database
+  or remote API, print the plan and then apply the changes
+`
+}
+
+func (a *applyCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() != 1 || (a.configPath == "") == (a.apiUrl == "") {
+		fmt.Print(a.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	raw, err := os.ReadFile(f.Arg(0))
+	if err != nil {
+		fmt.Println("Failed to read input file: ", err)
+		return subcommands.ExitFailure
+	}
+	desired, err := snapshot.Decode(bytes.NewReader(raw), snapshot.FormatYaml)
+	if err != nil {
+		fmt.Println("Failed to decode input file: ", err)
+		return subcommands.ExitFailure
+	}
+	if desired.Favicons != nil {
+		fmt.Println("Error: apply does not manage favicons, use the import subcommand instead")
+		return subcommands.ExitFailure
+	}
+
+	var target applyTarget
+	if a.apiUrl != "" {
+		target = &remoteApply{apiUrl: strings.TrimSuffix(a.apiUrl, "/"), token: a.token, raw: raw, prune: a.prune}
+	} else {
+		db, err := openConfigDB(a.configPath)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			return subcommands.ExitFailure
+		}
+		history, err := openConfigHistory(a.configPath, db)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			return subcommands.ExitFailure
+		}
+		manager := router.NewManager(db, proxy.NewHybridTransportWithCalls(&nilTransport{}, &nilTransport{}, &websocket.Server{}))
+		target = &localApply{desired: desired, prune: a.prune, db: db, history: history, compilers: []utils.CompileReporter{manager, domains.New(db)}}
+	}
+
+	plan, err := target.Plan(ctx)
+	if err != nil {
+		fmt.Println("Failed to plan changes: ", err)
+		return subcommands.ExitFailure
+	}
+	printPlan(plan)
+	if len(plan.Changes) == 0 || a.planOnly {
+		return subcommands.ExitSuccess
+	}
+
+	if !a.yes {
+		confirm := false
+		err = survey.AskOne(&survey.Confirm{Message: "Apply these changes?"}, &confirm)
+		if err != nil {
+			fmt.Println("Error: ", err)
+			return subcommands.ExitFailure
+		}
+		if !confirm {
+			fmt.Println("Nothing was changed")
+			return subcommands.ExitSuccess
+		}
+	}
+
+	applied, err := target.Apply(ctx, plan)
+	if err != nil {
+		fmt.Println("Failed to apply changes: ", err)
+		return subcommands.ExitFailure
+	}
+	fmt.Printf("Applied %d changes\n", len(applied.Changes))
+	if a.apiUrl == "" {
+		// the running server only reads the database when compiling
+		fmt.Println("Call POST /compile on the running Violet API to load the changes")
+	}
+	return subcommands.ExitSuccess
+}
+
+// printPlan outputs each change with a symbol for the action
+func printPlan(plan *snapshot.Result) {
+	for _, c := range plan.Changes {
+		symbol := "~"
+		switch c.Action {
+		case snapshot.ActionCreate:
+			symbol = "+"
+		case snapshot.ActionDelete:
+			symbol = "-"
+		}
+		fmt.Printf("%s %s %s\n", symbol, c.Type, c.Key)
+	}
+	fmt.Printf("Plan: %d to change, %d unchanged\n", len(plan.Changes), plan.Unchanged)
+}
+
+// applyTarget is the database or remote API being reconciled
+type applyTarget interface {
+	Plan(ctx context.Context) (*snapshot.Result, error)
+
+	// Apply makes the confirmed changes and fails with snapshot.ErrPlanChanged
+	// if the configuration changed since the plan was made
+	Apply(ctx context.Context, plan *snapshot.Result) (*snapshot.Result, error)
+}
+
+// localApply reconciles the database using the same transactional import as
+// the API, the changes are recorded in the audit log and history and compiled
+// once to check they load. The running server must be told to compile
+// afterwards.
+type localApply struct {
+	desired   *snapshot.Snapshot
+	prune     bool
+	db        *database.Queries
+	history   *snapshot.History
+	compilers []utils.CompileReporter
+}
+
+func (l *localApply) Plan(ctx context.Context) (*snapshot.Result, error) {
+	return snapshot.Import(ctx, l.db, l.desired, nil, snapshot.ImportOptions{DryRun: true, Prune: l.prune})
+}
+
+func (l *localApply) Apply(ctx context.Context, plan *snapshot.Result) (*snapshot.Result, error) {
+	result, err := snapshot.Import(ctx, l.db, l.desired, nil, snapshot.ImportOptions{
+		Prune:    l.prune,
+		Record:   l.history.Recorder(ctx, cliActor, "apply"),
+		Expected: plan.Differences,
+	})
+	if err != nil {
+		return nil, err
+	}
+	audit.New(l.db).RecordChanges(ctx, cliActor, result.Differences)
+	return result, l.compile(ctx)
+}
+
+// compile runs a single compile of the applied changes and waits for it to
+// finish
+func (l *localApply) compile(ctx context.Context) error {
+	start := time.Now()
+	for _, i := range l.compilers {
+		i.Compile()
+	}
+	for _, i := range l.compilers {
+		status, err := i.WaitCompile(ctx, start)
+		if err != nil {
+			return err
+		}
+		if !status.Success {
+			return fmt.Errorf("changes were applied but the %s failed to compile: %s", status.Name, status.Error)
+		}
+	}
+	return nil
+}
+
+// remoteApply reconciles through the import endpoint of the API which applies
+// the changes in a single transaction and compiles once
+type remoteApply struct {
+	apiUrl string
+	token  string
+	raw    []byte
+	prune  bool
+}
+
+func (r *remoteApply) Plan(ctx context.Context) (*snapshot.Result, error) {
+	return r.importRequest(ctx, true)
+}
+
+// Apply makes a fresh plan and aborts if it differs from the confirmed plan as
+// the import endpoint only receives the file
+func (r *remoteApply) Apply(ctx context.Context, plan *snapshot.Result) (*snapshot.Result, error) {
+	fresh, err := r.importRequest(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Equal(fresh.Changes, plan.Changes) {
+		return nil, snapshot.ErrPlanChanged
+	}
+	return r.importRequest(ctx, false)
+}
+
+func (r *remoteApply) importRequest(ctx context.Context, dryRun bool) (*snapshot.Result, error) {
+	q := url.Values{}
+	q.Set("format", string(snapshot.FormatYaml))
+	q.Set("dry_run", strconv.FormatBool(dryRun))
+	q.Set("prune", strconv.FormatBool(r.prune))
+
+	var result snapshot.Result
+	err := apiRequest(ctx, http.MethodPost, r.apiUrl+"/import?"+q.Encode(), r.token, snapshot.FormatYaml.ContentType(), r.raw, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// apiRequest sends a request to the Violet API and decodes the JSON response
+// into out or returns the error message from the API
+func apiRequest(ctx context.Context, method, u, token, contentType string, body []byte, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(res.Body).Decode(&apiErr) != nil || apiErr.Error == "" {
+			apiErr.Error = res.Status
+		}
+		return errors.New(apiErr.Error)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
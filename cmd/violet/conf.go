@@ -1,12 +1,37 @@
 package main
 
+import (
+	"encoding/json"
+	"github.com/1f349/violet/accesslog"
+	"github.com/1f349/violet/proxy/websocket"
+	"github.com/1f349/violet/tracing"
+	"github.com/1f349/violet/webhook"
+	"net/netip"
+	"time"
+)
+
 type startUpConfig struct {
-	SelfSigned    bool         `json:"self_signed"`
-	ErrorPagePath string       `json:"error_page_path"`
-	Listen        listenConfig `json:"listen"`
-	InkscapeCmd   string       `json:"inkscape"`
-	RateLimit     uint64       `json:"rate_limit"`
-	MetricsToken  string       `json:"metrics_token"`
+	SelfSigned    bool              `json:"self_signed"`
+	ErrorPagePath string            `json:"error_page_path"`
+	Listen        listenConfig      `json:"listen"`
+	InkscapeCmd   string            `json:"inkscape"`
+	RateLimit     uint64            `json:"rate_limit"`
+	MetricsToken  string            `json:"metrics_token"`
+	Websocket     websocketConfig   `json:"websocket"`
+	Shutdown      shutdownConfig    `json:"shutdown"`
+	AccessLog     []accessLogConfig `json:"access_log"`
+	HistoryLimit  int               `json:"history_limit"` // configuration versions kept
+
+	// TrustRequestId lists the client networks allowed to set X-Request-Id,
+	// use "0.0.0.0/0" and "::/0" to trust all clients
+	TrustRequestId []netip.Prefix `json:"trust_request_id"`
+
+	// TrustTiming lists the client networks which receive the
+	// X-Violet-Upstream-Time header
+	TrustTiming []netip.Prefix `json:"trust_timing"`
+
+	Tracing  tracingConfig `json:"tracing"`
+	Webhooks webhookConfig `json:"webhooks"`
 }
 
 type listenConfig struct {
@@ -14,3 +39,127 @@ type listenConfig struct {
 	Http  string `json:"http"`
 	Https string `json:"https"`
 }
+
+type websocketConfig struct {
+	IdleTimeout    duration `json:"idle_timeout"`
+	PingInterval   duration `json:"ping_interval"`
+	MaxMessageSize int64    `json:"max_message_size"`
+	MessageRate    float64  `json:"message_rate"`
+	MessageBurst   int      `json:"message_burst"`
+}
+
+type accessLogConfig struct {
+	Format      accesslog.Format `json:"format"`       // json, common or combined
+	Path        string           `json:"path"`         // file path, stdout or stderr
+	MaxSize     int              `json:"max_size"`     // megabytes
+	MaxBackups  int              `json:"max_backups"`  // number of rotated files
+	MaxAge      int              `json:"max_age"`      // days
+	RotateEvery duration         `json:"rotate_every"` // time between rotations
+}
+
+func (a accessLogConfig) Output() accesslog.Output {
+	return accesslog.Output{
+		Format:      a.Format,
+		Path:        a.Path,
+		MaxSize:     a.MaxSize,
+		MaxBackups:  a.MaxBackups,
+		MaxAge:      a.MaxAge,
+		RotateEvery: time.Duration(a.RotateEvery),
+	}
+}
+
+type tracingConfig struct {
+	Endpoint    string            `json:"endpoint"` // OTLP/HTTP collector url, tracing is disabled if empty
+	Headers     map[string]string `json:"headers"`
+	ServiceName string            `json:"service_name"`
+	SampleRatio float64           `json:"sample_ratio"`
+}
+
+func (t tracingConfig) Options() tracing.Options {
+	return tracing.Options{
+		Endpoint:    t.Endpoint,
+		Headers:     t.Headers,
+		ServiceName: t.ServiceName,
+		SampleRatio: t.SampleRatio,
+	}
+}
+
+type webhookConfig struct {
+	Endpoints      []webhook.Endpoint `json:"endpoints"`        // webhooks are disabled if empty
+	MaxAttempts    int                `json:"max_attempts"`     // attempts before a delivery is dropped
+	Backoff        duration           `json:"backoff"`          // delay before the first retry
+	CertExpiry     duration           `json:"cert_expiry"`      // report certificates expiring within this time
+	RateLimitSpike uint64             `json:"rate_limit_spike"` // rate limited requests per minute reported as a spike
+	KeepDeliveries int                `json:"keep_deliveries"`  // delivery attempts kept in the log
+}
+
+func (w webhookConfig) Options() webhook.Options {
+	return webhook.Options{
+		Endpoints:      w.Endpoints,
+		MaxAttempts:    w.MaxAttempts,
+		Backoff:        time.Duration(w.Backoff),
+		CertExpiry:     time.Duration(w.CertExpiry),
+		RateLimitSpike: w.RateLimitSpike,
+		KeepDeliveries: w.KeepDeliveries,
+	}
+}
+
+type shutdownConfig struct {
+	// DrainDelay is the time between reporting not ready and closing the
+	// listeners when terminating, this gives load balancers time to stop
+	// sending requests. Upgrades skip the delay as the new process takes over
+	// the listeners. This defaults to 5 seconds and a negative value disables
+	// the delay.
+	DrainDelay duration `json:"drain_delay"`
+
+	// GracePeriod is the time allowed for in-flight requests and websockets to
+	// finish before they are forcefully closed
+	GracePeriod duration `json:"grace_period"`
+}
+
+// drainDelay returns the configured drain delay or the default of 5 seconds
+func (s shutdownConfig) drainDelay() time.Duration {
+	if s.DrainDelay == 0 {
+		return 5 * time.Second
+	}
+	return max(time.Duration(s.DrainDelay), 0)
+}
+
+// gracePeriod returns the configured grace period or the default of 30 seconds
+func (s shutdownConfig) gracePeriod() time.Duration {
+	if s.GracePeriod <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(s.GracePeriod)
+}
+
+func (w websocketConfig) Options() websocket.Options {
+	return websocket.Options{
+		IdleTimeout:    time.Duration(w.IdleTimeout),
+		PingInterval:   time.Duration(w.PingInterval),
+		MaxMessageSize: w.MaxMessageSize,
+		MessageRate:    w.MessageRate,
+		MessageBurst:   w.MessageBurst,
+	}
+}
+
+// duration is a time.Duration stored in the config file as a string such as
+// "30s" or "5m"
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	a, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(a)
+	return nil
+}
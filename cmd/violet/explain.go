@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/1f349/violet/router"
+	"github.com/google/subcommands"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+type explainCmd struct {
+	apiUrl string
+	token  string
+}
+
+func (e *explainCmd) Name() string     { return "explain" }
+func (e *explainCmd) Synopsis() string { return "Explain which redirect or route matches a url" }
+func (e *explainCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&e.apiUrl, "api", "", "url of the Violet API")
+	f.StringVar(&e.token, "token", os.Getenv("VIOLET_TOKEN"), "bearer token for the API (defaults to $VIOLET_TOKEN)")
+}
+func (e *explainCmd) Usage() string {
+	return `explain -api <url> [-token <token>] <url>
+  Look up the url in the live router and list the candidates considered
+`
+}
+
+func (e *explainCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() != 1 || e.apiUrl == "" {
+		fmt.Print(e.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	var ex router.Explanation
+	u := strings.TrimSuffix(e.apiUrl, "/") + "/explain?url=" + url.QueryEscape(f.Arg(0))
+	if err := apiRequest(ctx, http.MethodGet, u, e.token, "", nil, &ex); err != nil {
+		fmt.Println("Failed to explain url: ", err)
+		return subcommands.ExitFailure
+	}
+
+	fmt.Printf("Lookup %s%s\n", ex.Host, ex.Path)
+	for _, c := range ex.Candidates {
+		fmt.Printf("  %s %s%s -> %s (flags %d)\n", c.Type, c.Host, c.Path, c.Dst, c.Flags)
+		if c.Reason != "" {
+			fmt.Printf("    skipped: %s\n", c.Reason)
+		}
+	}
+	switch {
+	case ex.Match == nil:
+		fmt.Println("No redirect or route matches")
+	case ex.Match.Type == "redirect":
+		fmt.Printf("Redirect %d to %s\n", ex.Code, ex.Upstream)
+	default:
+		fmt.Printf("Proxy to %s\n", ex.Upstream)
+	}
+	return subcommands.ExitSuccess
+}
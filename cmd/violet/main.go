@@ -15,6 +15,10 @@ func main() {
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(&serveCmd{}, "")
 	subcommands.Register(&setupCmd{}, "")
+	subcommands.Register(&exportCmd{}, "")
+	subcommands.Register(&importCmd{}, "")
+	subcommands.Register(&applyCmd{}, "")
+	subcommands.Register(&explainCmd{}, "")
 
 	flag.Parse()
 	ctx := context.Background()
@@ -6,9 +6,12 @@ import (
 	"flag"
 	"github.com/1f349/mjwt"
 	"github.com/1f349/violet"
+	"github.com/1f349/violet/accesslog"
+	"github.com/1f349/violet/audit"
 	"github.com/1f349/violet/certs"
 	"github.com/1f349/violet/domains"
 	errorPages "github.com/1f349/violet/error-pages"
+	"github.com/1f349/violet/events"
 	"github.com/1f349/violet/favicons"
 	"github.com/1f349/violet/logger"
 	"github.com/1f349/violet/proxy"
@@ -17,7 +20,11 @@ import (
 	"github.com/1f349/violet/servers"
 	"github.com/1f349/violet/servers/api"
 	"github.com/1f349/violet/servers/conf"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/tail"
+	"github.com/1f349/violet/tracing"
 	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/webhook"
 	"github.com/charmbracelet/log"
 	"github.com/cloudflare/tableflip"
 	"github.com/google/subcommands"
@@ -27,6 +34,8 @@ import (
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -85,6 +94,10 @@ func (s *serveCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...any) subcomm
 		logger.Logger.Info("Error: invalid config file: ", err)
 		return subcommands.ExitFailure
 	}
+	if err := config.Webhooks.Options().Validate(); err != nil {
+		logger.Logger.Info("Error: invalid config file: ", err)
+		return subcommands.ExitFailure
+	}
 
 	// working directory is the parent of the config file
 	wd := filepath.Dir(s.configPath)
@@ -128,7 +141,29 @@ func (s *serveCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...any) subcomm
 	certDir := os.DirFS(filepath.Join(wd, "certs"))
 	keyDir := os.DirFS(filepath.Join(wd, "keys"))
 
-	ws := websocket.NewServer()
+	// export spans to the tracing collector
+	stopTracing := func(context.Context) error { return nil }
+	if config.Tracing.Endpoint != "" {
+		stopTracing, err = tracing.Setup(config.Tracing.Options())
+		if err != nil {
+			logger.Logger.Fatal("Failed to setup tracing", "err", err)
+		}
+	}
+
+	// open the access log outputs
+	var accessLog *accesslog.AccessLog
+	if len(config.AccessLog) > 0 {
+		outputs := make([]accesslog.Output, 0, len(config.AccessLog))
+		for _, i := range config.AccessLog {
+			outputs = append(outputs, i.Output())
+		}
+		accessLog, err = accesslog.New(outputs...)
+		if err != nil {
+			logger.Logger.Fatal("Failed to open access log", "err", err)
+		}
+	}
+
+	ws := websocket.NewServerWithOptions(config.Websocket.Options())
 	allowedDomains := domains.New(db)                             // load allowed domains
 	acmeChallenges := utils.NewAcmeChallenge()                    // load acme challenge store
 	allowedCerts := certs.New(certDir, keyDir, config.SelfSigned) // load certificate manager
@@ -136,6 +171,18 @@ func (s *serveCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...any) subcomm
 	dynamicFavicons := favicons.New(db, config.InkscapeCmd)       // load dynamic favicon provider
 	dynamicErrorPages := errorPages.New(errorPageDir)             // load dynamic error page provider
 	dynamicRouter := router.NewManager(db, hybridTransport)       // load dynamic router manager
+	configHistory := snapshot.NewHistory(db, config.HistoryLimit) // load configuration history
+
+	// record the starting configuration including changes made while stopped
+	if _, err := configHistory.Record(context.Background(), "violet", "startup"); err != nil {
+		logger.Logger.Warn("Failed to record configuration history", "err", err)
+	}
+
+	// send events to the configured webhooks
+	var webhooks *webhook.Dispatcher
+	if len(config.Webhooks.Endpoints) > 0 {
+		webhooks = webhook.New(db, config.Webhooks.Options())
+	}
 
 	// struct containing config for the http servers
 	srvConf := &conf.Conf{
@@ -148,10 +195,40 @@ func (s *serveCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...any) subcomm
 		Signer:     keystore,
 		ErrorPages: dynamicErrorPages,
 		Router:     dynamicRouter,
+		Websocket:  ws,
+		Ready:      new(utils.Readiness),
+		AccessLog:  accessLog,
+		Tail:       tail.New(),
+		Audit:      audit.New(db),
+		History:    configHistory,
+		Events:     events.New(1000),
+		Webhooks:   webhooks,
+
+		TrustRequestId: config.TrustRequestId,
+		TrustTiming:    config.TrustTiming,
 	}
 
 	// create the compilable list and run a first time compile
 	allCompilables := utils.MultiCompilable{allowedDomains, allowedCerts, dynamicFavicons, dynamicErrorPages, dynamicRouter}
+
+	// publish configuration changes to the event stream
+	srvConf.Events.WatchAudit(srvConf.Audit)
+	srvConf.Events.WatchCerts(allowedCerts)
+	for _, i := range allCompilables {
+		if r, ok := i.(utils.CompileReporter); ok {
+			srvConf.Events.WatchCompile(r)
+		}
+	}
+
+	// send proxy events to the webhooks
+	webhooks.WatchAudit(srvConf.Audit)
+	webhooks.WatchCerts(allowedCerts)
+	webhooks.WatchBackends(hybridTransport)
+	for _, i := range allCompilables {
+		if r, ok := i.(utils.CompileReporter); ok {
+			webhooks.WatchCompile(r)
+		}
+	}
 	allCompilables.Compile()
 
 	_, httpsPort, ok := utils.SplitDomainPort(config.Listen.Https, 443)
@@ -216,13 +293,34 @@ func (s *serveCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...any) subcomm
 		}
 	}()
 
+	// Stop on SIGINT or SIGTERM
+	var terminated atomic.Bool
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+		terminated.Store(true)
+		upg.Stop()
+	}()
+
 	logger.Logger.Info("Ready")
 	if err := upg.Ready(); err != nil {
 		panic(err)
 	}
 	<-upg.Exit()
 
-	time.AfterFunc(30*time.Second, func() {
+	// after an upgrade the new process is already accepting on the same
+	// listeners so only report not ready when terminating, this gives load
+	// balancers time to stop sending new requests
+	if terminated.Load() {
+		drainDelay := config.Shutdown.drainDelay()
+		logger.Logger.Info("Draining", "delay", drainDelay)
+		srvConf.Ready.Drain()
+		time.Sleep(drainDelay)
+	}
+
+	gracePeriod := config.Shutdown.gracePeriod()
+	time.AfterFunc(gracePeriod+10*time.Second, func() {
 		logger.Logger.Warn("Graceful shutdown timed out")
 		os.Exit(1)
 	})
@@ -230,22 +328,52 @@ func (s *serveCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...any) subcomm
 	// stop updating certificates
 	allowedCerts.Stop()
 
-	// close websockets first
-	ws.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), gracePeriod)
+	defer cancel()
 
-	// close http servers
+	// end the live tail and event streams so they don't hold the api server open
+	srvConf.Tail.Close()
+	srvConf.Events.Close()
+
+	// collect all the http servers
+	allServers := make([]*http.Server, 0, len(srvHttp)+len(srvHttps)+1)
 	if srvApi != nil {
-		_ = srvApi.Close()
+		allServers = append(allServers, srvApi)
 	}
-	for _, srv := range srvHttp {
-		if srv != nil {
-			_ = srv.Close()
+	allServers = append(allServers, srvHttp...)
+	allServers = append(allServers, srvHttps...)
+
+	// stop accepting connections and wait for in-flight requests and
+	// websockets to finish
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		if err := ws.Shutdown(ctx); err != nil {
+			logger.Logger.Warn("Websockets did not close in time", "err", err)
 		}
+	})
+	for _, srv := range allServers {
+		wg.Go(func() {
+			if err := srv.Shutdown(ctx); err != nil {
+				logger.Logger.Warn("Requests did not finish in time", "err", err)
+				_ = srv.Close()
+			}
+		})
 	}
-	for _, srv := range srvHttps {
-		if srv != nil {
-			_ = srv.Close()
-		}
+	wg.Wait()
+
+	// cancel the pending webhook retries
+	webhooks.Close()
+
+	// flush the access log files
+	if err := accessLog.Close(); err != nil {
+		logger.Logger.Warn("Failed to close access log", "err", err)
+	}
+
+	// flush the remaining spans
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := stopTracing(flushCtx); err != nil {
+		logger.Logger.Warn("Failed to flush spans", "err", err)
 	}
 
 	return subcommands.ExitSuccess
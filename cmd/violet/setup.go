@@ -11,6 +11,7 @@ import (
 	"github.com/1f349/violet/proxy"
 	"github.com/1f349/violet/proxy/websocket"
 	"github.com/1f349/violet/router"
+	"github.com/1f349/violet/snapshot"
 	"github.com/1f349/violet/target"
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/google/subcommands"
@@ -21,6 +22,7 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
+	"time"
 )
 
 type setupCmd struct {
@@ -138,8 +140,13 @@ func (s *setupCmd) Execute(_ context.Context, _ *flag.FlagSet, _ ...any) subcomm
 			Http:  answers.HttpListen,
 			Https: answers.HttpsListen,
 		},
-		InkscapeCmd: "inkscape",
-		RateLimit:   answers.RateLimit,
+		InkscapeCmd:  "inkscape",
+		RateLimit:    answers.RateLimit,
+		HistoryLimit: snapshot.DefaultHistoryLimit,
+		Shutdown: shutdownConfig{
+			DrainDelay:  duration(5 * time.Second),
+			GracePeriod: duration(30 * time.Second),
+		},
 	})
 	if err != nil {
 		fmt.Println("Failed to write config file: ", err)
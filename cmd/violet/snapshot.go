@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/1f349/violet"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/snapshot"
+	"github.com/google/subcommands"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type exportCmd struct {
+	configPath string
+	format     string
+	outPath    string
+}
+
+func (e *exportCmd) Name() string     { return "export" }
+func (e *exportCmd) Synopsis() string { return "Export the routing configuration" }
+func (e *exportCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&e.configPath, "conf", "", "/path/to/config.json : path to the config file")
+	f.StringVar(&e.format, "format", "json", "output format: json or yaml")
+	f.StringVar(&e.outPath, "o", "", "path to the output file (defaults to stdout)")
+}
+func (e *exportCmd) Usage() string {
+	return `export -conf <config file> [-format json|yaml] [-o <output file>]
+  Export all domains, routes, redirects and favicons from the database
+`
+}
+
+func (e *exportCmd) Execute(ctx context.Context, _ *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	format, err := snapshot.ParseFormat(e.format)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return subcommands.ExitUsageError
+	}
+	db, err := openConfigDB(e.configPath)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return subcommands.ExitFailure
+	}
+
+	s, err := snapshot.Export(ctx, db, nil)
+	if err != nil {
+		fmt.Println("Failed to export from database: ", err)
+		return subcommands.ExitFailure
+	}
+
+	var out io.Writer = os.Stdout
+	if e.outPath != "" {
+		f, err := os.Create(e.outPath)
+		if err != nil {
+			fmt.Println("Failed to create output file: ", err)
+			return subcommands.ExitFailure
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := s.Encode(out, format); err != nil {
+		fmt.Println("Failed to write export: ", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+type importCmd struct {
+	configPath string
+	format     string
+	dryRun     bool
+	prune      bool
+}
+
+func (i *importCmd) Name() string     { return "import" }
+func (i *importCmd) Synopsis() string { return "Import the routing configuration" }
+func (i *importCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&i.configPath, "conf", "", "/path/to/config.json : path to the config file")
+	f.StringVar(&i.format, "format", "", "input format: json or yaml (defaults to the file extension)")
+	f.BoolVar(&i.dryRun, "dry-run", false, "only report the changes")
+	f.BoolVar(&i.prune, "prune", false, "delete items missing from the sections in the input file")
+}
+func (i *importCmd) Usage() string {
+	return `import -conf <config file> [-format json|yaml] [-dry-run] [-prune] <input file>
+  Validate and import domains, routes, redirects and favicons into the database
+`
+}
+
+func (i *importCmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if f.NArg() != 1 {
+		fmt.Println("Error: input file is missing")
+		return subcommands.ExitUsageError
+	}
+	inPath := f.Arg(0)
+
+	name := i.format
+	if name == "" {
+		name = filepath.Ext(inPath)
+		if len(name) > 0 {
+			name = name[1:]
+		}
+	}
+	format, err := snapshot.ParseFormat(name)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return subcommands.ExitUsageError
+	}
+
+	in, err := os.Open(inPath)
+	if err != nil {
+		fmt.Println("Failed to open input file: ", err)
+		return subcommands.ExitFailure
+	}
+	defer in.Close()
+	s, err := snapshot.Decode(in, format)
+	if err != nil {
+		fmt.Println("Failed to decode input file: ", err)
+		return subcommands.ExitFailure
+	}
+
+	db, err := openConfigDB(i.configPath)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return subcommands.ExitFailure
+	}
+
+	history, err := openConfigHistory(i.configPath, db)
+	if err != nil {
+		fmt.Println("Error: ", err)
+		return subcommands.ExitFailure
+	}
+
+	opts := snapshot.ImportOptions{DryRun: i.dryRun, Prune: i.prune}
+	if !i.dryRun {
+		opts.Record = history.Recorder(ctx, cliActor, "import")
+	}
+	result, err := snapshot.Import(ctx, db, s, nil, opts)
+	if err != nil {
+		fmt.Println("Failed to import: ", err)
+		return subcommands.ExitFailure
+	}
+	if !i.dryRun {
+		audit.New(db).RecordChanges(ctx, cliActor, result.Differences)
+	}
+
+	for _, c := range result.Changes {
+		fmt.Println(c)
+	}
+	fmt.Printf("%d changed, %d unchanged\n", len(result.Changes), result.Unchanged)
+	if i.dryRun {
+		fmt.Println("Dry run, no changes were made")
+	} else if len(result.Changes) > 0 {
+		fmt.Println("Restart Violet or call the compile API to load the changes")
+	}
+	return subcommands.ExitSuccess
+}
+
+// openConfigDB opens the database stored next to the config file
+func openConfigDB(configPath string) (*database.Queries, error) {
+	if configPath == "" {
+		return nil, errors.New("config flag is missing")
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		return nil, err
+	}
+	return violet.InitDB(filepath.Join(filepath.Dir(configPath), "violet.db.sqlite"))
+}
+
+// cliActor is recorded in the audit log and history for changes made by the
+// subcommands as they aren't made with a token
+const cliActor = "violet"
+
+// openConfigHistory opens the configuration history using the limit from the
+// config file
+func openConfigHistory(configPath string, db *database.Queries) (*snapshot.History, error) {
+	raw, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var config startUpConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+	return snapshot.NewHistory(db, config.HistoryLimit), nil
+}
@@ -66,3 +66,55 @@ func (q *Queries) GetActiveDomains(ctx context.Context) ([]string, error) {
 	}
 	return items, nil
 }
+
+const getAllDomains = `-- name: GetAllDomains :many
+SELECT domain, active
+FROM domains
+ORDER BY domain
+`
+
+type GetAllDomainsRow struct {
+	Domain string `json:"domain"`
+	Active bool   `json:"active"`
+}
+
+func (q *Queries) GetAllDomains(ctx context.Context) ([]GetAllDomainsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAllDomains)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAllDomainsRow
+	for rows.Next() {
+		var i GetAllDomainsRow
+		if err := rows.Scan(&i.Domain, &i.Active); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDomain = `-- name: GetDomain :one
+SELECT domain, active
+FROM domains
+WHERE domain = ?
+`
+
+type GetDomainRow struct {
+	Domain string `json:"domain"`
+	Active bool   `json:"active"`
+}
+
+func (q *Queries) GetDomain(ctx context.Context, domain string) (GetDomainRow, error) {
+	row := q.db.QueryRowContext(ctx, getDomain, domain)
+	var i GetDomainRow
+	err := row.Scan(&i.Domain, &i.Active)
+	return i, err
+}
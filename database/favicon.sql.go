@@ -10,6 +10,45 @@ import (
 	"database/sql"
 )
 
+const deleteFavicon = `-- name: DeleteFavicon :execrows
+DELETE
+FROM favicons
+WHERE host = ?
+`
+
+func (q *Queries) DeleteFavicon(ctx context.Context, host string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteFavicon, host)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getFavicon = `-- name: GetFavicon :one
+SELECT host, svg, png, ico
+FROM favicons
+WHERE host = ?
+`
+
+type GetFaviconRow struct {
+	Host string         `json:"host"`
+	Svg  sql.NullString `json:"svg"`
+	Png  sql.NullString `json:"png"`
+	Ico  sql.NullString `json:"ico"`
+}
+
+func (q *Queries) GetFavicon(ctx context.Context, host string) (GetFaviconRow, error) {
+	row := q.db.QueryRowContext(ctx, getFavicon, host)
+	var i GetFaviconRow
+	err := row.Scan(
+		&i.Host,
+		&i.Svg,
+		&i.Png,
+		&i.Ico,
+	)
+	return i, err
+}
+
 const getFavicons = `-- name: GetFavicons :many
 SELECT host, svg, png, ico
 FROM favicons
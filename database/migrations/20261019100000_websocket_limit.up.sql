@@ -0,0 +1,2 @@
+ALTER TABLE routes
+    ADD COLUMN websocket_limit INTEGER NOT NULL DEFAULT 0;
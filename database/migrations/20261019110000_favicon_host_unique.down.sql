@@ -0,0 +1 @@
+DROP INDEX IF EXISTS favicons_host;
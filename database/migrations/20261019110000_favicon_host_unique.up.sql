@@ -0,0 +1,5 @@
+DELETE
+FROM favicons
+WHERE id NOT IN (SELECT MAX(id) FROM favicons GROUP BY host);
+
+CREATE UNIQUE INDEX IF NOT EXISTS favicons_host ON favicons (host);
@@ -0,0 +1,5 @@
+ALTER TABLE routes
+    DROP COLUMN version;
+
+ALTER TABLE redirects
+    DROP COLUMN version;
@@ -0,0 +1,5 @@
+ALTER TABLE routes
+    ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+ALTER TABLE redirects
+    ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
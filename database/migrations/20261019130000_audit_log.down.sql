@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS audit_log_domain;
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id     INTEGER PRIMARY KEY AUTOINCREMENT,
+    time   DATETIME NOT NULL,
+    actor  TEXT     NOT NULL,
+    action TEXT     NOT NULL,
+    type   TEXT     NOT NULL,
+    key    TEXT     NOT NULL,
+    domain TEXT     NOT NULL,
+    before TEXT,
+    after  TEXT
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_domain ON audit_log (domain, id);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS config_history
+(
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    time     DATETIME NOT NULL,
+    actor    TEXT     NOT NULL,
+    reason   TEXT     NOT NULL,
+    snapshot TEXT     NOT NULL
+);
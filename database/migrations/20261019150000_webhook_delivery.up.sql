@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS webhook_delivery
+(
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    delivery TEXT     NOT NULL,
+    time     DATETIME NOT NULL,
+    url      TEXT     NOT NULL,
+    event    TEXT     NOT NULL,
+    attempt  INTEGER  NOT NULL,
+    status   INTEGER  NOT NULL,
+    error    TEXT     NOT NULL
+);
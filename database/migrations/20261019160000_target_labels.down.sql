@@ -0,0 +1,5 @@
+ALTER TABLE routes
+    DROP COLUMN labels;
+
+ALTER TABLE redirects
+    DROP COLUMN labels;
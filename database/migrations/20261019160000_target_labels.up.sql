@@ -0,0 +1,5 @@
+ALTER TABLE routes
+    ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
+
+ALTER TABLE redirects
+    ADD COLUMN labels TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE config_history
+    ADD COLUMN domains TEXT NOT NULL DEFAULT '[]';
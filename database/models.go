@@ -6,10 +6,32 @@ package database
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/1f349/violet/target"
 )
 
+type AuditLog struct {
+	ID     int64          `json:"id"`
+	Time   time.Time      `json:"time"`
+	Actor  string         `json:"actor"`
+	Action string         `json:"action"`
+	Type   string         `json:"type"`
+	Key    string         `json:"key"`
+	Domain string         `json:"domain"`
+	Before sql.NullString `json:"before"`
+	After  sql.NullString `json:"after"`
+}
+
+type ConfigHistory struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Reason   string    `json:"reason"`
+	Snapshot string    `json:"snapshot"`
+	Domains  string    `json:"domains"`
+}
+
 type Domain struct {
 	ID     int64  `json:"id"`
 	Domain string `json:"domain"`
@@ -25,20 +47,36 @@ type Favicon struct {
 }
 
 type Redirect struct {
-	ID          int64        `json:"id"`
-	Source      string       `json:"source"`
-	Destination string       `json:"destination"`
-	Description string       `json:"description"`
-	Flags       target.Flags `json:"flags"`
-	Code        int64        `json:"code"`
-	Active      bool         `json:"active"`
+	ID          int64         `json:"id"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Description string        `json:"description"`
+	Flags       target.Flags  `json:"flags"`
+	Code        int64         `json:"code"`
+	Active      bool          `json:"active"`
+	Version     int64         `json:"version"`
+	Labels      target.Labels `json:"labels"`
 }
 
 type Route struct {
-	ID          int64        `json:"id"`
-	Source      string       `json:"source"`
-	Destination string       `json:"destination"`
-	Description string       `json:"description"`
-	Flags       target.Flags `json:"flags"`
-	Active      bool         `json:"active"`
+	ID             int64         `json:"id"`
+	Source         string        `json:"source"`
+	Destination    string        `json:"destination"`
+	Description    string        `json:"description"`
+	Flags          target.Flags  `json:"flags"`
+	Active         bool          `json:"active"`
+	WebsocketLimit int64         `json:"websocket_limit"`
+	Version        int64         `json:"version"`
+	Labels         target.Labels `json:"labels"`
+}
+
+type WebhookDelivery struct {
+	ID       int64     `json:"id"`
+	Delivery string    `json:"delivery"`
+	Time     time.Time `json:"time"`
+	Url      string    `json:"url"`
+	Event    string    `json:"event"`
+	Attempt  int64     `json:"attempt"`
+	Status   int64     `json:"status"`
+	Error    string    `json:"error"`
 }
@@ -0,0 +1,11 @@
+-- name: AddAuditEntry :exec
+INSERT INTO audit_log (time, actor, action, type, key, domain, before, after)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetAuditEntries :many
+SELECT id, time, actor, action, type, key, domain, before, after
+FROM audit_log
+WHERE domain IN (sqlc.slice('domains'))
+  AND (sqlc.arg('before_id') = 0 OR id < sqlc.arg('before_id'))
+ORDER BY id DESC
+LIMIT sqlc.arg('limit');
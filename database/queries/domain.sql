@@ -14,3 +14,13 @@ INSERT OR
 REPLACE
 INTO domains(domain, active)
 VALUES (?, false);
+
+-- name: GetAllDomains :many
+SELECT domain, active
+FROM domains
+ORDER BY domain;
+
+-- name: GetDomain :one
+SELECT domain, active
+FROM domains
+WHERE domain = ?;
@@ -6,3 +6,13 @@ FROM favicons;
 INSERT OR
 REPLACE INTO favicons (host, svg, png, ico)
 VALUES (?, ?, ?, ?);
+
+-- name: GetFavicon :one
+SELECT host, svg, png, ico
+FROM favicons
+WHERE host = ?;
+
+-- name: DeleteFavicon :execrows
+DELETE
+FROM favicons
+WHERE host = ?;
@@ -0,0 +1,27 @@
+-- name: AddConfigVersion :execlastid
+INSERT INTO config_history (time, actor, reason, snapshot, domains)
+VALUES (?, ?, ?, ?, ?);
+
+-- name: GetLatestConfigVersion :one
+SELECT id, snapshot
+FROM config_history
+ORDER BY id DESC
+LIMIT 1;
+
+-- name: GetConfigVersion :one
+SELECT id, time, actor, reason, snapshot, domains
+FROM config_history
+WHERE id = ?;
+
+-- name: GetConfigVersions :many
+SELECT id, time, actor, reason
+FROM config_history
+WHERE EXISTS (SELECT 1 FROM json_each(domains) d WHERE d.value IN (sqlc.slice('owned')))
+  AND (sqlc.arg('before_id') = 0 OR id < sqlc.arg('before_id'))
+ORDER BY id DESC
+LIMIT sqlc.arg('limit');
+
+-- name: PruneConfigVersions :exec
+DELETE
+FROM config_history
+WHERE id < (SELECT min(id) FROM (SELECT id FROM config_history ORDER BY id DESC LIMIT @keep));
@@ -1,5 +1,5 @@
 -- name: GetActiveRoutes :many
-SELECT source, destination, flags
+SELECT source, destination, flags, websocket_limit
 FROM routes
 WHERE active = 1;
 
@@ -9,31 +9,129 @@ FROM redirects
 WHERE active = 1;
 
 -- name: GetAllRoutes :many
-SELECT source, destination, description, flags, active
+SELECT source, destination, description, flags, active, websocket_limit, labels
 FROM routes;
 
 -- name: GetAllRedirects :many
-SELECT source, destination, description, flags, code, active
+SELECT source, destination, description, flags, code, active, labels
 FROM redirects;
 
 -- name: AddRoute :exec
-INSERT OR
-REPLACE
-INTO routes (source, destination, description, flags, active)
-VALUES (?, ?, ?, ?, ?);
+INSERT INTO routes (source, destination, description, flags, active, websocket_limit, labels)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (source) DO UPDATE SET destination     = excluded.destination,
+                                   description     = excluded.description,
+                                   flags           = excluded.flags,
+                                   active          = excluded.active,
+                                   websocket_limit = excluded.websocket_limit,
+                                   labels          = excluded.labels,
+                                   version         = version + 1;
 
 -- name: AddRedirect :exec
-INSERT OR
-REPLACE
-INTO redirects (source, destination, description, flags, code, active)
-VALUES (?, ?, ?, ?, ?, ?);
+INSERT INTO redirects (source, destination, description, flags, code, active, labels)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (source) DO UPDATE SET destination = excluded.destination,
+                                   description = excluded.description,
+                                   flags       = excluded.flags,
+                                   code        = excluded.code,
+                                   active      = excluded.active,
+                                   labels      = excluded.labels,
+                                   version     = version + 1;
 
--- name: RemoveRoute :exec
+-- name: RemoveRoute :execrows
 DELETE
 FROM routes
-WHERE source = ?;
+WHERE source = @source
+  AND (@version = 0 OR version = @version);
 
--- name: RemoveRedirect :exec
+-- name: RemoveRedirect :execrows
 DELETE
 FROM redirects
+WHERE source = @source
+  AND (@version = 0 OR version = @version);
+
+-- name: GetRoute :one
+SELECT source, destination, description, flags, active, websocket_limit, labels, version
+FROM routes
 WHERE source = ?;
+
+-- name: GetRedirect :one
+SELECT source, destination, description, flags, code, active, labels, version
+FROM redirects
+WHERE source = ?;
+
+-- name: UpdateRoute :execrows
+UPDATE routes
+SET destination     = @destination,
+    description     = @description,
+    flags           = @flags,
+    active          = @active,
+    websocket_limit = @websocket_limit,
+    labels          = @labels,
+    version         = version + 1
+WHERE source = @source
+  AND (@version = 0 OR version = @version);
+
+-- name: UpdateRedirect :execrows
+UPDATE redirects
+SET destination = @destination,
+    description = @description,
+    flags       = @flags,
+    code        = @code,
+    active      = @active,
+    labels      = @labels,
+    version     = version + 1
+WHERE source = @source
+  AND (@version = 0 OR version = @version);
+
+-- name: SetRouteActive :execrows
+UPDATE routes
+SET active  = @active,
+    version = version + 1
+WHERE source = @source
+  AND (@version = 0 OR version = @version);
+
+-- name: SetRedirectActive :execrows
+UPDATE redirects
+SET active  = @active,
+    version = version + 1
+WHERE source = @source
+  AND (@version = 0 OR version = @version);
+
+-- name: ListRoutes :many
+SELECT id, source, destination, description, flags, active, websocket_limit, labels
+FROM routes
+WHERE EXISTS (SELECT 1
+              FROM json_each(@hosts) h
+              WHERE substr(source, 1, instr(source || '/', '/') - 1) = h.value
+                 OR substr(substr(source, 1, instr(source || '/', '/') - 1), -length(h.value) - 1) = '.' || h.value)
+  AND (@search = '' OR instr(lower(source), lower(@search)) > 0
+    OR instr(lower(destination), lower(@search)) > 0
+    OR instr(lower(description), lower(@search)) > 0)
+  AND (sqlc.narg('active') IS NULL OR active = sqlc.narg('active'))
+  AND (flags & @flags) = @flags
+  AND NOT EXISTS (SELECT 1
+                  FROM json_each(@labels) s
+                  WHERE NOT EXISTS (SELECT 1 FROM json_each(labels) l WHERE l.key = s.key AND l.value = s.value))
+  AND id > @after_id
+ORDER BY id
+LIMIT @limit;
+
+-- name: ListRedirects :many
+SELECT id, source, destination, description, flags, code, active, labels
+FROM redirects
+WHERE EXISTS (SELECT 1
+              FROM json_each(@hosts) h
+              WHERE substr(source, 1, instr(source || '/', '/') - 1) = h.value
+                 OR substr(substr(source, 1, instr(source || '/', '/') - 1), -length(h.value) - 1) = '.' || h.value)
+  AND (@search = '' OR instr(lower(source), lower(@search)) > 0
+    OR instr(lower(destination), lower(@search)) > 0
+    OR instr(lower(description), lower(@search)) > 0)
+  AND (sqlc.narg('active') IS NULL OR active = sqlc.narg('active'))
+  AND (flags & @flags) = @flags
+  AND NOT EXISTS (SELECT 1
+                  FROM json_each(@labels) s
+                  WHERE NOT EXISTS (SELECT 1 FROM json_each(labels) l WHERE l.key = s.key AND l.value = s.value))
+  AND id > @after_id
+ORDER BY id
+LIMIT @limit;
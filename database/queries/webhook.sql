@@ -0,0 +1,15 @@
+-- name: AddWebhookDelivery :exec
+INSERT INTO webhook_delivery (delivery, time, url, event, attempt, status, error)
+VALUES (?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetWebhookDeliveries :many
+SELECT id, delivery, time, url, event, attempt, status, error
+FROM webhook_delivery
+WHERE (@before_id = 0 OR id < @before_id)
+ORDER BY id DESC
+LIMIT @limit;
+
+-- name: PruneWebhookDeliveries :exec
+DELETE
+FROM webhook_delivery
+WHERE id < (SELECT min(id) FROM (SELECT id FROM webhook_delivery ORDER BY id DESC LIMIT @keep));
@@ -7,24 +7,31 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/1f349/violet/target"
 )
 
 const addRedirect = `-- name: AddRedirect :exec
-INSERT OR
-REPLACE
-INTO redirects (source, destination, description, flags, code, active)
-VALUES (?, ?, ?, ?, ?, ?)
+INSERT INTO redirects (source, destination, description, flags, code, active, labels)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (source) DO UPDATE SET destination = excluded.destination,
+                                   description = excluded.description,
+                                   flags       = excluded.flags,
+                                   code        = excluded.code,
+                                   active      = excluded.active,
+                                   labels      = excluded.labels,
+                                   version     = version + 1
 `
 
 type AddRedirectParams struct {
-	Source      string       `json:"source"`
-	Destination string       `json:"destination"`
-	Description string       `json:"description"`
-	Flags       target.Flags `json:"flags"`
-	Code        int64        `json:"code"`
-	Active      bool         `json:"active"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Description string        `json:"description"`
+	Flags       target.Flags  `json:"flags"`
+	Code        int64         `json:"code"`
+	Active      bool          `json:"active"`
+	Labels      target.Labels `json:"labels"`
 }
 
 func (q *Queries) AddRedirect(ctx context.Context, arg AddRedirectParams) error {
@@ -35,23 +42,31 @@ func (q *Queries) AddRedirect(ctx context.Context, arg AddRedirectParams) error
 		arg.Flags,
 		arg.Code,
 		arg.Active,
+		arg.Labels,
 	)
 	return err
 }
 
 const addRoute = `-- name: AddRoute :exec
-INSERT OR
-REPLACE
-INTO routes (source, destination, description, flags, active)
-VALUES (?, ?, ?, ?, ?)
+INSERT INTO routes (source, destination, description, flags, active, websocket_limit, labels)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (source) DO UPDATE SET destination     = excluded.destination,
+                                   description     = excluded.description,
+                                   flags           = excluded.flags,
+                                   active          = excluded.active,
+                                   websocket_limit = excluded.websocket_limit,
+                                   labels          = excluded.labels,
+                                   version         = version + 1
 `
 
 type AddRouteParams struct {
-	Source      string       `json:"source"`
-	Destination string       `json:"destination"`
-	Description string       `json:"description"`
-	Flags       target.Flags `json:"flags"`
-	Active      bool         `json:"active"`
+	Source         string        `json:"source"`
+	Destination    string        `json:"destination"`
+	Description    string        `json:"description"`
+	Flags          target.Flags  `json:"flags"`
+	Active         bool          `json:"active"`
+	WebsocketLimit int64         `json:"websocket_limit"`
+	Labels         target.Labels `json:"labels"`
 }
 
 func (q *Queries) AddRoute(ctx context.Context, arg AddRouteParams) error {
@@ -61,6 +76,8 @@ func (q *Queries) AddRoute(ctx context.Context, arg AddRouteParams) error {
 		arg.Description,
 		arg.Flags,
 		arg.Active,
+		arg.WebsocketLimit,
+		arg.Labels,
 	)
 	return err
 }
@@ -107,15 +124,16 @@ func (q *Queries) GetActiveRedirects(ctx context.Context) ([]GetActiveRedirectsR
 }
 
 const getActiveRoutes = `-- name: GetActiveRoutes :many
-SELECT source, destination, flags
+SELECT source, destination, flags, websocket_limit
 FROM routes
 WHERE active = 1
 `
 
 type GetActiveRoutesRow struct {
-	Source      string       `json:"source"`
-	Destination string       `json:"destination"`
-	Flags       target.Flags `json:"flags"`
+	Source         string       `json:"source"`
+	Destination    string       `json:"destination"`
+	Flags          target.Flags `json:"flags"`
+	WebsocketLimit int64        `json:"websocket_limit"`
 }
 
 func (q *Queries) GetActiveRoutes(ctx context.Context) ([]GetActiveRoutesRow, error) {
@@ -127,7 +145,12 @@ func (q *Queries) GetActiveRoutes(ctx context.Context) ([]GetActiveRoutesRow, er
 	var items []GetActiveRoutesRow
 	for rows.Next() {
 		var i GetActiveRoutesRow
-		if err := rows.Scan(&i.Source, &i.Destination, &i.Flags); err != nil {
+		if err := rows.Scan(
+			&i.Source,
+			&i.Destination,
+			&i.Flags,
+			&i.WebsocketLimit,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -142,17 +165,18 @@ func (q *Queries) GetActiveRoutes(ctx context.Context) ([]GetActiveRoutesRow, er
 }
 
 const getAllRedirects = `-- name: GetAllRedirects :many
-SELECT source, destination, description, flags, code, active
+SELECT source, destination, description, flags, code, active, labels
 FROM redirects
 `
 
 type GetAllRedirectsRow struct {
-	Source      string       `json:"source"`
-	Destination string       `json:"destination"`
-	Description string       `json:"description"`
-	Flags       target.Flags `json:"flags"`
-	Code        int64        `json:"code"`
-	Active      bool         `json:"active"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Description string        `json:"description"`
+	Flags       target.Flags  `json:"flags"`
+	Code        int64         `json:"code"`
+	Active      bool          `json:"active"`
+	Labels      target.Labels `json:"labels"`
 }
 
 func (q *Queries) GetAllRedirects(ctx context.Context) ([]GetAllRedirectsRow, error) {
@@ -171,6 +195,7 @@ func (q *Queries) GetAllRedirects(ctx context.Context) ([]GetAllRedirectsRow, er
 			&i.Flags,
 			&i.Code,
 			&i.Active,
+			&i.Labels,
 		); err != nil {
 			return nil, err
 		}
@@ -186,16 +211,18 @@ func (q *Queries) GetAllRedirects(ctx context.Context) ([]GetAllRedirectsRow, er
 }
 
 const getAllRoutes = `-- name: GetAllRoutes :many
-SELECT source, destination, description, flags, active
+SELECT source, destination, description, flags, active, websocket_limit, labels
 FROM routes
 `
 
 type GetAllRoutesRow struct {
-	Source      string       `json:"source"`
-	Destination string       `json:"destination"`
-	Description string       `json:"description"`
-	Flags       target.Flags `json:"flags"`
-	Active      bool         `json:"active"`
+	Source         string        `json:"source"`
+	Destination    string        `json:"destination"`
+	Description    string        `json:"description"`
+	Flags          target.Flags  `json:"flags"`
+	Active         bool          `json:"active"`
+	WebsocketLimit int64         `json:"websocket_limit"`
+	Labels         target.Labels `json:"labels"`
 }
 
 func (q *Queries) GetAllRoutes(ctx context.Context) ([]GetAllRoutesRow, error) {
@@ -213,6 +240,8 @@ func (q *Queries) GetAllRoutes(ctx context.Context) ([]GetAllRoutesRow, error) {
 			&i.Description,
 			&i.Flags,
 			&i.Active,
+			&i.WebsocketLimit,
+			&i.Labels,
 		); err != nil {
 			return nil, err
 		}
@@ -227,24 +256,396 @@ func (q *Queries) GetAllRoutes(ctx context.Context) ([]GetAllRoutesRow, error) {
 	return items, nil
 }
 
-const removeRedirect = `-- name: RemoveRedirect :exec
-DELETE
+const getRedirect = `-- name: GetRedirect :one
+SELECT source, destination, description, flags, code, active, labels, version
 FROM redirects
 WHERE source = ?
 `
 
-func (q *Queries) RemoveRedirect(ctx context.Context, source string) error {
-	_, err := q.db.ExecContext(ctx, removeRedirect, source)
-	return err
+type GetRedirectRow struct {
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Description string        `json:"description"`
+	Flags       target.Flags  `json:"flags"`
+	Code        int64         `json:"code"`
+	Active      bool          `json:"active"`
+	Labels      target.Labels `json:"labels"`
+	Version     int64         `json:"version"`
 }
 
-const removeRoute = `-- name: RemoveRoute :exec
-DELETE
+func (q *Queries) GetRedirect(ctx context.Context, source string) (GetRedirectRow, error) {
+	row := q.db.QueryRowContext(ctx, getRedirect, source)
+	var i GetRedirectRow
+	err := row.Scan(
+		&i.Source,
+		&i.Destination,
+		&i.Description,
+		&i.Flags,
+		&i.Code,
+		&i.Active,
+		&i.Labels,
+		&i.Version,
+	)
+	return i, err
+}
+
+const getRoute = `-- name: GetRoute :one
+SELECT source, destination, description, flags, active, websocket_limit, labels, version
 FROM routes
 WHERE source = ?
 `
 
-func (q *Queries) RemoveRoute(ctx context.Context, source string) error {
-	_, err := q.db.ExecContext(ctx, removeRoute, source)
-	return err
+type GetRouteRow struct {
+	Source         string        `json:"source"`
+	Destination    string        `json:"destination"`
+	Description    string        `json:"description"`
+	Flags          target.Flags  `json:"flags"`
+	Active         bool          `json:"active"`
+	WebsocketLimit int64         `json:"websocket_limit"`
+	Labels         target.Labels `json:"labels"`
+	Version        int64         `json:"version"`
+}
+
+func (q *Queries) GetRoute(ctx context.Context, source string) (GetRouteRow, error) {
+	row := q.db.QueryRowContext(ctx, getRoute, source)
+	var i GetRouteRow
+	err := row.Scan(
+		&i.Source,
+		&i.Destination,
+		&i.Description,
+		&i.Flags,
+		&i.Active,
+		&i.WebsocketLimit,
+		&i.Labels,
+		&i.Version,
+	)
+	return i, err
+}
+
+const listRedirects = `-- name: ListRedirects :many
+SELECT id, source, destination, description, flags, code, active, labels
+FROM redirects
+WHERE EXISTS (SELECT 1
+              FROM json_each(?1) h
+              WHERE substr(source, 1, instr(source || '/', '/') - 1) = h.value
+                 OR substr(substr(source, 1, instr(source || '/', '/') - 1), -length(h.value) - 1) = '.' || h.value)
+  AND (?2 = '' OR instr(lower(source), lower(?2)) > 0
+    OR instr(lower(destination), lower(?2)) > 0
+    OR instr(lower(description), lower(?2)) > 0)
+  AND (?3 IS NULL OR active = ?3)
+  AND (flags & ?4) = ?4
+  AND NOT EXISTS (SELECT 1
+                  FROM json_each(?5) s
+                  WHERE NOT EXISTS (SELECT 1 FROM json_each(labels) l WHERE l.key = s.key AND l.value = s.value))
+  AND id > ?6
+ORDER BY id
+LIMIT ?7
+`
+
+type ListRedirectsParams struct {
+	Hosts   string        `json:"hosts"`
+	Search  string        `json:"search"`
+	Active  sql.NullBool  `json:"active"`
+	Flags   target.Flags  `json:"flags"`
+	Labels  target.Labels `json:"labels"`
+	AfterID int64         `json:"after_id"`
+	Limit   int64         `json:"limit"`
+}
+
+type ListRedirectsRow struct {
+	ID          int64         `json:"id"`
+	Source      string        `json:"source"`
+	Destination string        `json:"destination"`
+	Description string        `json:"description"`
+	Flags       target.Flags  `json:"flags"`
+	Code        int64         `json:"code"`
+	Active      bool          `json:"active"`
+	Labels      target.Labels `json:"labels"`
+}
+
+func (q *Queries) ListRedirects(ctx context.Context, arg ListRedirectsParams) ([]ListRedirectsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listRedirects,
+		arg.Hosts,
+		arg.Search,
+		arg.Active,
+		arg.Flags,
+		arg.Labels,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRedirectsRow
+	for rows.Next() {
+		var i ListRedirectsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Source,
+			&i.Destination,
+			&i.Description,
+			&i.Flags,
+			&i.Code,
+			&i.Active,
+			&i.Labels,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoutes = `-- name: ListRoutes :many
+SELECT id, source, destination, description, flags, active, websocket_limit, labels
+FROM routes
+WHERE EXISTS (SELECT 1
+              FROM json_each(?1) h
+              WHERE substr(source, 1, instr(source || '/', '/') - 1) = h.value
+                 OR substr(substr(source, 1, instr(source || '/', '/') - 1), -length(h.value) - 1) = '.' || h.value)
+  AND (?2 = '' OR instr(lower(source), lower(?2)) > 0
+    OR instr(lower(destination), lower(?2)) > 0
+    OR instr(lower(description), lower(?2)) > 0)
+  AND (?3 IS NULL OR active = ?3)
+  AND (flags & ?4) = ?4
+  AND NOT EXISTS (SELECT 1
+                  FROM json_each(?5) s
+                  WHERE NOT EXISTS (SELECT 1 FROM json_each(labels) l WHERE l.key = s.key AND l.value = s.value))
+  AND id > ?6
+ORDER BY id
+LIMIT ?7
+`
+
+type ListRoutesParams struct {
+	Hosts   string        `json:"hosts"`
+	Search  string        `json:"search"`
+	Active  sql.NullBool  `json:"active"`
+	Flags   target.Flags  `json:"flags"`
+	Labels  target.Labels `json:"labels"`
+	AfterID int64         `json:"after_id"`
+	Limit   int64         `json:"limit"`
+}
+
+type ListRoutesRow struct {
+	ID             int64         `json:"id"`
+	Source         string        `json:"source"`
+	Destination    string        `json:"destination"`
+	Description    string        `json:"description"`
+	Flags          target.Flags  `json:"flags"`
+	Active         bool          `json:"active"`
+	WebsocketLimit int64         `json:"websocket_limit"`
+	Labels         target.Labels `json:"labels"`
+}
+
+func (q *Queries) ListRoutes(ctx context.Context, arg ListRoutesParams) ([]ListRoutesRow, error) {
+	rows, err := q.db.QueryContext(ctx, listRoutes,
+		arg.Hosts,
+		arg.Search,
+		arg.Active,
+		arg.Flags,
+		arg.Labels,
+		arg.AfterID,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRoutesRow
+	for rows.Next() {
+		var i ListRoutesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Source,
+			&i.Destination,
+			&i.Description,
+			&i.Flags,
+			&i.Active,
+			&i.WebsocketLimit,
+			&i.Labels,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const removeRedirect = `-- name: RemoveRedirect :execrows
+DELETE
+FROM redirects
+WHERE source = ?1
+  AND (?2 = 0 OR version = ?2)
+`
+
+type RemoveRedirectParams struct {
+	Source  string `json:"source"`
+	Version int64  `json:"version"`
+}
+
+func (q *Queries) RemoveRedirect(ctx context.Context, arg RemoveRedirectParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeRedirect, arg.Source, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const removeRoute = `-- name: RemoveRoute :execrows
+DELETE
+FROM routes
+WHERE source = ?1
+  AND (?2 = 0 OR version = ?2)
+`
+
+type RemoveRouteParams struct {
+	Source  string `json:"source"`
+	Version int64  `json:"version"`
+}
+
+func (q *Queries) RemoveRoute(ctx context.Context, arg RemoveRouteParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, removeRoute, arg.Source, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setRedirectActive = `-- name: SetRedirectActive :execrows
+UPDATE redirects
+SET active  = ?1,
+    version = version + 1
+WHERE source = ?2
+  AND (?3 = 0 OR version = ?3)
+`
+
+type SetRedirectActiveParams struct {
+	Active  bool   `json:"active"`
+	Source  string `json:"source"`
+	Version int64  `json:"version"`
+}
+
+func (q *Queries) SetRedirectActive(ctx context.Context, arg SetRedirectActiveParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setRedirectActive, arg.Active, arg.Source, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setRouteActive = `-- name: SetRouteActive :execrows
+UPDATE routes
+SET active  = ?1,
+    version = version + 1
+WHERE source = ?2
+  AND (?3 = 0 OR version = ?3)
+`
+
+type SetRouteActiveParams struct {
+	Active  bool   `json:"active"`
+	Source  string `json:"source"`
+	Version int64  `json:"version"`
+}
+
+func (q *Queries) SetRouteActive(ctx context.Context, arg SetRouteActiveParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setRouteActive, arg.Active, arg.Source, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateRedirect = `-- name: UpdateRedirect :execrows
+UPDATE redirects
+SET destination = ?1,
+    description = ?2,
+    flags       = ?3,
+    code        = ?4,
+    active      = ?5,
+    labels      = ?6,
+    version     = version + 1
+WHERE source = ?7
+  AND (?8 = 0 OR version = ?8)
+`
+
+type UpdateRedirectParams struct {
+	Destination string        `json:"destination"`
+	Description string        `json:"description"`
+	Flags       target.Flags  `json:"flags"`
+	Code        int64         `json:"code"`
+	Active      bool          `json:"active"`
+	Labels      target.Labels `json:"labels"`
+	Source      string        `json:"source"`
+	Version     int64         `json:"version"`
+}
+
+func (q *Queries) UpdateRedirect(ctx context.Context, arg UpdateRedirectParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateRedirect,
+		arg.Destination,
+		arg.Description,
+		arg.Flags,
+		arg.Code,
+		arg.Active,
+		arg.Labels,
+		arg.Source,
+		arg.Version,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateRoute = `-- name: UpdateRoute :execrows
+UPDATE routes
+SET destination     = ?1,
+    description     = ?2,
+    flags           = ?3,
+    active          = ?4,
+    websocket_limit = ?5,
+    labels          = ?6,
+    version         = version + 1
+WHERE source = ?7
+  AND (?8 = 0 OR version = ?8)
+`
+
+type UpdateRouteParams struct {
+	Destination    string        `json:"destination"`
+	Description    string        `json:"description"`
+	Flags          target.Flags  `json:"flags"`
+	Active         bool          `json:"active"`
+	WebsocketLimit int64         `json:"websocket_limit"`
+	Labels         target.Labels `json:"labels"`
+	Source         string        `json:"source"`
+	Version        int64         `json:"version"`
+}
+
+func (q *Queries) UpdateRoute(ctx context.Context, arg UpdateRouteParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateRoute,
+		arg.Destination,
+		arg.Description,
+		arg.Flags,
+		arg.Active,
+		arg.WebsocketLimit,
+		arg.Labels,
+		arg.Source,
+		arg.Version,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Transaction runs fn with queries bound to a new transaction. The
+// transaction is committed if fn returns nil and rolled back otherwise. If the
+// queries are already bound to a transaction then fn runs within it.
+func (q *Queries) Transaction(ctx context.Context, fn func(tx *Queries) error) error {
+	if _, ok := q.db.(*sql.Tx); ok {
+		return fn(q)
+	}
+	db, ok := q.db.(*sql.DB)
+	if !ok {
+		return errors.New("database does not support transactions")
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(q.WithTx(tx)); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -5,10 +5,12 @@ import (
 	_ "embed"
 	"github.com/1f349/violet/database"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
 	"github.com/1f349/violet/utils"
 	"github.com/mrmelon54/rescheduler"
 	"strings"
 	"sync"
+	"time"
 )
 
 var Logger = logger.Logger.WithPrefix("Violet Domains")
@@ -19,6 +21,7 @@ type Domains struct {
 	s  *sync.RWMutex
 	m  map[string]struct{}
 	r  *rescheduler.Rescheduler
+	*utils.CompileTracker
 }
 
 // New creates a new domain list
@@ -27,6 +30,8 @@ func New(db *database.Queries) *Domains {
 		db: db,
 		s:  &sync.RWMutex{},
 		m:  make(map[string]struct{}),
+
+		CompileTracker: utils.NewCompileTracker("domains"),
 	}
 	a.r = rescheduler.NewRescheduler(a.threadCompile)
 	return a
@@ -64,13 +69,17 @@ func (d *Domains) Compile() {
 }
 
 func (d *Domains) threadCompile() {
+	start := time.Now()
+
 	// new map
 	domainMap := make(map[string]struct{})
 
 	// compile map and check errors
 	err := d.internalCompile(domainMap)
+	metrics.ObserveCompile("domains", start, err)
 	if err != nil {
 		Logger.Info("Compile faile", "err", err)
+		d.FinishCompile(start, 0, err)
 		return
 	}
 
@@ -78,6 +87,7 @@ func (d *Domains) threadCompile() {
 	d.s.Lock()
 	d.m = domainMap
 	d.s.Unlock()
+	d.FinishCompile(start, len(domainMap), nil)
 }
 
 // internalCompile is a hidden internal method for querying the database during
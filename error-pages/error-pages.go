@@ -1,19 +1,27 @@
 package error_pages
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
+	"github.com/1f349/violet/utils"
 	"github.com/mrmelon54/rescheduler"
+	"html"
 	"io/fs"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var Logger = logger.Logger.WithPrefix("Violet Error Pages")
 
+// requestIdPlaceholder is replaced with the request id in custom error pages
+var requestIdPlaceholder = []byte("{{request_id}}")
+
 // ErrorPages stores the custom error pages and is called by the servers to
 // output meaningful pages for HTTP error codes
 type ErrorPages struct {
@@ -22,6 +30,7 @@ type ErrorPages struct {
 	generic func(rw http.ResponseWriter, code int)
 	dir     fs.FS
 	r       *rescheduler.Rescheduler
+	*utils.CompileTracker
 }
 
 // New creates a new error pages generator
@@ -33,16 +42,20 @@ func New(dir fs.FS) *ErrorPages {
 		generic: func(rw http.ResponseWriter, code int) {
 			// if status text is empty then the code is unknown
 			a := http.StatusText(code)
-			fmt.Printf("%d - %s\n", code, a)
-			if a != "" {
-				// output in "xxx Error Text" format
-				http.Error(rw, fmt.Sprintf("%d %s\n", code, a), code)
+			if a == "" {
+				a = "Unknown Error Code"
+			}
+
+			// output in "xxx Error Text" format with the request id if set
+			if id := rw.Header().Get("X-Request-Id"); id != "" {
+				http.Error(rw, fmt.Sprintf("%d %s\nRequest ID: %s\n", code, a, id), code)
 				return
 			}
-			// output the code and generic unknown message
-			http.Error(rw, fmt.Sprintf("%d Unknown Error Code\n", code), code)
+			http.Error(rw, fmt.Sprintf("%d %s\n", code, a), code)
 		},
 		dir: dir,
+
+		CompileTracker: utils.NewCompileTracker("error-pages"),
 	}
 	e.r = rescheduler.NewRescheduler(e.threadCompile)
 	return e
@@ -73,22 +86,29 @@ func (e *ErrorPages) Compile() {
 }
 
 func (e *ErrorPages) threadCompile() {
+	start := time.Now()
+
 	// new map
 	errorPageMap := make(map[int]func(rw http.ResponseWriter))
 
-	// compile map and check errors
+	// compile map and check errors, without a directory only the generic
+	// error pages are used
+	var err error
 	if e.dir != nil {
-		err := e.internalCompile(errorPageMap)
-		if err != nil {
-			Logger.Info("Compile failed", "err", err)
-			return
-		}
+		err = e.internalCompile(errorPageMap)
+	}
+	metrics.ObserveCompile("error-pages", start, err)
+	if err != nil {
+		Logger.Info("Compile failed", "err", err)
+		e.FinishCompile(start, 0, err)
+		return
 	}
 
 	// lock while replacing the map
 	e.s.Lock()
 	e.m = errorPageMap
 	e.s.Unlock()
+	e.FinishCompile(start, len(errorPageMap), nil)
 }
 
 func (e *ErrorPages) internalCompile(m map[int]func(rw http.ResponseWriter)) error {
@@ -136,11 +156,12 @@ func (e *ErrorPages) internalCompile(m map[int]func(rw http.ResponseWriter)) err
 			return fmt.Errorf("failed to read html file '%s': %w", name, err)
 		}
 
-		// create a callback function to write the page
+		// create a callback function to write the page, the request id
+		// placeholder is replaced with the X-Request-Id response header
 		m[nameInt] = func(rw http.ResponseWriter) {
 			rw.Header().Set("Content-Type", "text/html; encoding=utf-8")
 			rw.WriteHeader(nameInt)
-			_, _ = rw.Write(htmlData)
+			_, _ = rw.Write(bytes.ReplaceAll(htmlData, requestIdPlaceholder, []byte(html.EscapeString(rw.Header().Get("X-Request-Id")))))
 		}
 	}
 
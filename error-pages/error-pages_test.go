@@ -1,6 +1,8 @@
 package error_pages
 
 import (
+	"github.com/1f349/violet/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"io"
 	"net/http"
@@ -31,6 +33,15 @@ func TestErrorPages_ServeError(t *testing.T) {
 	assert.Equal(t, "469 Unknown Error Code\n\n", string(a))
 }
 
+func TestErrorPages_Compile_NoDir(t *testing.T) {
+	// the compile is observed without an error pages directory
+	before := testutil.CollectAndCount(metrics.CompileDuration)
+	errorPages := New(nil)
+	errorPages.threadCompile()
+	assert.Equal(t, before+1, testutil.CollectAndCount(metrics.CompileDuration))
+	assert.Empty(t, errorPages.m)
+}
+
 func TestErrorPagesWithCustom(t *testing.T) {
 	fs := fstest.MapFS{
 		"418.html": {
@@ -62,3 +73,24 @@ func TestErrorPagesWithCustom(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "469 Custom Error Page\n", string(a))
 }
+
+func TestErrorPages_ServeError_RequestId(t *testing.T) {
+	fs := fstest.MapFS{
+		"469.html": {
+			Data: []byte("<p>Request {{request_id}}</p>\n"),
+		},
+	}
+
+	errorPages := New(fs)
+	assert.NoError(t, errorPages.internalCompile(errorPages.m))
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-Id", "abc<123>")
+	errorPages.ServeError(rec, http.StatusTeapot)
+	assert.Equal(t, "418 I'm a teapot\nRequest ID: abc<123>\n\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	rec.Header().Set("X-Request-Id", "abc<123>")
+	errorPages.ServeError(rec, 469)
+	assert.Equal(t, "<p>Request abc&lt;123&gt;</p>\n", rec.Body.String())
+}
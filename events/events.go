@@ -0,0 +1,154 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event describes a change to the configuration sent to stream subscribers.
+type Event struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`   // domain, route, redirect, cert or compile
+	Action string    `json:"action"` // e.g. create, update, delete or compile
+	Key    string    `json:"key,omitempty"`
+	Domain string    `json:"domain,omitempty"` // top level domain of the key, empty for global events
+	Data   any       `json:"data,omitempty"`
+}
+
+// Stream broadcasts configuration events to live subscribers and keeps the
+// latest events so subscribers can resume after reconnecting. Events are
+// dropped for subscribers which fall behind so publishing never blocks.
+type Stream struct {
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	recent []Event
+	size   int
+	next   uint64
+	closed bool
+}
+
+type subscriber struct {
+	c     chan Event
+	match func(Event) bool
+}
+
+// New creates a Stream which keeps the latest size events for resuming. Event
+// ids start from the current time so ids from a previous process are older
+// than every id in the new stream.
+func New(size int) *Stream {
+	return &Stream{
+		subs:   make(map[*subscriber]struct{}),
+		recent: make([]Event, 0, size),
+		size:   size,
+		next:   uint64(time.Now().UnixMicro()),
+	}
+}
+
+// Publish assigns the id and time of the event and sends it to each
+// subscriber matching it. A nil Stream ignores the event.
+func (s *Stream) Publish(e Event) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	e.ID = s.next
+	s.next++
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	if len(s.recent) == s.size && s.size > 0 {
+		s.recent = append(s.recent[:0], s.recent[1:]...)
+	}
+	if s.size > 0 {
+		s.recent = append(s.recent, e)
+	}
+
+	for sub := range s.subs {
+		if sub.match != nil && !sub.match(e) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			// drop the event for slow subscribers
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events matching the filter and a
+// function to remove the subscription. If lastId is not zero the matching
+// events published after lastId are returned as the backlog. The resumed
+// result is false if the events after lastId are no longer available. The
+// channel is closed once the subscription is removed or the Stream is closed.
+func (s *Stream) Subscribe(lastId uint64, match func(Event) bool, buffer int) (backlog []Event, resumed bool, c <-chan Event, cancel func()) {
+	sub := &subscriber{c: make(chan Event, buffer), match: match}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	backlog, resumed = s.since(lastId, match)
+	if s.closed {
+		close(sub.c)
+		return backlog, resumed, sub.c, func() {}
+	}
+	s.subs[sub] = struct{}{}
+
+	return backlog, resumed, sub.c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.subs[sub]; ok {
+			s.remove(sub)
+		}
+	}
+}
+
+// since must be called with the lock held
+func (s *Stream) since(lastId uint64, match func(Event) bool) ([]Event, bool) {
+	if lastId == 0 {
+		return nil, true
+	}
+	// the id is from the future or a previous process
+	if lastId >= s.next {
+		return nil, false
+	}
+	oldest := s.next
+	if len(s.recent) > 0 {
+		oldest = s.recent[0].ID
+	}
+	if lastId+1 < oldest {
+		return nil, false
+	}
+
+	var backlog []Event
+	for _, e := range s.recent {
+		if e.ID > lastId && (match == nil || match(e)) {
+			backlog = append(backlog, e)
+		}
+	}
+	return backlog, true
+}
+
+// Close removes all subscribers and prevents new events, this ends the
+// streams of connected clients during shutdown.
+func (s *Stream) Close() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subs {
+		s.remove(sub)
+	}
+}
+
+// remove must be called with the lock held
+func (s *Stream) remove(sub *subscriber) {
+	delete(s.subs, sub)
+	close(sub.c)
+}
@@ -0,0 +1,85 @@
+package events
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStream_Subscribe(t *testing.T) {
+	s := New(10)
+	s.Publish(Event{Type: "route", Key: "example.com/a"})
+
+	backlog, resumed, c, cancel := s.Subscribe(0, func(e Event) bool { return e.Type == "route" }, 4)
+	assert.Empty(t, backlog)
+	assert.True(t, resumed)
+
+	s.Publish(Event{Type: "redirect", Key: "example.com"})
+	s.Publish(Event{Type: "route", Key: "example.com/b"})
+	e := <-c
+	assert.Equal(t, "example.com/b", e.Key)
+	assert.NotZero(t, e.ID)
+	assert.False(t, e.Time.IsZero())
+
+	cancel()
+	_, ok := <-c
+	assert.False(t, ok)
+}
+
+func TestStream_Resume(t *testing.T) {
+	s := New(3)
+	var ids []uint64
+	for _, key := range []string{"a", "b", "c", "d"} {
+		s.Publish(Event{Type: "route", Key: key})
+		ids = append(ids, s.recent[len(s.recent)-1].ID)
+	}
+	assert.Equal(t, ids[0]+3, ids[3])
+
+	// resume after the second event
+	backlog, resumed, _, cancel := s.Subscribe(ids[1], nil, 1)
+	cancel()
+	assert.True(t, resumed)
+	if assert.Len(t, backlog, 2) {
+		assert.Equal(t, "c", backlog[0].Key)
+		assert.Equal(t, "d", backlog[1].Key)
+	}
+
+	// the oldest event after the id is still kept
+	backlog, resumed, _, cancel = s.Subscribe(ids[0], nil, 1)
+	cancel()
+	assert.True(t, resumed)
+	assert.Len(t, backlog, 3)
+
+	// up to date
+	backlog, resumed, _, cancel = s.Subscribe(ids[3], nil, 1)
+	cancel()
+	assert.True(t, resumed)
+	assert.Empty(t, backlog)
+
+	// events were dropped from the buffer
+	_, resumed, _, cancel = s.Subscribe(ids[0]-1, nil, 1)
+	cancel()
+	assert.False(t, resumed)
+
+	// an id from a later stream
+	_, resumed, _, cancel = s.Subscribe(ids[3]+100, nil, 1)
+	cancel()
+	assert.False(t, resumed)
+}
+
+func TestStream_Close(t *testing.T) {
+	s := New(1)
+	_, _, c, _ := s.Subscribe(0, nil, 1)
+	s.Close()
+	_, ok := <-c
+	assert.False(t, ok)
+
+	// closed streams drop events and close new subscriptions
+	s.Publish(Event{Type: "route"})
+	_, _, c, _ = s.Subscribe(0, nil, 1)
+	_, ok = <-c
+	assert.False(t, ok)
+
+	var nilStream *Stream
+	nilStream.Publish(Event{Type: "route"})
+	nilStream.Close()
+}
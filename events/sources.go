@@ -0,0 +1,42 @@
+package events
+
+import (
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/certs"
+	"github.com/1f349/violet/utils"
+)
+
+// WatchAudit publishes the domain, route and redirect changes recorded in the
+// audit log
+func (s *Stream) WatchAudit(l *audit.Log) {
+	l.OnRecord(func(e audit.Entry) {
+		switch e.Type {
+		case "domain", "route", "redirect":
+		default:
+			return
+		}
+		var data any
+		if e.After != nil {
+			data = e.After
+		} else if e.Before != nil {
+			data = e.Before
+		}
+		s.Publish(Event{Type: e.Type, Action: e.Action, Key: e.Key, Domain: e.Domain, Data: data})
+	})
+}
+
+// WatchCerts publishes the certificates loaded, replaced or removed by each
+// compile
+func (s *Stream) WatchCerts(c *certs.Certs) {
+	c.OnChange(func(change certs.CertChange) {
+		domain, _ := utils.GetTopFqdn(change.Domain)
+		s.Publish(Event{Type: "cert", Action: change.Action, Key: change.Domain, Domain: domain, Data: change})
+	})
+}
+
+// WatchCompile publishes the result of each compile
+func (s *Stream) WatchCompile(c utils.CompileReporter) {
+	c.OnFinish(func(status utils.CompileStatus) {
+		s.Publish(Event{Type: "compile", Action: "compile", Key: status.Name, Data: status})
+	})
+}
@@ -0,0 +1,55 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/1f349/violet"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestStream_WatchAudit(t *testing.T) {
+	db, err := violet.InitDB("file:TestStream_WatchAudit?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	log := audit.New(db)
+	s := New(10)
+	s.WatchAudit(log)
+	_, _, c, cancel := s.Subscribe(0, nil, 4)
+	defer cancel()
+
+	// favicon changes aren't published
+	log.Record(context.Background(), "alice", "create", "favicon", "example.com", nil, map[string]string{"png": "https://example.com/a.png"})
+	log.Record(context.Background(), "alice", "delete", "route", "www.example.com/a", map[string]string{"dst": "127.0.0.1:8080"}, nil)
+
+	e := <-c
+	assert.Equal(t, "route", e.Type)
+	assert.Equal(t, "delete", e.Action)
+	assert.Equal(t, "www.example.com/a", e.Key)
+	assert.Equal(t, "example.com", e.Domain)
+	assert.Equal(t, `{"dst":"127.0.0.1:8080"}`, string(e.Data.(json.RawMessage)))
+	assert.Empty(t, c)
+}
+
+func TestStream_WatchCompile(t *testing.T) {
+	f := fake.NewReportingCompilable("router")
+	f.Items = 2
+	s := New(10)
+	s.WatchCompile(f)
+	_, _, c, cancel := s.Subscribe(0, nil, 4)
+	defer cancel()
+
+	f.Compile()
+	select {
+	case e := <-c:
+		assert.Equal(t, "compile", e.Type)
+		assert.Equal(t, "router", e.Key)
+		assert.Equal(t, "", e.Domain)
+		assert.Equal(t, 2, e.Data.(utils.CompileStatus).Items)
+	case <-time.After(time.Second):
+		assert.Fail(t, "missing compile event")
+	}
+}
@@ -3,6 +3,7 @@ package favicons
 import (
 	"bytes"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -19,6 +20,23 @@ type FaviconList struct {
 	Svg *FaviconImage
 }
 
+// FaviconUrls contains the source urls of the icons configured for a host
+type FaviconUrls struct {
+	Host string `json:"host" yaml:"host"`
+	Svg  string `json:"svg,omitempty" yaml:"svg,omitempty"`
+	Png  string `json:"png,omitempty" yaml:"png,omitempty"`
+	Ico  string `json:"ico,omitempty" yaml:"ico,omitempty"`
+}
+
+// createFaviconList outputs a FaviconList containing the icons with urls.
+func createFaviconList(svg, png, ico sql.NullString) *FaviconList {
+	return &FaviconList{
+		Ico: CreateFaviconImage(ico),
+		Png: CreateFaviconImage(png),
+		Svg: CreateFaviconImage(svg),
+	}
+}
+
 var ErrInvalidFaviconExtension = errors.New("invalid favicon extension")
 
 // ProduceForExt outputs the bytes for the ico/png/svg icon and the HTTP
@@ -2,14 +2,20 @@ package favicons
 
 import (
 	"context"
+	"database/sql"
 	_ "embed"
 	"errors"
 	"fmt"
 	"github.com/1f349/violet/database"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
+	"github.com/1f349/violet/utils"
 	"github.com/mrmelon54/rescheduler"
 	"golang.org/x/sync/errgroup"
+	"net/url"
+	"slices"
 	"sync"
+	"time"
 )
 
 var Logger = logger.Logger.WithPrefix("Violet Favicons")
@@ -23,6 +29,14 @@ type Favicons struct {
 	cLock      *sync.RWMutex
 	faviconMap map[string]*FaviconList
 	r          *rescheduler.Rescheduler
+	hLock      *sync.Mutex
+
+	// updated holds the hosts compiled while a full compile is running so the
+	// full compile doesn't replace them with older entries, this is nil when
+	// no full compile is running and is guarded by cLock
+	updated map[string]struct{}
+
+	*utils.CompileTracker
 }
 
 // New creates a new dynamic favicon generator
@@ -32,6 +46,9 @@ func New(db *database.Queries, inkscapeCmd string) *Favicons {
 		cmd:        inkscapeCmd,
 		cLock:      &sync.RWMutex{},
 		faviconMap: make(map[string]*FaviconList),
+		hLock:      &sync.Mutex{},
+
+		CompileTracker: utils.NewCompileTracker("favicons"),
 	}
 	f.r = rescheduler.NewRescheduler(f.threadCompile)
 
@@ -61,21 +78,42 @@ func (f *Favicons) Compile() {
 }
 
 func (f *Favicons) threadCompile() {
+	start := time.Now()
+
 	// new map
 	favicons := make(map[string]*FaviconList)
 
+	// track the hosts compiled while loading the icons
+	f.cLock.Lock()
+	f.updated = make(map[string]struct{})
+	f.cLock.Unlock()
+
 	// compile map and check errors
 	err := f.internalCompile(favicons)
+	metrics.ObserveCompile("favicons", start, err)
 	if err != nil {
 		// log compile errors
 		Logger.Info("Compile failed", "err", err)
+		f.cLock.Lock()
+		f.updated = nil
+		f.cLock.Unlock()
+		f.FinishCompile(start, 0, err)
 		return
 	}
 
-	// lock while replacing the map
+	// lock while replacing the map, keeping the newer host entries
 	f.cLock.Lock()
+	for host := range f.updated {
+		if l, ok := f.faviconMap[host]; ok {
+			favicons[host] = l
+		} else {
+			delete(favicons, host)
+		}
+	}
 	f.faviconMap = favicons
+	f.updated = nil
 	f.cLock.Unlock()
+	f.FinishCompile(start, len(favicons), nil)
 }
 
 // internalCompile is a hidden internal method for loading and generating all
@@ -91,11 +129,7 @@ func (f *Favicons) internalCompile(m map[string]*FaviconList) error {
 	var g errgroup.Group
 	for _, row := range rows {
 		// create favicon list for this row
-		l := &FaviconList{
-			Ico: CreateFaviconImage(row.Ico),
-			Png: CreateFaviconImage(row.Png),
-			Svg: CreateFaviconImage(row.Svg),
-		}
+		l := createFaviconList(row.Svg, row.Png, row.Ico)
 
 		// save the favicon list to the map
 		m[row.Host] = l
@@ -108,6 +142,134 @@ func (f *Favicons) internalCompile(m map[string]*FaviconList) error {
 	return g.Wait()
 }
 
+// CompileHost reloads the favicons for a single host from the database without
+// downloading the icons for every other host.
+func (f *Favicons) CompileHost(host string) {
+	go f.threadCompileHost(host)
+}
+
+func (f *Favicons) threadCompileHost(host string) {
+	// host compiles are run one at a time so the latest database state is
+	// always the last to be saved
+	f.hLock.Lock()
+	defer f.hLock.Unlock()
+
+	start := time.Now()
+	l, err := f.internalCompileHost(host)
+	metrics.ObserveCompile("favicons", start, err)
+	if err != nil {
+		Logger.Info("Compile failed", "host", host, "err", err)
+		f.FinishCompile(start, 0, fmt.Errorf("failed to compile host %s: %w", host, err))
+		return
+	}
+
+	// lock while replacing the host
+	f.cLock.Lock()
+	if f.updated != nil {
+		f.updated[host] = struct{}{}
+	}
+	if l == nil {
+		delete(f.faviconMap, host)
+	} else {
+		f.faviconMap[host] = l
+	}
+	f.cLock.Unlock()
+}
+
+// internalCompileHost loads and generates the favicons for a single host, a
+// nil list is returned if the host has no favicons.
+func (f *Favicons) internalCompileHost(host string) (*FaviconList, error) {
+	row, err := f.db.GetFavicon(context.Background(), host)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query host: %w", err)
+	}
+
+	l := createFaviconList(row.Svg, row.Png, row.Ico)
+	if err := l.PreProcess(f.convertSvgToPng); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// GetAllFavicons returns the favicon urls for hosts on the provided domains.
+func (f *Favicons) GetAllFavicons(domains []string) ([]FaviconUrls, error) {
+	if len(domains) < 1 {
+		return []FaviconUrls{}, nil
+	}
+
+	rows, err := f.db.GetFavicons(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	s := make([]FaviconUrls, 0)
+	for _, row := range rows {
+		if slices.ContainsFunc(domains, func(domain string) bool {
+			return utils.IsDomainOrSubdomain(row.Host, domain)
+		}) {
+			s = append(s, FaviconUrls{
+				Host: row.Host,
+				Svg:  row.Svg.String,
+				Png:  row.Png.String,
+				Ico:  row.Ico.String,
+			})
+		}
+	}
+	return s, nil
+}
+
+// GetFavicon returns the favicon urls for the host or ErrFaviconNotFound.
+func (f *Favicons) GetFavicon(host string) (FaviconUrls, error) {
+	row, err := f.db.GetFavicon(context.Background(), host)
+	if errors.Is(err, sql.ErrNoRows) {
+		return FaviconUrls{}, ErrFaviconNotFound
+	}
+	if err != nil {
+		return FaviconUrls{}, err
+	}
+	return FaviconUrls{
+		Host: row.Host,
+		Svg:  row.Svg.String,
+		Png:  row.Png.String,
+		Ico:  row.Ico.String,
+	}, nil
+}
+
+// InsertFavicon adds or replaces the favicon urls for a host.
+func (f *Favicons) InsertFavicon(urls FaviconUrls) error {
+	return f.db.UpdateFaviconCache(context.Background(), database.UpdateFaviconCacheParams{
+		Host: urls.Host,
+		Svg:  sql.NullString{String: urls.Svg, Valid: urls.Svg != ""},
+		Png:  sql.NullString{String: urls.Png, Valid: urls.Png != ""},
+		Ico:  sql.NullString{String: urls.Ico, Valid: urls.Ico != ""},
+	})
+}
+
+// DeleteFavicon removes the favicon urls for a host or returns
+// ErrFaviconNotFound.
+func (f *Favicons) DeleteFavicon(host string) error {
+	n, err := f.db.DeleteFavicon(context.Background(), host)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrFaviconNotFound
+	}
+	return nil
+}
+
+// ValidUrl returns true for absolute http and https urls
+func ValidUrl(a string) bool {
+	u, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // convertSvgToPng calls svg2png which runs inkscape in a subprocess
 func (f *Favicons) convertSvgToPng(in []byte) ([]byte, error) {
 	return svg2png(f.cmd, in)
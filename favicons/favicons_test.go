@@ -5,11 +5,14 @@ import (
 	"context"
 	"database/sql"
 	_ "embed"
+	"errors"
 	"github.com/1f349/violet"
 	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/utils"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
 	"image/png"
+	"sync"
 	"testing"
 )
 
@@ -53,3 +56,114 @@ func TestFaviconsNew(t *testing.T) {
 	_, err = png.Decode(pngRaw)
 	assert.NoError(t, err)
 }
+
+func TestFavicons_CompileHost(t *testing.T) {
+	getFaviconViaRequest = func(_ string) ([]byte, error) { return examplePng, nil }
+
+	db, err := violet.InitDB("file:TestFavicons_CompileHost?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	// skip the initial full compile
+	favicons := &Favicons{
+		db:         db,
+		cmd:        "inkscape",
+		cLock:      &sync.RWMutex{},
+		faviconMap: make(map[string]*FaviconList),
+		hLock:      &sync.Mutex{},
+	}
+	assert.NoError(t, favicons.InsertFavicon(FaviconUrls{Host: "example.com", Png: "https://example.com/assets/logo.png"}))
+	assert.NoError(t, favicons.InsertFavicon(FaviconUrls{Host: "example.org", Png: "https://example.org/assets/logo.png"}))
+
+	// only the requested host is loaded
+	favicons.threadCompileHost("example.com")
+	icons := favicons.GetIcons("example.com")
+	if assert.NotNil(t, icons) {
+		assert.Equal(t, "https://example.com/assets/logo.png", icons.Png.Url)
+		assert.Nil(t, icons.Svg)
+		assert.NotEqual(t, "", icons.Ico.Hash)
+	}
+	assert.Nil(t, favicons.GetIcons("example.org"))
+
+	// replacing the urls doesn't create another row
+	assert.NoError(t, favicons.InsertFavicon(FaviconUrls{Host: "example.com", Png: "https://example.com/assets/logo2.png"}))
+	all, err := favicons.GetAllFavicons([]string{"example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, []FaviconUrls{{Host: "example.com", Png: "https://example.com/assets/logo2.png"}}, all)
+
+	// deleting the host removes the loaded icons
+	assert.NoError(t, favicons.DeleteFavicon("example.com"))
+	assert.ErrorIs(t, favicons.DeleteFavicon("example.com"), ErrFaviconNotFound)
+	_, err = favicons.GetFavicon("example.com")
+	assert.ErrorIs(t, err, ErrFaviconNotFound)
+	favicons.threadCompileHost("example.com")
+	assert.Nil(t, favicons.GetIcons("example.com"))
+}
+
+func TestFavicons_CompileHost_DuringCompile(t *testing.T) {
+	fetching := make(chan struct{})
+	release := make(chan struct{})
+	getFaviconViaRequest = func(u string) ([]byte, error) {
+		if u == "https://example.org/assets/logo.png" {
+			close(fetching)
+			<-release
+		}
+		return examplePng, nil
+	}
+
+	db, err := violet.InitDB("file:TestFavicons_CompileHost_DuringCompile?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	favicons := &Favicons{
+		db:             db,
+		cmd:            "inkscape",
+		cLock:          &sync.RWMutex{},
+		faviconMap:     make(map[string]*FaviconList),
+		hLock:          &sync.Mutex{},
+		CompileTracker: utils.NewCompileTracker("favicons"),
+	}
+	assert.NoError(t, favicons.InsertFavicon(FaviconUrls{Host: "example.com", Png: "https://example.com/assets/logo.png"}))
+	assert.NoError(t, favicons.InsertFavicon(FaviconUrls{Host: "example.org", Png: "https://example.org/assets/logo.png"}))
+
+	done := make(chan struct{})
+	go func() {
+		favicons.threadCompile()
+		close(done)
+	}()
+
+	// change a host after the full compile has read the rows
+	<-fetching
+	assert.NoError(t, favicons.InsertFavicon(FaviconUrls{Host: "example.com", Png: "https://example.com/assets/logo2.png"}))
+	favicons.threadCompileHost("example.com")
+	close(release)
+	<-done
+
+	// the full compile keeps the newer host entry
+	icons := favicons.GetIcons("example.com")
+	if assert.NotNil(t, icons) {
+		assert.Equal(t, "https://example.com/assets/logo2.png", icons.Png.Url)
+	}
+	assert.NotNil(t, favicons.GetIcons("example.org"))
+}
+
+func TestFavicons_CompileHost_Failed(t *testing.T) {
+	getFaviconViaRequest = func(_ string) ([]byte, error) { return nil, errors.New("unavailable") }
+
+	db, err := violet.InitDB("file:TestFavicons_CompileHost_Failed?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	favicons := &Favicons{
+		db:             db,
+		cmd:            "inkscape",
+		cLock:          &sync.RWMutex{},
+		faviconMap:     make(map[string]*FaviconList),
+		hLock:          &sync.Mutex{},
+		CompileTracker: utils.NewCompileTracker("favicons"),
+	}
+	assert.NoError(t, favicons.InsertFavicon(FaviconUrls{Host: "example.com", Png: "https://example.com/assets/logo.png"}))
+
+	// a failed host compile is reported in the compile status
+	favicons.threadCompileHost("example.com")
+	status := favicons.CompileStatus()
+	assert.False(t, status.Success)
+	assert.Contains(t, status.Error, "example.com")
+}
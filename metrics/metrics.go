@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "violet"
+
+// Registry contains all the metrics exposed by the API server.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	// Requests counts the requests handled by the HTTP and HTTPS servers.
+	Requests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Total number of requests by host, route source and status.",
+	}, []string{"host", "route", "status"})
+
+	// RequestDuration records the time taken to respond to a request.
+	RequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to respond to requests by host, route source and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"host", "route", "status"})
+
+	// RequestBytes counts the bytes received in request bodies.
+	RequestBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_request_bytes_total",
+		Help:      "Total bytes received in request bodies by host, route source and status.",
+	}, []string{"host", "route", "status"})
+
+	// ResponseBytes counts the bytes sent in response bodies.
+	ResponseBytes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_response_bytes_total",
+		Help:      "Total bytes sent in response bodies by host, route source and status.",
+	}, []string{"host", "route", "status"})
+
+	// UpstreamErrors counts failed round trips to the route destination.
+	UpstreamErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Total number of failed upstream requests by destination.",
+	}, []string{"destination"})
+
+	// RateLimitRejections counts requests rejected by the rate limiter.
+	RateLimitRejections = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Total number of requests rejected by the rate limiter.",
+	})
+
+	// WebsocketConnections tracks the open websocket connections.
+	WebsocketConnections = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Number of open websocket connections by route source.",
+	}, []string{"route"})
+
+	// CompileDuration records the time taken by each compilable.
+	CompileDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "compile_duration_seconds",
+		Help:      "Time taken to compile by compilable.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"compilable"})
+
+	// CompileFailures counts compiles which returned an error.
+	CompileFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "compile_failures_total",
+		Help:      "Total number of failed compiles by compilable.",
+	}, []string{"compilable"})
+
+	// CertificateExpiry stores the expiry time of each loaded certificate.
+	CertificateExpiry = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "certificate_expiry_timestamp_seconds",
+		Help:      "Unix time when the certificate for the domain expires.",
+	}, []string{"domain"})
+)
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// ObserveCompile records the duration and result of a compile which started
+// at the provided time.
+func ObserveCompile(compilable string, start time.Time, err error) {
+	CompileDuration.WithLabelValues(compilable).Observe(time.Since(start).Seconds())
+	if err != nil {
+		CompileFailures.WithLabelValues(compilable).Inc()
+	}
+}
+
+// Handler returns a http handler which outputs all metrics in the registry.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
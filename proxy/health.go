@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// unhealthyAfter is the number of consecutive failed round trips before a
+// backend is marked unhealthy
+const unhealthyAfter = 5
+
+// BackendHealth describes a backend marked unhealthy or healthy again
+type BackendHealth struct {
+	Backend  string `json:"backend"` // host and port of the backend
+	Healthy  bool   `json:"healthy"`
+	Failures int    `json:"failures,omitempty"` // consecutive failed round trips
+	Error    string `json:"error,omitempty"`    // error from the last round trip
+}
+
+// healthTracker counts the consecutive failed round trips to each backend
+type healthTracker struct {
+	mu       sync.Mutex
+	failures map[string]int
+	notify   []func(BackendHealth)
+}
+
+// OnBackendHealth adds a function called when a backend is marked unhealthy
+// after consecutive failed round trips and when it responds again
+func (h *HybridTransport) OnBackendHealth(fn func(BackendHealth)) {
+	h.health.mu.Lock()
+	defer h.health.mu.Unlock()
+	h.health.notify = append(h.health.notify, fn)
+}
+
+// observe records the result of a round trip to the backend
+func (t *healthTracker) observe(backend string, err error) {
+	// cancelled requests say nothing about the backend
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	t.mu.Lock()
+	if len(t.notify) == 0 {
+		t.mu.Unlock()
+		return
+	}
+	n := t.failures[backend]
+	var change *BackendHealth
+	if err == nil {
+		if n >= unhealthyAfter {
+			change = &BackendHealth{Backend: backend, Healthy: true}
+		}
+		delete(t.failures, backend)
+	} else {
+		n++
+		t.failures[backend] = n
+		if n == unhealthyAfter {
+			change = &BackendHealth{Backend: backend, Failures: n, Error: err.Error()}
+		}
+	}
+	notify := t.notify
+	t.mu.Unlock()
+
+	if change != nil {
+		for _, fn := range notify {
+			fn(*change)
+		}
+	}
+}
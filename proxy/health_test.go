@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHybridTransport_OnBackendHealth(t *testing.T) {
+	var fail error
+	trip := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if fail != nil {
+			return nil, fail
+		}
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})
+	h := NewHybridTransportWithCalls(trip, trip, nil)
+
+	var changes []BackendHealth
+	h.OnBackendHealth(func(b BackendHealth) { changes = append(changes, b) })
+
+	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:8080", nil)
+	assert.NoError(t, err)
+
+	// cancelled requests are ignored
+	fail = context.Canceled
+	for range unhealthyAfter {
+		_, _ = h.SecureRoundTrip(req)
+	}
+	assert.Len(t, changes, 0)
+
+	// a success resets the failures
+	fail = errors.New("connection refused")
+	for range unhealthyAfter - 1 {
+		_, _ = h.SecureRoundTrip(req)
+	}
+	fail = nil
+	_, _ = h.SecureRoundTrip(req)
+	assert.Len(t, changes, 0)
+
+	// the backend is only marked unhealthy once
+	fail = errors.New("connection refused")
+	for range unhealthyAfter * 2 {
+		_, _ = h.InsecureRoundTrip(req)
+	}
+	assert.Equal(t, []BackendHealth{{Backend: "127.0.0.1:8080", Failures: unhealthyAfter, Error: "connection refused"}}, changes)
+
+	fail = nil
+	_, _ = h.SecureRoundTrip(req)
+	_, _ = h.SecureRoundTrip(req)
+	assert.Len(t, changes, 2)
+	assert.Equal(t, BackendHealth{Backend: "127.0.0.1:8080", Healthy: true}, changes[1])
+}
@@ -21,6 +21,7 @@ type HybridTransport struct {
 	socksSync         *sync.RWMutex
 	socksTransport    map[string]http.RoundTripper
 	ws                *websocket.Server
+	health            *healthTracker
 }
 
 // NewHybridTransport creates a new hybrid transport
@@ -41,6 +42,7 @@ func NewHybridTransportWithCalls(normal, insecure http.RoundTripper, ws *websock
 		normalTransport:   normal,
 		insecureTransport: insecure,
 		ws:                ws,
+		health:            &healthTracker{failures: make(map[string]int)},
 	}
 	if h.normalTransport == nil {
 		h.normalTransport = &http.Transport{
@@ -74,15 +76,29 @@ func NewHybridTransportWithCalls(normal, insecure http.RoundTripper, ws *websock
 
 // SecureRoundTrip calls the secure transport
 func (h *HybridTransport) SecureRoundTrip(req *http.Request) (*http.Response, error) {
-	return h.normalTransport.RoundTrip(req)
+	resp, err := h.normalTransport.RoundTrip(req)
+	h.health.observe(req.URL.Host, err)
+	return resp, err
 }
 
 // InsecureRoundTrip calls the insecure transport
 func (h *HybridTransport) InsecureRoundTrip(req *http.Request) (*http.Response, error) {
-	return h.insecureTransport.RoundTrip(req)
+	resp, err := h.insecureTransport.RoundTrip(req)
+	h.health.observe(req.URL.Host, err)
+	return resp, err
 }
 
 // ConnectWebsocket calls the websocket upgrader and thus hijacks the connection
-func (h *HybridTransport) ConnectWebsocket(rw http.ResponseWriter, req *http.Request) {
-	h.ws.Upgrade(rw, req)
+func (h *HybridTransport) ConnectWebsocket(rw http.ResponseWriter, req *http.Request, d websocket.Details) {
+	h.ws.Upgrade(rw, req, d)
+}
+
+// TunnelWebsocket sends the upgrade using the secure or insecure transport then
+// hijacks the connection and splices it with the internal server
+func (h *HybridTransport) TunnelWebsocket(rw http.ResponseWriter, req *http.Request, d websocket.Details, insecure bool) {
+	if insecure {
+		h.ws.Tunnel(rw, req, d, h.InsecureRoundTrip)
+	} else {
+		h.ws.Tunnel(rw, req, d, h.SecureRoundTrip)
+	}
 }
@@ -0,0 +1,204 @@
+package websocket
+
+import (
+	"errors"
+	"github.com/1f349/violet/metrics"
+	"github.com/google/uuid"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrShuttingDown = errors.New("websocket server is shutting down")
+	ErrRouteLimit   = errors.New("websocket route limit reached")
+)
+
+// Details describes the route and client used to open a websocket connection.
+type Details struct {
+	Route  string // source of the matched route
+	Host   string // host requested by the client
+	Remote string // remote address of the client
+	Limit  int64  // maximum concurrent connections on the route, zero is unlimited
+
+	// OnHandshake is called once with the status code sent to the client when
+	// the handshake completes or fails, this may be nil
+	OnHandshake func(status int)
+}
+
+// handshakeFunc returns a function which calls OnHandshake at most once
+func (d Details) handshakeFunc() func(status int) {
+	var once sync.Once
+	return func(status int) {
+		once.Do(func() {
+			if d.OnHandshake != nil {
+				d.OnHandshake(status)
+			}
+		})
+	}
+}
+
+// ConnInfo contains the information about an active websocket connection.
+type ConnInfo struct {
+	ID         string    `json:"id"`
+	Route      string    `json:"route"`
+	Host       string    `json:"host"`
+	Remote     string    `json:"remote"`
+	Tunnel     bool      `json:"tunnel"`
+	Started    time.Time `json:"started"`
+	LastActive time.Time `json:"last_active"`
+	BytesIn    int64     `json:"bytes_in"`  // bytes received from the client
+	BytesOut   int64     `json:"bytes_out"` // bytes sent to the client
+}
+
+// conn is a tracked websocket connection, the close functions are set once
+// both sides of the connection are open. The going away function asks both
+// sides to close the connection instead of dropping it.
+type conn struct {
+	info      ConnInfo
+	in        atomic.Int64
+	out       atomic.Int64
+	last      atomic.Int64
+	close     func()
+	goingAway func()
+	closed    bool
+}
+
+// Info returns a snapshot of the connection information.
+func (c *conn) Info() ConnInfo {
+	i := c.info
+	i.LastActive = c.lastActive()
+	i.BytesIn = c.in.Load()
+	i.BytesOut = c.out.Load()
+	return i
+}
+
+// addIn records bytes received from the client.
+func (c *conn) addIn(n int) {
+	c.in.Add(int64(n))
+	c.last.Store(time.Now().UnixNano())
+}
+
+// addOut records bytes sent to the client.
+func (c *conn) addOut(n int) {
+	c.out.Add(int64(n))
+	c.last.Store(time.Now().UnixNano())
+}
+
+// lastActive returns the time data was last relayed.
+func (c *conn) lastActive() time.Time {
+	return time.Unix(0, c.last.Load())
+}
+
+// track reserves a connection slot for the route, an error is returned if the
+// server is shutting down or the route limit has been reached.
+func (s *Server) track(d Details, tunnel bool) (*conn, error) {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+
+	// no more connections allowed
+	if s.connStop {
+		return nil, ErrShuttingDown
+	}
+
+	// count open connections on the route
+	if d.Limit > 0 {
+		var n int64
+		for _, c := range s.conns {
+			if c.info.Route == d.Route {
+				n++
+			}
+		}
+		if n >= d.Limit {
+			return nil, ErrRouteLimit
+		}
+	}
+
+	c := &conn{info: ConnInfo{
+		ID:      uuid.NewString(),
+		Route:   d.Route,
+		Host:    d.Host,
+		Remote:  d.Remote,
+		Tunnel:  tunnel,
+		Started: time.Now(),
+	}}
+	c.last.Store(c.info.Started.UnixNano())
+	s.conns[c.info.ID] = c
+	metrics.WebsocketConnections.WithLabelValues(d.Route).Inc()
+	return c, nil
+}
+
+// attach sets the functions used to close the connection, false is returned
+// if the connection was closed or the server started shutting down before both
+// sides were open. The going away
+// function may be nil if the connection can only be dropped.
+func (s *Server) attach(c *conn, closeFunc, goingAway func()) bool {
+	s.connLock.Lock()
+	defer s.connLock.Unlock()
+	if c.closed || s.connStop {
+		return false
+	}
+	c.close = closeFunc
+	c.goingAway = goingAway
+	return true
+}
+
+// untrack removes the connection from the tracked connections.
+func (s *Server) untrack(c *conn) {
+	s.connLock.Lock()
+	s.remove(c)
+	s.connLock.Unlock()
+}
+
+// remove marks the connection as closed and deletes it from the tracked
+// connections, the connection lock must be held.
+func (s *Server) remove(c *conn) {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	delete(s.conns, c.info.ID)
+	metrics.WebsocketConnections.WithLabelValues(c.info.Route).Dec()
+}
+
+// Conns returns the information about all active connections sorted by the
+// start time.
+func (s *Server) Conns() []ConnInfo {
+	s.connLock.RLock()
+	a := make([]ConnInfo, 0, len(s.conns))
+	for _, c := range s.conns {
+		a = append(a, c.Info())
+	}
+	s.connLock.RUnlock()
+
+	slices.SortFunc(a, func(a, b ConnInfo) int {
+		return a.Started.Compare(b.Started)
+	})
+	return a
+}
+
+// CloseFunc closes all active connections matching the function and returns
+// the number of connections closed.
+func (s *Server) CloseFunc(match func(info ConnInfo) bool) int {
+	s.connLock.Lock()
+	n := 0
+	closers := make([]func(), 0)
+	for _, c := range s.conns {
+		if !match(c.Info()) {
+			continue
+		}
+		n++
+		s.remove(c)
+		if c.close != nil {
+			closers = append(closers, c.close)
+		}
+	}
+	s.connLock.Unlock()
+
+	// close outside the lock as this may block
+	for _, f := range closers {
+		f()
+	}
+	return n
+}
@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"time"
+)
+
+const (
+	// writeWait is the time allowed to write a control message
+	writeWait = 5 * time.Second
+
+	// shutdownPollInterval is how often Shutdown checks for closed connections
+	shutdownPollInterval = 50 * time.Millisecond
+)
+
+// Options configures the limits applied to websocket connections, the zero
+// value of each option disables the limit.
+type Options struct {
+	IdleTimeout    time.Duration // close connections after no activity for this long
+	PingInterval   time.Duration // interval between pings sent to both sides of relayed connections
+	MaxMessageSize int64         // maximum size of a relayed message in bytes
+	MessageRate    float64       // messages per second allowed from the client of a relayed connection
+	MessageBurst   int           // number of messages allowed to exceed the message rate
+}
+
+// prepareConn applies the read limit and idle timeout to a relayed connection,
+// pings and pongs from the peer also reset the idle timeout.
+func (s *Server) prepareConn(c *websocket.Conn) {
+	if s.opts.MaxMessageSize > 0 {
+		c.SetReadLimit(s.opts.MaxMessageSize)
+	}
+	s.extendDeadline(c)
+	c.SetPongHandler(func(string) error {
+		s.extendDeadline(c)
+		return nil
+	})
+	c.SetPingHandler(func(data string) error {
+		s.extendDeadline(c)
+
+		// this matches the default ping handler
+		err := c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return nil
+		}
+		return err
+	})
+}
+
+// extendDeadline moves the read deadline forward by the idle timeout
+func (s *Server) extendDeadline(c *websocket.Conn) {
+	if s.opts.IdleTimeout > 0 {
+		_ = c.SetReadDeadline(time.Now().Add(s.opts.IdleTimeout))
+	}
+}
+
+// keepalive sends pings to each connection until done is closed or a ping
+// fails to send
+func (s *Server) keepalive(done <-chan struct{}, conns ...*websocket.Conn) {
+	if s.opts.PingInterval <= 0 {
+		return
+	}
+	t := time.NewTicker(s.opts.PingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+			for _, c := range conns {
+				if c.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)) != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// idleWatch calls the close function once no data has been relayed through a
+// tunnelled connection for the idle timeout
+func (s *Server) idleWatch(done <-chan struct{}, tc *conn, closeFunc func()) {
+	if s.opts.IdleTimeout <= 0 {
+		return
+	}
+	t := time.NewTicker(s.opts.IdleTimeout / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-t.C:
+			if now.Sub(tc.lastActive()) > s.opts.IdleTimeout {
+				Logger.Info("Closing idle websocket tunnel", "id", tc.info.ID)
+				closeFunc()
+				return
+			}
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package websocket
 
 import (
+	"context"
+	"fmt"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/utils"
 	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+	"io"
 	"net/http"
 	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,55 +31,102 @@ var upgrader = websocket.Upgrader{
 type Server struct {
 	connLock *sync.RWMutex
 	connStop bool
-	conns    map[string]*websocket.Conn
+	conns    map[string]*conn
+	opts     Options
 }
 
+// NewServer creates a websocket server without any connection limits
 func NewServer() *Server {
+	return NewServerWithOptions(Options{})
+}
+
+// NewServerWithOptions creates a websocket server which applies the options to
+// each connection
+func NewServerWithOptions(opts Options) *Server {
 	return &Server{
 		connLock: new(sync.RWMutex),
-		conns:    make(map[string]*websocket.Conn),
+		conns:    make(map[string]*conn),
+		opts:     opts,
 	}
 }
 
-func (s *Server) Upgrade(rw http.ResponseWriter, req *http.Request) {
+func (s *Server) Upgrade(rw http.ResponseWriter, req *http.Request, d Details) {
 	req.URL.Scheme = "ws"
 	Logger.Info("Upgrading request", "url", req.URL, "origin", req.Header.Get("Origin"))
 
-	c, err := upgrader.Upgrade(rw, req, nil)
-	if err != nil {
-		return
-	}
+	// report a bad gateway unless another status is reported first
+	handshake := d.handshakeFunc()
+	defer handshake(http.StatusBadGateway)
 
-	defer c.Close()
-	s.connLock.Lock()
-
-	// no more connections allowed
-	if s.connStop {
-		s.connLock.Unlock()
+	// reserve a connection slot before dialing
+	tc, err := s.track(d, false)
+	if err != nil {
+		Logger.Info("Rejected websocket", "url", req.URL, "err", err)
+		handshake(http.StatusServiceUnavailable)
+		utils.RespondVioletError(rw, http.StatusServiceUnavailable, err.Error())
 		return
 	}
-
-	// save connection for shutdown
-	s.conns[c.RemoteAddr().String()] = c
-	s.connLock.Unlock()
+	defer s.untrack(tc)
 
 	Logger.Info("Dialing", "url", req.URL)
 
-	// dial for internal connection
-	ic, _, err := websocket.DefaultDialer.DialContext(req.Context(), req.URL.String(), filterWebsocketHeaders(req.Header))
+	// dial for internal connection before upgrading so the handshake response
+	// from the internal server can be forwarded to the client
+	ic, resp, err := websocket.DefaultDialer.DialContext(req.Context(), req.URL.String(), filterWebsocketHeaders(req))
 	if err != nil {
 		Logger.Info("Failed to dial", "url", req.URL, "err", err)
-		s.Remove(c)
+
+		// a rejected handshake is forwarded so the client can handle it
+		if resp != nil {
+			handshake(resp.StatusCode)
+			copyHandshakeResponse(rw, resp)
+			return
+		}
+		utils.RespondVioletError(rw, http.StatusBadGateway, "Failed to dial internal websocket")
 		return
 	}
 	defer ic.Close()
 
+	// the subprotocol selected by the internal server is returned to the client
+	c, err := upgrader.Upgrade(rw, req, filterWebsocketResponseHeaders(resp.Header))
+	if err != nil {
+		handshake(http.StatusBadRequest)
+		return
+	}
+	defer c.Close()
+	handshake(http.StatusSwitchingProtocols)
+
+	// the connection may have been closed while dialing
+	if !s.attach(tc, func() {
+		_ = c.Close()
+		_ = ic.Close()
+	}, func() {
+		// the relay stops once either side replies with a close frame
+		m := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		_ = c.WriteControl(websocket.CloseMessage, m, time.Now().Add(writeWait))
+		_ = ic.WriteControl(websocket.CloseMessage, m, time.Now().Add(writeWait))
+	}) {
+		return
+	}
+
+	s.prepareConn(c)
+	s.prepareConn(ic)
+
+	// the rate limit only applies to messages from the client
+	var limiter *rate.Limiter
+	if s.opts.MessageRate > 0 {
+		limiter = rate.NewLimiter(rate.Limit(s.opts.MessageRate), max(s.opts.MessageBurst, 1))
+	}
+
 	d1 := make(chan struct{}, 1)
 	d2 := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
 
 	// relay messages each way
-	go s.wsRelay(d1, c, ic)
-	go s.wsRelay(d2, ic, c)
+	go s.wsRelay(d1, c, ic, tc.addIn, limiter)
+	go s.wsRelay(d2, ic, c, tc.addOut, nil)
+	go s.keepalive(stop, c, ic)
 
 	// wait for done signal and close both connections
 	Logger.Info("Completed websocket hijacking")
@@ -85,18 +138,180 @@ func (s *Server) Upgrade(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// filterWebsocketHeaders allows specific headers to forward to the underlying websocket connection
-func filterWebsocketHeaders(headers http.Header) (out http.Header) {
-	out = make(http.Header)
-	for k, v := range headers {
-		if k == "Origin" {
-			out[k] = slices.Clone(v)
-		}
+// Tunnel sends the upgrade request to the internal server using the round trip
+// function then hijacks the client connection and splices the raw streams
+// together. Unlike Upgrade the websocket is not terminated so extensions and
+// control frames are passed through unchanged.
+func (s *Server) Tunnel(rw http.ResponseWriter, req *http.Request, d Details, roundTrip func(req *http.Request) (*http.Response, error)) {
+	Logger.Info("Tunnelling request", "url", req.URL, "origin", req.Header.Get("Origin"))
+
+	// report a bad gateway unless another status is reported first
+	handshake := d.handshakeFunc()
+	defer handshake(http.StatusBadGateway)
+
+	// reserve a connection slot before sending the request
+	tc, err := s.track(d, true)
+	if err != nil {
+		Logger.Info("Rejected websocket", "url", req.URL, "err", err)
+		handshake(http.StatusServiceUnavailable)
+		utils.RespondVioletError(rw, http.StatusServiceUnavailable, err.Error())
+		return
 	}
+	defer s.untrack(tc)
+
+	resp, err := roundTrip(req)
+	if err != nil {
+		Logger.Info("Failed to send upgrade", "url", req.URL, "err", err)
+		utils.RespondVioletError(rw, http.StatusBadGateway, "Error receiving internal round trip response")
+		return
+	}
+	defer resp.Body.Close()
+
+	// a rejected upgrade is forwarded so the client can handle it
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		handshake(resp.StatusCode)
+		copyHandshakeResponse(rw, resp)
+		return
+	}
+
+	reqUpType := utils.UpgradeType(req.Header)
+	resUpType := utils.UpgradeType(resp.Header)
+	if !strings.EqualFold(reqUpType, resUpType) {
+		utils.RespondVioletError(rw, http.StatusBadGateway, fmt.Sprintf("Internal server switched to protocol %s when %s was requested", resUpType, reqUpType))
+		return
+	}
+
+	ic, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		utils.RespondVioletError(rw, http.StatusBadGateway, "Internal server connection is not writable")
+		return
+	}
+
+	// copy headers before hijacking to keep any headers set by the middleware
+	for k, v := range resp.Header {
+		rw.Header()[k] = slices.Clone(v)
+	}
+
+	c, brw, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		Logger.Info("Failed to hijack", "url", req.URL, "err", err)
+		utils.RespondVioletError(rw, http.StatusBadGateway, "Failed to hijack connection")
+		return
+	}
+	defer c.Close()
+
+	// the http server deadlines are still set after hijacking
+	_ = c.SetDeadline(time.Time{})
+
+	// the connection may have been closed while waiting for the response
+	if !s.attach(tc, func() {
+		_ = c.Close()
+		_ = ic.Close()
+	}, nil) {
+		return
+	}
+
+	// write the switching protocols response to the client
+	_, _ = fmt.Fprintf(brw, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	_ = rw.Header().Write(brw)
+	_, _ = brw.WriteString("\r\n")
+	if brw.Flush() != nil {
+		return
+	}
+	handshake(http.StatusSwitchingProtocols)
+
+	d1 := make(chan struct{}, 1)
+	d2 := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// splice the streams each way, the buffered reader may already contain
+	// data sent by the client
+	go rawRelay(d1, ic, brw.Reader, tc.addIn)
+	go rawRelay(d2, c, ic, tc.addOut)
+	go s.idleWatch(stop, tc, func() {
+		_ = c.Close()
+		_ = ic.Close()
+	})
+
+	Logger.Info("Completed websocket tunnelling")
+
+	// waiting until d1 or d2 close then automatically defer close both connections
+	select {
+	case <-d1:
+	case <-d2:
+	}
+}
+
+// filterWebsocketHeaders returns the handshake headers to forward to the
+// underlying websocket connection, this excludes the hop-by-hop headers and
+// the headers which the dialer generates itself
+func filterWebsocketHeaders(req *http.Request) (out http.Header) {
+	out = req.Header.Clone()
+	if out == nil {
+		out = make(http.Header)
+	}
+	utils.RemoveHopByHopHeaders(out)
+	out.Del("Sec-Websocket-Key")
+	out.Del("Sec-Websocket-Version")
+	out.Del("Sec-Websocket-Extensions")
+
+	// the dialer uses the Host header to override the URL host
+	if req.Host != "" && req.Host != req.URL.Host {
+		out.Set("Host", req.Host)
+	}
+	return
+}
+
+// filterWebsocketResponseHeaders returns the handshake response headers from
+// the underlying websocket connection to send back to the client, the upgrader
+// picks the subprotocol from the Sec-WebSocket-Protocol header
+func filterWebsocketResponseHeaders(headers http.Header) (out http.Header) {
+	out = headers.Clone()
+	if out == nil {
+		out = make(http.Header)
+	}
+	utils.RemoveHopByHopHeaders(out)
+	out.Del("Sec-Websocket-Accept")
+	out.Del("Sec-Websocket-Extensions")
 	return
 }
 
-func (s *Server) wsRelay(done chan struct{}, a, b *websocket.Conn) {
+// copyHandshakeResponse writes a rejected handshake response from the
+// underlying websocket connection to the client, the body may be truncated by
+// the dialer so the length is not copied
+func copyHandshakeResponse(rw http.ResponseWriter, resp *http.Response) {
+	h := resp.Header.Clone()
+	utils.RemoveHopByHopHeaders(h)
+	h.Del("Content-Length")
+	for k, v := range h {
+		rw.Header()[k] = v
+	}
+	rw.WriteHeader(resp.StatusCode)
+	if resp.Body != nil {
+		_, _ = io.Copy(rw, resp.Body)
+	}
+}
+
+// rawRelay copies bytes from src to dst until either side fails
+func rawRelay(done chan struct{}, dst io.Writer, src io.Reader, count func(n int)) {
+	defer close(done)
+	_, _ = io.Copy(countWriter{dst, count}, src)
+}
+
+// countWriter passes the number of bytes written to the count function
+type countWriter struct {
+	w     io.Writer
+	count func(n int)
+}
+
+func (c countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.count(n)
+	return n, err
+}
+
+func (s *Server) wsRelay(done chan struct{}, a, b *websocket.Conn, count func(n int), limiter *rate.Limiter) {
 	defer func() {
 		close(done)
 	}()
@@ -106,29 +321,62 @@ func (s *Server) wsRelay(done chan struct{}, a, b *websocket.Conn) {
 			Logger.Info("Read message", "err", err)
 			return
 		}
+		s.extendDeadline(a)
+		if limiter != nil && !limiter.Allow() {
+			Logger.Info("Message rate exceeded", "remote", a.RemoteAddr())
+			_ = a.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "message rate exceeded"), time.Now().Add(writeWait))
+			return
+		}
+		count(len(message))
 		if b.WriteMessage(mt, message) != nil {
 			return
 		}
 	}
 }
 
-func (s *Server) Remove(c *websocket.Conn) {
+// Shutdown stops accepting new connections and sends a going away close frame
+// to both sides of each relayed connection. Tunnelled connections cannot be
+// sent a close frame so they are dropped immediately. Shutdown waits for the
+// relayed connections to finish closing, if the context expires first the
+// remaining connections are dropped and the context error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
 	s.connLock.Lock()
-	delete(s.conns, c.RemoteAddr().String())
+
+	// flag shutdown so no more connections are allowed
+	s.connStop = true
+	goingAway := make([]func(), 0, len(s.conns))
+	for _, c := range s.conns {
+		if c.goingAway != nil {
+			goingAway = append(goingAway, c.goingAway)
+		}
+	}
 	s.connLock.Unlock()
-	_ = c.Close()
-}
 
-func (s *Server) Shutdown() {
-	s.connLock.Lock()
-	defer s.connLock.Unlock()
+	// drop connections which can't be closed cleanly
+	s.CloseFunc(func(info ConnInfo) bool { return info.Tunnel })
+	for _, f := range goingAway {
+		f()
+	}
 
-	// flag shutdown and close all open connections
-	s.connStop = true
-	for _, i := range s.conns {
-		_ = i.Close()
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+	for {
+		if s.activeConns() == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			// close all remaining connections
+			s.CloseFunc(func(ConnInfo) bool { return true })
+			return ctx.Err()
+		case <-t.C:
+		}
 	}
+}
 
-	// clear connections, not required but do it anyway
-	s.conns = make(map[string]*websocket.Conn)
+// activeConns returns the number of tracked connections
+func (s *Server) activeConns() int {
+	s.connLock.RLock()
+	defer s.connLock.RUnlock()
+	return len(s.conns)
 }
@@ -0,0 +1,342 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyTo creates a test server which upgrades all requests using the Server
+// and forwards them to the internal server
+func proxyTo(s *Server, internal *httptest.Server, d Details) *httptest.Server {
+	u, err := url.Parse(internal.URL)
+	if err != nil {
+		panic(err)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.URL.Host = u.Host
+		req.Host = "example.com"
+		s.Upgrade(rw, req, d)
+	}))
+}
+
+func TestServer_Upgrade_Headers(t *testing.T) {
+	internalUpgrader := websocket.Upgrader{Subprotocols: []string{"chat"}}
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "example.com", req.Host)
+		assert.Equal(t, "Bearer abc", req.Header.Get("Authorization"))
+		assert.Equal(t, "session=123", req.Header.Get("Cookie"))
+		assert.Equal(t, "https://example.com", req.Header.Get("Origin"))
+		assert.Equal(t, []string{"other", "chat"}, websocket.Subprotocols(req))
+
+		c, err := internalUpgrader.Upgrade(rw, req, http.Header{"Set-Cookie": {"hello=world"}})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		mt, p, err := c.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, c.WriteMessage(mt, p))
+	}))
+	defer internal.Close()
+
+	s := NewServer()
+	srv := proxyTo(s, internal, Details{Route: "example.com"})
+	defer srv.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"other", "chat"}}
+	c, resp, err := dialer.Dial(strings.Replace(srv.URL, "http://", "ws://", 1), http.Header{
+		"Authorization": {"Bearer abc"},
+		"Cookie":        {"session=123"},
+		"Origin":        {"https://example.com"},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.Equal(t, "chat", c.Subprotocol())
+	assert.Equal(t, "hello=world", resp.Header.Get("Set-Cookie"))
+
+	assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("hello")))
+	mt, p, err := c.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.TextMessage, mt)
+	assert.Equal(t, "hello", string(p))
+}
+
+func TestFilterWebsocketHeaders(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://127.0.0.1/ws", nil)
+	req.Host = "example.com"
+	req.Header = http.Header{
+		"Connection":             {"Upgrade, X-Secret"},
+		"Upgrade":                {"websocket"},
+		"Keep-Alive":             {"timeout=5"},
+		"Te":                     {"trailers"},
+		"Proxy-Authorization":    {"Basic abc"},
+		"X-Secret":               {"hidden"},
+		"Sec-Websocket-Key":      {"abc"},
+		"Sec-Websocket-Version":  {"13"},
+		"Sec-Websocket-Protocol": {"chat"},
+		"Cookie":                 {"session=123"},
+	}
+	assert.Equal(t, http.Header{
+		"Sec-Websocket-Protocol": {"chat"},
+		"Cookie":                 {"session=123"},
+		"Host":                   {"example.com"},
+	}, filterWebsocketHeaders(req))
+
+	assert.Equal(t, http.Header{
+		"Sec-Websocket-Protocol": {"chat"},
+	}, filterWebsocketResponseHeaders(http.Header{
+		"Connection":             {"Upgrade, X-Secret"},
+		"Upgrade":                {"websocket"},
+		"Keep-Alive":             {"timeout=5"},
+		"X-Secret":               {"hidden"},
+		"Sec-Websocket-Accept":   {"abc"},
+		"Sec-Websocket-Protocol": {"chat"},
+	}))
+}
+
+func TestServer_Upgrade_Rejected(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+	}))
+	defer internal.Close()
+
+	s := NewServer()
+	srv := proxyTo(s, internal, Details{Route: "example.com"})
+	defer srv.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http://", "ws://", 1), nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, "Bearer", resp.Header.Get("WWW-Authenticate"))
+}
+
+func TestServer_Conns(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c, err := upgrader.Upgrade(rw, req, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if c.WriteMessage(mt, p) != nil {
+				return
+			}
+		}
+	}))
+	defer internal.Close()
+
+	s := NewServer()
+	srv := proxyTo(s, internal, Details{Route: "example.com/ws", Host: "example.com", Limit: 1})
+	defer srv.Close()
+
+	wsUrl := strings.Replace(srv.URL, "http://", "ws://", 1)
+	c, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+	assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("hello")))
+	_, _, err = c.ReadMessage()
+	assert.NoError(t, err)
+
+	conns := s.Conns()
+	if assert.Len(t, conns, 1) {
+		assert.Equal(t, "example.com/ws", conns[0].Route)
+		assert.Equal(t, "example.com", conns[0].Host)
+		assert.False(t, conns[0].Tunnel)
+		assert.Equal(t, int64(5), conns[0].BytesIn)
+		assert.Equal(t, int64(5), conns[0].BytesOut)
+	}
+
+	// the route limit is reached
+	_, resp, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// force close the connection
+	assert.Equal(t, 0, s.CloseFunc(func(info ConnInfo) bool { return info.Host == "example.org" }))
+	assert.Equal(t, 1, s.CloseFunc(func(info ConnInfo) bool { return info.ID == conns[0].ID }))
+	_, _, err = c.ReadMessage()
+	assert.Error(t, err)
+	assert.Empty(t, s.Conns())
+}
+
+func TestServer_Options(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c, err := upgrader.Upgrade(rw, req, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		for {
+			mt, p, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if c.WriteMessage(mt, p) != nil {
+				return
+			}
+		}
+	}))
+	defer internal.Close()
+
+	dial := func(t *testing.T, opts Options) (*websocket.Conn, *Server) {
+		s := NewServerWithOptions(opts)
+		srv := proxyTo(s, internal, Details{Route: "example.com"})
+		t.Cleanup(srv.Close)
+		c, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http://", "ws://", 1), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = c.Close() })
+		return c, s
+	}
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		c, s := dial(t, Options{IdleTimeout: 100 * time.Millisecond})
+		_, _, err := c.ReadMessage()
+		assert.Error(t, err)
+		assert.Empty(t, s.Conns())
+	})
+
+	t.Run("PingInterval", func(t *testing.T) {
+		c, _ := dial(t, Options{PingInterval: 20 * time.Millisecond})
+		pings := make(chan struct{}, 1)
+		c.SetPingHandler(func(string) error {
+			select {
+			case pings <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+		go func() {
+			_, _, _ = c.ReadMessage()
+		}()
+		select {
+		case <-pings:
+		case <-time.After(time.Second):
+			t.Fatal("no ping received")
+		}
+	})
+
+	t.Run("MaxMessageSize", func(t *testing.T) {
+		c, _ := dial(t, Options{MaxMessageSize: 4})
+		assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("hi")))
+		_, p, err := c.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "hi", string(p))
+
+		assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("hello world")))
+		_, _, err = c.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+	})
+
+	t.Run("MessageRate", func(t *testing.T) {
+		c, _ := dial(t, Options{MessageRate: 1, MessageBurst: 1})
+		assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("a")))
+		_, p, err := c.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, "a", string(p))
+
+		// the burst has been used up so the next message is rejected
+		assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("b")))
+		_, _, err = c.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+	})
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	closeCode := make(chan int, 1)
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c, err := upgrader.Upgrade(rw, req, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		_, _, err = c.ReadMessage()
+		var closeErr *websocket.CloseError
+		if errors.As(err, &closeErr) {
+			closeCode <- closeErr.Code
+		}
+	}))
+	defer internal.Close()
+
+	s := NewServer()
+	srv := proxyTo(s, internal, Details{Route: "example.com"})
+	defer srv.Close()
+
+	wsUrl := strings.Replace(srv.URL, "http://", "ws://", 1)
+	c, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	// reply to the close frame like a browser would
+	go func() {
+		_, _, _ = c.ReadMessage()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+	assert.Empty(t, s.Conns())
+	select {
+	case code := <-closeCode:
+		assert.Equal(t, websocket.CloseGoingAway, code)
+	case <-time.After(time.Second):
+		t.Fatal("internal server did not receive a close frame")
+	}
+
+	// new connections are rejected
+	_, resp, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	assert.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestServer_Shutdown_Timeout(t *testing.T) {
+	block := make(chan struct{})
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c, err := upgrader.Upgrade(rw, req, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		<-block
+	}))
+	defer internal.Close()
+	defer close(block)
+
+	s := NewServer()
+	srv := proxyTo(s, internal, Details{Route: "example.com"})
+	defer srv.Close()
+
+	// neither side reads so the close frame is not answered
+	c, _, err := websocket.DefaultDialer.Dial(strings.Replace(srv.URL, "http://", "ws://", 1), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	assert.Empty(t, s.Conns())
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"github.com/1f349/violet/target"
+	"github.com/1f349/violet/utils"
+	"github.com/mrmelon54/trie"
+	"net/http"
+	"strings"
+)
+
+// Candidate is a redirect or route found in the trie while explaining a lookup
+type Candidate struct {
+	Type   string       `json:"type"` // redirect or route
+	Host   string       `json:"host"`
+	Path   string       `json:"path"`
+	Dst    string       `json:"dst"`
+	Flags  target.Flags `json:"flags"`
+	Reason string       `json:"reason,omitempty"` // why the candidate was skipped
+}
+
+// Explanation describes how the router would handle a request
+type Explanation struct {
+	Host       string      `json:"host"`
+	Path       string      `json:"path"`
+	Candidates []Candidate `json:"candidates"`
+	Match      *Candidate  `json:"match"`
+
+	// Upstream is the proxy url for a route or the location for a redirect
+	Upstream string `json:"upstream,omitempty"`
+
+	// Code is the status code used by a redirect
+	Code int `json:"code,omitempty"`
+}
+
+// Explain looks up the host, path and query in the same order as ServeHTTP
+// without serving the request. Redirects are checked before routes and the
+// wildcard host is checked last.
+func (r *Router) Explain(host, path, rawQuery string) Explanation {
+	if path == "" {
+		path = "/"
+	}
+	host, _, _ = utils.SplitDomainPort(host, 0)
+	e := Explanation{Host: host, Path: path, Candidates: []Candidate{}}
+
+	hosts := []string{host}
+	if parentHostDot := strings.IndexByte(host, '.'); parentHostDot != -1 {
+		hosts = append(hosts, "*"+host[parentHostDot:])
+	}
+
+	for _, h := range hosts {
+		if explainTrie(&e, r.redirect[h], "redirect", h, path, func(t target.Redirect, p string) {
+			e.Upstream = t.RedirectUrl("", p).String()
+			e.Code = http.StatusFound
+			if t.Code != 0 {
+				e.Code = int(t.Code)
+			}
+		}) {
+			return e
+		}
+		if explainTrie(&e, r.route[h], "route", h, path, func(t target.Route, p string) {
+			e.Upstream = t.UpstreamUrl(p, rawQuery).String()
+		}) {
+			return e
+		}
+	}
+	return e
+}
+
+// explainTrie adds the candidates from the trie in the order used by
+// getServeData and calls matched with the remaining path of the first match
+func explainTrie[T interface{ target.Route | target.Redirect }](e *Explanation, h *trie.Trie[T], t, host, path string, matched func(v T, p string)) bool {
+	if h == nil {
+		return false
+	}
+	pairs := h.GetAllKeyValues([]byte(path))
+	for i := len(pairs) - 1; i >= 0; i-- {
+		c := Candidate{Type: t, Host: host, Path: pairs[i].Key}
+		var v any = pairs[i].Value
+		switch v := v.(type) {
+		case target.Route:
+			c.Dst, c.Flags = v.Dst, v.Flags
+		case target.Redirect:
+			c.Dst, c.Flags = v.Dst, v.Flags
+		}
+
+		if !c.Flags.HasFlag(target.FlagPre) && pairs[i].Key != path {
+			c.Reason = "path is not an exact match and the prefix flag is not set"
+			e.Candidates = append(e.Candidates, c)
+			continue
+		}
+		e.Candidates = append(e.Candidates, c)
+		e.Match = &e.Candidates[len(e.Candidates)-1]
+		matched(pairs[i].Value, strings.TrimPrefix(path, pairs[i].Key))
+		return true
+	}
+	return false
+}
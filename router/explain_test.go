@@ -0,0 +1,48 @@
+package router
+
+import (
+	"github.com/1f349/violet/target"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRouter_Explain(t *testing.T) {
+	r := New(nil)
+	r.AddRoute(target.Route{Src: "example.com", Dst: "127.0.0.1:8080", Flags: target.FlagPre})
+	r.AddRoute(target.Route{Src: "example.com/api", Dst: "127.0.0.1:8081/v1"})
+	r.AddRoute(target.Route{Src: "*.example.com", Dst: "127.0.0.1:8082", Flags: target.FlagPre | target.FlagSecureMode})
+	r.AddRedirect(target.Redirect{Src: "www.example.com/old", Dst: "example.com/new", Flags: target.FlagPre, Code: http.StatusPermanentRedirect})
+
+	// the exact route is skipped without the prefix flag
+	e := r.Explain("example.com:443", "/api/users", "a=1")
+	assert.Equal(t, Explanation{
+		Host: "example.com",
+		Path: "/api/users",
+		Candidates: []Candidate{
+			{Type: "route", Host: "example.com", Path: "/api", Dst: "127.0.0.1:8081/v1", Reason: "path is not an exact match and the prefix flag is not set"},
+			{Type: "route", Host: "example.com", Path: "/", Dst: "127.0.0.1:8080", Flags: target.FlagPre},
+		},
+		Match:    &Candidate{Type: "route", Host: "example.com", Path: "/", Dst: "127.0.0.1:8080", Flags: target.FlagPre},
+		Upstream: "http://127.0.0.1:8080/api/users?a=1",
+	}, e)
+
+	e = r.Explain("example.com", "/api", "")
+	assert.Equal(t, "/api", e.Match.Path)
+	assert.Equal(t, "http://127.0.0.1:8081/v1", e.Upstream)
+
+	// redirects take precedence over the wildcard route
+	e = r.Explain("www.example.com", "/old/page", "")
+	assert.Equal(t, "redirect", e.Match.Type)
+	assert.Equal(t, "//example.com/new/page", e.Upstream)
+	assert.Equal(t, http.StatusPermanentRedirect, e.Code)
+
+	e = r.Explain("www.example.com", "/other", "")
+	assert.Equal(t, Candidate{Type: "route", Host: "*.example.com", Path: "/", Dst: "127.0.0.1:8082", Flags: target.FlagPre | target.FlagSecureMode}, *e.Match)
+	assert.Equal(t, "https://127.0.0.1:8082/other", e.Upstream)
+
+	e = r.Explain("example.org", "", "")
+	assert.Equal(t, "/", e.Path)
+	assert.Nil(t, e.Match)
+	assert.Empty(t, e.Candidates)
+}
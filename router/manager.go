@@ -2,20 +2,37 @@ package router
 
 import (
 	"context"
+	"database/sql"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"github.com/1f349/violet/database"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
 	"github.com/1f349/violet/proxy"
 	"github.com/1f349/violet/target"
+	"github.com/1f349/violet/utils"
 	"github.com/mrmelon54/rescheduler"
 	"net/http"
-	"slices"
-	"strings"
 	"sync"
+	"time"
 )
 
 var Logger = logger.Logger.WithPrefix("Violet Manager")
 
+var (
+	// ErrTargetNotFound is returned when no route or redirect exists for the source
+	ErrTargetNotFound = errors.New("target not found")
+
+	// ErrVersionConflict is returned when the route or redirect has been changed
+	// since the expected version was read
+	ErrVersionConflict = errors.New("target version conflict")
+
+	// ErrEmptySelector is returned when a bulk change has no labels to select
+	// the routes or redirects with
+	ErrEmptySelector = errors.New("empty label selector")
+)
+
 // Manager is a database and mutex wrap around router allowing it to be
 // dynamically regenerated after updating the database of routes.
 type Manager struct {
@@ -24,6 +41,7 @@ type Manager struct {
 	r  *Router
 	p  *proxy.HybridTransport
 	z  *rescheduler.Rescheduler
+	*utils.CompileTracker
 }
 
 // NewManager create a new manager, initialises the routes and redirects tables
@@ -34,6 +52,8 @@ func NewManager(db *database.Queries, proxy *proxy.HybridTransport) *Manager {
 		s:  &sync.RWMutex{},
 		r:  New(proxy),
 		p:  proxy,
+
+		CompileTracker: utils.NewCompileTracker("router"),
 	}
 	m.z = rescheduler.NewRescheduler(m.threadCompile)
 	return m
@@ -46,18 +66,30 @@ func (m *Manager) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	r.ServeHTTP(rw, req)
 }
 
+// Explain describes how the live compiled router would handle a request
+func (m *Manager) Explain(host, path, rawQuery string) Explanation {
+	m.s.RLock()
+	r := m.r
+	m.s.RUnlock()
+	return r.Explain(host, path, rawQuery)
+}
+
 func (m *Manager) Compile() {
 	m.z.Run()
 }
 
 func (m *Manager) threadCompile() {
+	start := time.Now()
+
 	// new router
 	router := New(m.p)
 
 	// compile router and check errors
 	err := m.internalCompile(router)
+	metrics.ObserveCompile("router", start, err)
 	if err != nil {
 		Logger.Info("Compile failed", "err", err)
+		m.FinishCompile(start, 0, err)
 		return
 	}
 
@@ -65,6 +97,7 @@ func (m *Manager) threadCompile() {
 	m.s.Lock()
 	m.r = router
 	m.s.Unlock()
+	m.FinishCompile(start, router.size, nil)
 }
 
 // internalCompile is a hidden internal method for querying the database during
@@ -80,9 +113,10 @@ func (m *Manager) internalCompile(router *Router) error {
 
 	for _, row := range routeRows {
 		router.AddRoute(target.Route{
-			Src:   row.Source,
-			Dst:   row.Destination,
-			Flags: row.Flags.NormaliseRouteFlags(),
+			Src:            row.Source,
+			Dst:            row.Destination,
+			Flags:          row.Flags.NormaliseRouteFlags(),
+			WebsocketLimit: row.WebsocketLimit,
 		})
 	}
 
@@ -105,81 +139,287 @@ func (m *Manager) internalCompile(router *Router) error {
 	return nil
 }
 
-func (m *Manager) GetAllRoutes(hosts []string) ([]target.RouteWithActive, error) {
-	if len(hosts) < 1 {
-		return []target.RouteWithActive{}, nil
+// ListOptions selects the routes or redirects returned by a listing
+type ListOptions struct {
+	Hosts  []string      // domains the sources must be on, nothing is listed if empty
+	Search string        // case-insensitive substring of the source, destination or description
+	Active *bool         // only list enabled or disabled items if not nil
+	Flags  target.Flags  // flags which must all be set
+	Labels target.Labels // labels which must all be set
+	After  int64         // cursor returned by the previous page
+	Limit  int           // maximum number of items, zero lists every item
+}
+
+// params converts the options into the shared listing query parameters
+func (o ListOptions) params() (hosts string, active sql.NullBool, limit int64, err error) {
+	raw, err := json.Marshal(o.Hosts)
+	if err != nil {
+		return "", sql.NullBool{}, 0, err
+	}
+	if o.Active != nil {
+		active = sql.NullBool{Bool: *o.Active, Valid: true}
 	}
 
-	s := make([]target.RouteWithActive, 0)
+	// fetch an extra row to find out if there is another page
+	limit = -1
+	if o.Limit > 0 {
+		limit = int64(o.Limit) + 1
+	}
+	return string(raw), active, limit, nil
+}
+
+// GetAllRoutes returns every route on the hosts
+func (m *Manager) GetAllRoutes(hosts []string) ([]target.RouteWithActive, error) {
+	s, _, err := m.ListRoutes(ListOptions{Hosts: hosts})
+	return s, err
+}
+
+// ListRoutes returns the routes matching the options ordered by creation. The
+// cursor for the next page is returned or zero if this is the last page.
+func (m *Manager) ListRoutes(opts ListOptions) ([]target.RouteWithActive, int64, error) {
+	return listRoutes(context.Background(), m.db, opts)
+}
+
+// listRoutes lists the routes using db so it can be part of a transaction
+func listRoutes(ctx context.Context, db *database.Queries, opts ListOptions) ([]target.RouteWithActive, int64, error) {
+	if len(opts.Hosts) < 1 {
+		return []target.RouteWithActive{}, 0, nil
+	}
+	hosts, active, limit, err := opts.params()
+	if err != nil {
+		return nil, 0, err
+	}
 
-	rows, err := m.db.GetAllRoutes(context.Background())
+	rows, err := db.ListRoutes(ctx, database.ListRoutesParams{
+		Hosts:   hosts,
+		Search:  opts.Search,
+		Active:  active,
+		Flags:   opts.Flags,
+		Labels:  opts.Labels,
+		AfterID: opts.After,
+		Limit:   limit,
+	})
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	var next int64
+	if opts.Limit > 0 && len(rows) > opts.Limit {
+		rows = rows[:opts.Limit]
+		next = rows[len(rows)-1].ID
 	}
 
+	s := make([]target.RouteWithActive, 0, len(rows))
 	for _, row := range rows {
-		a := target.RouteWithActive{
+		s = append(s, target.RouteWithActive{
 			Route: target.Route{
-				Src:   row.Source,
-				Dst:   row.Destination,
-				Desc:  row.Description,
-				Flags: row.Flags,
+				Src:            row.Source,
+				Dst:            row.Destination,
+				Desc:           row.Description,
+				Flags:          row.Flags,
+				WebsocketLimit: row.WebsocketLimit,
+				Labels:         row.Labels,
 			},
 			Active: row.Active,
-		}
-
-		if slices.ContainsFunc(hosts, a.OnDomain) {
-			s = append(s, a)
-		}
+		})
 	}
-
-	return s, nil
+	return s, next, nil
 }
 
 func (m *Manager) InsertRoute(route target.RouteWithActive) error {
 	return m.db.AddRoute(context.Background(), database.AddRouteParams{
-		Source:      route.Src,
-		Destination: route.Dst,
-		Description: route.Desc,
-		Flags:       route.Flags,
-		Active:      route.Active,
+		Source:         route.Src,
+		Destination:    route.Dst,
+		Description:    route.Desc,
+		Flags:          route.Flags,
+		Active:         route.Active,
+		WebsocketLimit: route.WebsocketLimit,
+		Labels:         route.Labels,
 	})
 }
 
-func (m *Manager) DeleteRoute(source string) error {
-	return m.db.RemoveRoute(context.Background(), source)
+// GetRoute returns the route with the source and the version of the row or
+// ErrTargetNotFound.
+func (m *Manager) GetRoute(source string) (target.RouteWithActive, int64, error) {
+	row, err := m.db.GetRoute(context.Background(), source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return target.RouteWithActive{}, 0, ErrTargetNotFound
+	}
+	if err != nil {
+		return target.RouteWithActive{}, 0, err
+	}
+	return target.RouteWithActive{
+		Route: target.Route{
+			Src:            row.Source,
+			Dst:            row.Destination,
+			Desc:           row.Description,
+			Flags:          row.Flags,
+			WebsocketLimit: row.WebsocketLimit,
+			Labels:         row.Labels,
+		},
+		Active: row.Active,
+	}, row.Version, nil
 }
 
-func (m *Manager) GetAllRedirects(hosts []string) ([]target.RedirectWithActive, error) {
-	if len(hosts) < 1 {
-		return []target.RedirectWithActive{}, nil
+// UpdateRoute replaces an existing route. A non-zero version must match the
+// stored version otherwise ErrVersionConflict is returned.
+func (m *Manager) UpdateRoute(route target.RouteWithActive, version int64) error {
+	n, err := m.db.UpdateRoute(context.Background(), database.UpdateRouteParams{
+		Destination:    route.Dst,
+		Description:    route.Desc,
+		Flags:          route.Flags,
+		Active:         route.Active,
+		WebsocketLimit: route.WebsocketLimit,
+		Labels:         route.Labels,
+		Source:         route.Src,
+		Version:        version,
+	})
+	return m.routeChanged(route.Src, n, err)
+}
+
+// SetRouteActive enables or disables an existing route. A non-zero version
+// must match the stored version otherwise ErrVersionConflict is returned.
+func (m *Manager) SetRouteActive(source string, active bool, version int64) error {
+	n, err := m.db.SetRouteActive(context.Background(), database.SetRouteActiveParams{
+		Active:  active,
+		Source:  source,
+		Version: version,
+	})
+	return m.routeChanged(source, n, err)
+}
+
+// DeleteRoute removes an existing route. A non-zero version must match the
+// stored version otherwise ErrVersionConflict is returned.
+func (m *Manager) DeleteRoute(source string, version int64) error {
+	n, err := m.db.RemoveRoute(context.Background(), database.RemoveRouteParams{
+		Source:  source,
+		Version: version,
+	})
+	return m.routeChanged(source, n, err)
+}
+
+// SetRoutesActiveByLabels enables or disables every route on the hosts with
+// all the labels in a single transaction. The changed routes are returned as
+// they were before the change.
+func (m *Manager) SetRoutesActiveByLabels(hosts []string, labels target.Labels, active bool) ([]target.RouteWithActive, error) {
+	if len(labels) < 1 {
+		return nil, ErrEmptySelector
 	}
+	ctx := context.Background()
+	var routes []target.RouteWithActive
+	err := m.db.Transaction(ctx, func(tx *database.Queries) error {
+		current := !active
+		var err error
+		routes, _, err = listRoutes(ctx, tx, ListOptions{Hosts: hosts, Labels: labels, Active: &current})
+		if err != nil {
+			return err
+		}
+		for _, i := range routes {
+			_, err := tx.SetRouteActive(ctx, database.SetRouteActiveParams{Active: active, Source: i.Src})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return routes, err
+}
 
-	s := make([]target.RedirectWithActive, 0)
+// DeleteRoutesByLabels removes every route on the hosts with all the labels in
+// a single transaction and returns the removed routes
+func (m *Manager) DeleteRoutesByLabels(hosts []string, labels target.Labels) ([]target.RouteWithActive, error) {
+	if len(labels) < 1 {
+		return nil, ErrEmptySelector
+	}
+	ctx := context.Background()
+	var routes []target.RouteWithActive
+	err := m.db.Transaction(ctx, func(tx *database.Queries) error {
+		var err error
+		routes, _, err = listRoutes(ctx, tx, ListOptions{Hosts: hosts, Labels: labels})
+		if err != nil {
+			return err
+		}
+		for _, i := range routes {
+			_, err := tx.RemoveRoute(ctx, database.RemoveRouteParams{Source: i.Src})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return routes, err
+}
+
+// routeChanged checks if a route was changed and returns ErrTargetNotFound or
+// ErrVersionConflict if it wasn't
+func (m *Manager) routeChanged(source string, n int64, err error) error {
+	if err != nil || n != 0 {
+		return err
+	}
+	if _, _, err := m.GetRoute(source); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// GetAllRedirects returns every redirect on the hosts
+func (m *Manager) GetAllRedirects(hosts []string) ([]target.RedirectWithActive, error) {
+	s, _, err := m.ListRedirects(ListOptions{Hosts: hosts})
+	return s, err
+}
+
+// ListRedirects returns the redirects matching the options ordered by
+// creation. The cursor for the next page is returned or zero if this is the
+// last page.
+func (m *Manager) ListRedirects(opts ListOptions) ([]target.RedirectWithActive, int64, error) {
+	return listRedirects(context.Background(), m.db, opts)
+}
 
-	rows, err := m.db.GetAllRedirects(context.Background())
+// listRedirects lists the redirects using db so it can be part of a
+// transaction
+func listRedirects(ctx context.Context, db *database.Queries, opts ListOptions) ([]target.RedirectWithActive, int64, error) {
+	if len(opts.Hosts) < 1 {
+		return []target.RedirectWithActive{}, 0, nil
+	}
+	hosts, active, limit, err := opts.params()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	rows, err := db.ListRedirects(ctx, database.ListRedirectsParams{
+		Hosts:   hosts,
+		Search:  opts.Search,
+		Active:  active,
+		Flags:   opts.Flags,
+		Labels:  opts.Labels,
+		AfterID: opts.After,
+		Limit:   limit,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next int64
+	if opts.Limit > 0 && len(rows) > opts.Limit {
+		rows = rows[:opts.Limit]
+		next = rows[len(rows)-1].ID
 	}
 
+	s := make([]target.RedirectWithActive, 0, len(rows))
 	for _, row := range rows {
-		a := target.RedirectWithActive{
+		s = append(s, target.RedirectWithActive{
 			Redirect: target.Redirect{
-				Src:   row.Source,
-				Dst:   row.Destination,
-				Desc:  row.Description,
-				Flags: row.Flags,
-				Code:  row.Code,
+				Src:    row.Source,
+				Dst:    row.Destination,
+				Desc:   row.Description,
+				Flags:  row.Flags,
+				Code:   row.Code,
+				Labels: row.Labels,
 			},
 			Active: row.Active,
-		}
-
-		if slices.ContainsFunc(hosts, a.OnDomain) {
-			s = append(s, a)
-		}
+		})
 	}
-
-	return s, nil
+	return s, next, nil
 }
 
 func (m *Manager) InsertRedirect(redirect target.RedirectWithActive) error {
@@ -190,32 +430,131 @@ func (m *Manager) InsertRedirect(redirect target.RedirectWithActive) error {
 		Flags:       redirect.Flags,
 		Code:        redirect.Code,
 		Active:      redirect.Active,
+		Labels:      redirect.Labels,
 	})
 }
 
-func (m *Manager) DeleteRedirect(source string) error {
-	return m.db.RemoveRedirect(context.Background(), source)
+// GetRedirect returns the redirect with the source and the version of the row
+// or ErrTargetNotFound.
+func (m *Manager) GetRedirect(source string) (target.RedirectWithActive, int64, error) {
+	row, err := m.db.GetRedirect(context.Background(), source)
+	if errors.Is(err, sql.ErrNoRows) {
+		return target.RedirectWithActive{}, 0, ErrTargetNotFound
+	}
+	if err != nil {
+		return target.RedirectWithActive{}, 0, err
+	}
+	return target.RedirectWithActive{
+		Redirect: target.Redirect{
+			Src:    row.Source,
+			Dst:    row.Destination,
+			Desc:   row.Description,
+			Flags:  row.Flags,
+			Code:   row.Code,
+			Labels: row.Labels,
+		},
+		Active: row.Active,
+	}, row.Version, nil
+}
+
+// UpdateRedirect replaces an existing redirect. A non-zero version must match
+// the stored version otherwise ErrVersionConflict is returned.
+func (m *Manager) UpdateRedirect(redirect target.RedirectWithActive, version int64) error {
+	n, err := m.db.UpdateRedirect(context.Background(), database.UpdateRedirectParams{
+		Destination: redirect.Dst,
+		Description: redirect.Desc,
+		Flags:       redirect.Flags,
+		Code:        redirect.Code,
+		Active:      redirect.Active,
+		Labels:      redirect.Labels,
+		Source:      redirect.Src,
+		Version:     version,
+	})
+	return m.redirectChanged(redirect.Src, n, err)
 }
 
-// GenerateHostSearch this should help improve performance
-// TODO(Melon) discover how to implement this correctly
-func GenerateHostSearch(hosts []string) (string, []string) {
-	var searchString strings.Builder
-	searchString.WriteString("WHERE ")
+// SetRedirectActive enables or disables an existing redirect. A non-zero
+// version must match the stored version otherwise ErrVersionConflict is
+// returned.
+func (m *Manager) SetRedirectActive(source string, active bool, version int64) error {
+	n, err := m.db.SetRedirectActive(context.Background(), database.SetRedirectActiveParams{
+		Active:  active,
+		Source:  source,
+		Version: version,
+	})
+	return m.redirectChanged(source, n, err)
+}
 
-	hostArgs := make([]string, len(hosts)*2)
-	for i := range hosts {
-		if i != 0 {
-			searchString.WriteString(" OR ")
+// DeleteRedirect removes an existing redirect. A non-zero version must match
+// the stored version otherwise ErrVersionConflict is returned.
+func (m *Manager) DeleteRedirect(source string, version int64) error {
+	n, err := m.db.RemoveRedirect(context.Background(), database.RemoveRedirectParams{
+		Source:  source,
+		Version: version,
+	})
+	return m.redirectChanged(source, n, err)
+}
+
+// SetRedirectsActiveByLabels enables or disables every redirect on the hosts
+// with all the labels in a single transaction. The changed redirects are
+// returned as they were before the change.
+func (m *Manager) SetRedirectsActiveByLabels(hosts []string, labels target.Labels, active bool) ([]target.RedirectWithActive, error) {
+	if len(labels) < 1 {
+		return nil, ErrEmptySelector
+	}
+	ctx := context.Background()
+	var redirects []target.RedirectWithActive
+	err := m.db.Transaction(ctx, func(tx *database.Queries) error {
+		current := !active
+		var err error
+		redirects, _, err = listRedirects(ctx, tx, ListOptions{Hosts: hosts, Labels: labels, Active: &current})
+		if err != nil {
+			return err
 		}
-		// these like checks are not perfect but do reduce load on the database
-		searchString.WriteString("source LIKE '%' + ? + '/%'")
-		searchString.WriteString(" OR source LIKE '%' + ?")
+		for _, i := range redirects {
+			_, err := tx.SetRedirectActive(ctx, database.SetRedirectActiveParams{Active: active, Source: i.Src})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return redirects, err
+}
 
-		// loads the hostname into even and odd args
-		hostArgs[i*2] = hosts[i]
-		hostArgs[i*2+1] = hosts[i]
+// DeleteRedirectsByLabels removes every redirect on the hosts with all the
+// labels in a single transaction and returns the removed redirects
+func (m *Manager) DeleteRedirectsByLabels(hosts []string, labels target.Labels) ([]target.RedirectWithActive, error) {
+	if len(labels) < 1 {
+		return nil, ErrEmptySelector
 	}
+	ctx := context.Background()
+	var redirects []target.RedirectWithActive
+	err := m.db.Transaction(ctx, func(tx *database.Queries) error {
+		var err error
+		redirects, _, err = listRedirects(ctx, tx, ListOptions{Hosts: hosts, Labels: labels})
+		if err != nil {
+			return err
+		}
+		for _, i := range redirects {
+			_, err := tx.RemoveRedirect(ctx, database.RemoveRedirectParams{Source: i.Src})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return redirects, err
+}
 
-	return searchString.String(), hostArgs
+// redirectChanged checks if a redirect was changed and returns
+// ErrTargetNotFound or ErrVersionConflict if it wasn't
+func (m *Manager) redirectChanged(source string, n int64, err error) error {
+	if err != nil || n != 0 {
+		return err
+	}
+	if _, _, err := m.GetRedirect(source); err != nil {
+		return err
+	}
+	return ErrVersionConflict
 }
@@ -121,8 +121,208 @@ func TestManager_GetAllRedirects(t *testing.T) {
 	}, redirects)
 }
 
-func TestGenerateHostSearch(t *testing.T) {
-	query, args := GenerateHostSearch([]string{"example.com", "example.org"})
-	assert.Equal(t, "WHERE source LIKE '%' + ? + '/%' OR source LIKE '%' + ? OR source LIKE '%' + ? + '/%' OR source LIKE '%' + ?", query)
-	assert.Equal(t, []string{"example.com", "example.com", "example.org", "example.org"}, args)
+func TestManager_UpdateRoute(t *testing.T) {
+	db, err := violet.InitDB("file:TestManager_UpdateRoute?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, nil)
+
+	route := target.RouteWithActive{Route: target.Route{Src: "example.com", Dst: "127.0.0.1:8080"}, Active: true}
+	assert.ErrorIs(t, m.UpdateRoute(route, 0), ErrTargetNotFound)
+	assert.ErrorIs(t, m.SetRouteActive("example.com", false, 0), ErrTargetNotFound)
+	assert.ErrorIs(t, m.DeleteRoute("example.com", 0), ErrTargetNotFound)
+	_, _, err = m.GetRoute("example.com")
+	assert.ErrorIs(t, err, ErrTargetNotFound)
+
+	assert.NoError(t, m.InsertRoute(route))
+	route.Desc = "Hello"
+	assert.NoError(t, m.UpdateRoute(route, 1))
+	assert.NoError(t, m.SetRouteActive("example.com", false, 0))
+	route.Active = false
+	stored, version, err := m.GetRoute("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, route, stored)
+	assert.Equal(t, int64(3), version)
+
+	// replacing the route also changes the version
+	assert.NoError(t, m.InsertRoute(route))
+	_, version, err = m.GetRoute("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), version)
+
+	// outdated versions are rejected
+	assert.ErrorIs(t, m.UpdateRoute(route, 3), ErrVersionConflict)
+	assert.ErrorIs(t, m.SetRouteActive("example.com", true, 3), ErrVersionConflict)
+	assert.ErrorIs(t, m.DeleteRoute("example.com", 3), ErrVersionConflict)
+	assert.NoError(t, m.DeleteRoute("example.com", 4))
+}
+
+func TestManager_UpdateRedirect(t *testing.T) {
+	db, err := violet.InitDB("file:TestManager_UpdateRedirect?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, nil)
+
+	redirect := target.RedirectWithActive{Redirect: target.Redirect{Src: "example.com", Dst: "example.org", Code: 308}, Active: true}
+	assert.ErrorIs(t, m.UpdateRedirect(redirect, 0), ErrTargetNotFound)
+	assert.ErrorIs(t, m.SetRedirectActive("example.com", false, 0), ErrTargetNotFound)
+	assert.ErrorIs(t, m.DeleteRedirect("example.com", 0), ErrTargetNotFound)
+	_, _, err = m.GetRedirect("example.com")
+	assert.ErrorIs(t, err, ErrTargetNotFound)
+
+	assert.NoError(t, m.InsertRedirect(redirect))
+	redirect.Desc = "Hello"
+	assert.NoError(t, m.UpdateRedirect(redirect, 1))
+	assert.NoError(t, m.SetRedirectActive("example.com", false, 0))
+	redirect.Active = false
+	stored, version, err := m.GetRedirect("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, redirect, stored)
+	assert.Equal(t, int64(3), version)
+
+	// outdated versions are rejected
+	assert.ErrorIs(t, m.UpdateRedirect(redirect, 1), ErrVersionConflict)
+	assert.ErrorIs(t, m.SetRedirectActive("example.com", true, 1), ErrVersionConflict)
+	assert.ErrorIs(t, m.DeleteRedirect("example.com", 1), ErrVersionConflict)
+	assert.NoError(t, m.DeleteRedirect("example.com", 3))
+}
+
+func TestManager_ListRoutes(t *testing.T) {
+	db, err := violet.InitDB("file:TestManager_ListRoutes?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, nil)
+	a := []target.RouteWithActive{
+		{Route: target.Route{Src: "example.com", Dst: "127.0.0.1:8080", Desc: "Main site", Flags: target.FlagPre}, Active: true},
+		{Route: target.Route{Src: "api.example.com", Dst: "127.0.0.1:9000", Flags: target.FlagPre | target.FlagCors}, Active: true},
+		{Route: target.Route{Src: "example.com/old", Dst: "127.0.0.1:8081", Desc: "Legacy API"}, Active: false},
+		{Route: target.Route{Src: "notexample.com", Dst: "127.0.0.1:8082"}, Active: true},
+		{Route: target.Route{Src: "example.org/x.example.com", Dst: "127.0.0.1:8083"}, Active: true},
+	}
+	for _, i := range a {
+		assert.NoError(t, m.InsertRoute(i))
+	}
+	list := func(opts ListOptions) ([]string, int64) {
+		opts.Hosts = []string{"example.com"}
+		routes, next, err := m.ListRoutes(opts)
+		assert.NoError(t, err)
+		src := make([]string, 0, len(routes))
+		for _, i := range routes {
+			src = append(src, i.Src)
+		}
+		return src, next
+	}
+
+	// only sources on the hosts are listed
+	src, next := list(ListOptions{})
+	assert.Equal(t, []string{"example.com", "api.example.com", "example.com/old"}, src)
+	assert.Equal(t, int64(0), next)
+
+	// search is case-insensitive and includes the destination and description
+	src, _ = list(ListOptions{Search: "API"})
+	assert.Equal(t, []string{"api.example.com", "example.com/old"}, src)
+	src, _ = list(ListOptions{Search: ":8080"})
+	assert.Equal(t, []string{"example.com"}, src)
+
+	inactive := false
+	src, _ = list(ListOptions{Active: &inactive})
+	assert.Equal(t, []string{"example.com/old"}, src)
+	src, _ = list(ListOptions{Flags: target.FlagPre | target.FlagCors})
+	assert.Equal(t, []string{"api.example.com"}, src)
+
+	// follow the cursor to the last page
+	src, next = list(ListOptions{Limit: 2})
+	assert.Equal(t, []string{"example.com", "api.example.com"}, src)
+	assert.NotZero(t, next)
+	src, next = list(ListOptions{Limit: 2, After: next})
+	assert.Equal(t, []string{"example.com/old"}, src)
+	assert.Equal(t, int64(0), next)
+}
+
+func TestManager_ListRedirects(t *testing.T) {
+	db, err := violet.InitDB("file:TestManager_ListRedirects?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, nil)
+	a := []target.RedirectWithActive{
+		{Redirect: target.Redirect{Src: "example.com", Dst: "example.org", Code: 308}, Active: true},
+		{Redirect: target.Redirect{Src: "www.example.com", Dst: "example.com", Code: 308}, Active: false},
+		{Redirect: target.Redirect{Src: "example.com/docs", Dst: "docs.example.org", Desc: "Docs", Flags: target.FlagAbs}, Active: true},
+	}
+	for _, i := range a {
+		assert.NoError(t, m.InsertRedirect(i))
+	}
+
+	active := true
+	redirects, next, err := m.ListRedirects(ListOptions{Hosts: []string{"example.com"}, Active: &active, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []target.RedirectWithActive{a[0]}, redirects)
+	redirects, next, err = m.ListRedirects(ListOptions{Hosts: []string{"example.com"}, Active: &active, Limit: 1, After: next})
+	assert.NoError(t, err)
+	assert.Equal(t, []target.RedirectWithActive{a[2]}, redirects)
+	assert.Equal(t, int64(0), next)
+
+	redirects, _, err = m.ListRedirects(ListOptions{Hosts: []string{"www.example.com"}, Search: "example"})
+	assert.NoError(t, err)
+	assert.Equal(t, []target.RedirectWithActive{a[1]}, redirects)
+}
+
+func TestManager_Labels(t *testing.T) {
+	db, err := violet.InitDB("file:TestManager_Labels?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	m := NewManager(db, nil)
+	a := []target.RouteWithActive{
+		{Route: target.Route{Src: "example.com", Dst: "127.0.0.1:8080", Labels: target.Labels{"team": "payments", "env": "staging"}}, Active: true},
+		{Route: target.Route{Src: "api.example.com", Dst: "127.0.0.1:9000", Labels: target.Labels{"team": "payments"}}, Active: true},
+		{Route: target.Route{Src: "example.com/old", Dst: "127.0.0.1:8081"}, Active: true},
+		{Route: target.Route{Src: "example.org", Dst: "127.0.0.1:8082", Labels: target.Labels{"team": "payments"}}, Active: true},
+	}
+	for _, i := range a {
+		assert.NoError(t, m.InsertRoute(i))
+	}
+	hosts := []string{"example.com"}
+
+	route, _, err := m.GetRoute("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, a[0], route)
+
+	routes, _, err := m.ListRoutes(ListOptions{Hosts: hosts, Labels: target.Labels{"team": "payments"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []target.RouteWithActive{a[0], a[1]}, routes)
+	routes, _, err = m.ListRoutes(ListOptions{Hosts: hosts, Labels: target.Labels{"team": "payments", "env": "staging"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []target.RouteWithActive{a[0]}, routes)
+
+	// bulk changes only apply to the hosts and skip unchanged routes
+	changed, err := m.SetRoutesActiveByLabels(hosts, target.Labels{"team": "payments"}, false)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"example.com", "api.example.com"}, routeSources(changed))
+	for _, i := range changed {
+		assert.True(t, i.Active)
+	}
+	changed, err = m.SetRoutesActiveByLabels(hosts, target.Labels{"team": "payments"}, false)
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+	route, _, err = m.GetRoute("example.org")
+	assert.NoError(t, err)
+	assert.True(t, route.Active)
+
+	_, err = m.DeleteRoutesByLabels(hosts, nil)
+	assert.ErrorIs(t, err, ErrEmptySelector)
+	changed, err = m.DeleteRoutesByLabels(hosts, target.Labels{"env": "staging"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, routeSources(changed))
+	assert.False(t, changed[0].Active)
+	_, _, err = m.GetRoute("example.com")
+	assert.ErrorIs(t, err, ErrTargetNotFound)
+
+	assert.NoError(t, m.InsertRedirect(target.RedirectWithActive{Redirect: target.Redirect{Src: "www.example.com", Dst: "example.com", Labels: target.Labels{"env": "staging"}}, Active: true}))
+	redirects, err := m.DeleteRedirectsByLabels(hosts, target.Labels{"env": "staging"})
+	assert.NoError(t, err)
+	assert.Len(t, redirects, 1)
+	assert.Equal(t, "www.example.com", redirects[0].Src)
+}
+
+func routeSources(routes []target.RouteWithActive) []string {
+	a := make([]string, 0, len(routes))
+	for _, i := range routes {
+		a = append(a, i.Src)
+	}
+	return a
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/mrmelon54/trie"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type Router struct {
@@ -15,6 +16,7 @@ type Router struct {
 	redirect map[string]*trie.Trie[target.Redirect]
 	notFound http.Handler
 	proxy    *proxy.HybridTransport
+	size     int // number of routes and redirects added
 }
 
 func New(proxy *proxy.HybridTransport) *Router {
@@ -50,11 +52,13 @@ func (r *Router) AddRoute(t target.Route) {
 	t.Proxy = r.proxy
 	host, path := utils.SplitHostPath(t.Src)
 	r.hostRoute(host).PutString(path, t)
+	r.size++
 }
 
 func (r *Router) AddRedirect(t target.Redirect) {
 	host, path := utils.SplitHostPath(t.Src)
 	r.hostRedirect(host).PutString(path, t)
+	r.size++
 }
 
 func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
@@ -62,11 +66,12 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		req.URL.Path = "/"
 	}
 
+	start := time.Now()
 	host, _, _ := utils.SplitDomainPort(req.Host, 0)
-	if r.serveRedirectHTTP(rw, req, host) {
+	if r.serveRedirectHTTP(rw, req, host, start) {
 		return
 	}
-	if r.serveRouteHTTP(rw, req, host) {
+	if r.serveRouteHTTP(rw, req, host, start) {
 		return
 	}
 
@@ -78,24 +83,24 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	wildcardHost := "*" + host[parentHostDot:]
 
-	if r.serveRedirectHTTP(rw, req, wildcardHost) {
+	if r.serveRedirectHTTP(rw, req, wildcardHost, start) {
 		return
 	}
-	if r.serveRouteHTTP(rw, req, wildcardHost) {
+	if r.serveRouteHTTP(rw, req, wildcardHost, start) {
 		return
 	}
 
 	utils.RespondVioletError(rw, http.StatusTeapot, "No route")
 }
 
-func (r *Router) serveRouteHTTP(rw http.ResponseWriter, req *http.Request, host string) bool {
+func (r *Router) serveRouteHTTP(rw http.ResponseWriter, req *http.Request, host string, start time.Time) bool {
 	h := r.route[host]
-	return getServeData(rw, req, h)
+	return getServeData(rw, req, h, start)
 }
 
-func (r *Router) serveRedirectHTTP(rw http.ResponseWriter, req *http.Request, host string) bool {
+func (r *Router) serveRedirectHTTP(rw http.ResponseWriter, req *http.Request, host string, start time.Time) bool {
 	h := r.redirect[host]
-	return getServeData(rw, req, h)
+	return getServeData(rw, req, h, start)
 }
 
 type serveDataInterface interface {
@@ -103,7 +108,7 @@ type serveDataInterface interface {
 	ServeHTTP(rw http.ResponseWriter, req *http.Request)
 }
 
-func getServeData[T serveDataInterface](rw http.ResponseWriter, req *http.Request, h *trie.Trie[T]) bool {
+func getServeData[T serveDataInterface](rw http.ResponseWriter, req *http.Request, h *trie.Trie[T], start time.Time) bool {
 	if h == nil {
 		return false
 	}
@@ -111,6 +116,7 @@ func getServeData[T serveDataInterface](rw http.ResponseWriter, req *http.Reques
 	for i := len(pairs) - 1; i >= 0; i-- {
 		if pairs[i].Value.HasFlag(target.FlagPre) || pairs[i].Key == req.URL.Path {
 			req.URL.Path = strings.TrimPrefix(req.URL.Path, pairs[i].Key)
+			utils.GetRequestInfo(req).LookupTime = time.Since(start)
 			pairs[i].Value.ServeHTTP(rw, req)
 			return true
 		}
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"path"
 	"testing"
+	"time"
 )
 
 type routeTestBase struct {
@@ -322,5 +323,5 @@ func TestGetServeData_Route(t *testing.T) {
 	rec := httptest.NewRecorder()
 	pairs := h.GetAllKeyValues([]byte(req.URL.Path))
 	fmt.Printf("%#v\n", pairs)
-	assert.True(t, getServeData(rec, req, h))
+	assert.True(t, getServeData(rec, req, h, time.Now()))
 }
@@ -0,0 +1,48 @@
+package servers
+
+import (
+	"github.com/1f349/violet/accesslog"
+	"github.com/1f349/violet/utils"
+	"net/http"
+	"time"
+)
+
+// setupAccessLog is an internal function to create a middleware which writes
+// an access log entry for each request.
+func setupAccessLog(log *accesslog.AccessLog, next http.Handler) http.Handler {
+	if log == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req, info := utils.WithRequestInfo(req)
+		rec := newResponseRecorder(rw)
+		body := &countReader{ReadCloser: http.NoBody}
+		if req.Body != nil && req.Body != http.NoBody {
+			body.ReadCloser = req.Body
+			req.Body = body
+		}
+
+		next.ServeHTTP(rec, req)
+
+		log.Log(accesslog.Entry{
+			Time:           info.Start,
+			RequestId:      info.RequestId,
+			Remote:         req.RemoteAddr,
+			Method:         req.Method,
+			URI:            req.RequestURI,
+			Proto:          req.Proto,
+			Host:           req.Host,
+			Status:         rec.Status(),
+			BytesIn:        body.n,
+			BytesOut:       rec.written,
+			Referer:        req.Referer(),
+			UserAgent:      req.UserAgent(),
+			Route:          info.Route,
+			Upstream:       info.Upstream,
+			UpstreamStatus: info.UpstreamStatus,
+			Duration:       time.Since(info.Start),
+			RouteTime:      info.RouteTime,
+			UpstreamTime:   info.UpstreamTime,
+		})
+	})
+}
@@ -0,0 +1,51 @@
+package servers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/1f349/violet/accesslog"
+	"github.com/1f349/violet/utils"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupAccessLog(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "access.log")
+	log, err := accesslog.New(accesslog.Output{Format: accesslog.FormatJSON, Path: p})
+	assert.NoError(t, err)
+
+	h := setupAccessLog(log, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		info := utils.GetRequestInfo(req)
+		info.Route = "example.com/logs"
+		info.Upstream = "http://127.0.0.1:8080/test"
+		info.UpstreamStatus = http.StatusCreated
+		_, _ = io.Copy(io.Discard, req.Body)
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = rw.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/test", strings.NewReader("abc"))
+	req.Header.Set("User-Agent", "test-agent")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.NoError(t, log.Close())
+
+	raw, err := os.ReadFile(p)
+	assert.NoError(t, err)
+	var j map[string]any
+	assert.NoError(t, json.NewDecoder(bytes.NewReader(raw)).Decode(&j))
+	assert.Equal(t, "POST", j["method"])
+	assert.Equal(t, "example.com", j["host"])
+	assert.Equal(t, 201.0, j["status"])
+	assert.Equal(t, 3.0, j["bytes_in"])
+	assert.Equal(t, 5.0, j["bytes_out"])
+	assert.Equal(t, "test-agent", j["user_agent"])
+	assert.Equal(t, "example.com/logs", j["route"])
+	assert.Equal(t, "http://127.0.0.1:8080/test", j["upstream"])
+	assert.Equal(t, 201.0, j["upstream_status"])
+}
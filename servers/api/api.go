@@ -1,11 +1,16 @@
 package api
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"github.com/1f349/mjwt"
 	"github.com/1f349/mjwt/auth"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/database"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
 	"github.com/1f349/violet/servers/conf"
+	"github.com/1f349/violet/snapshot"
 	"github.com/1f349/violet/utils"
 	"github.com/julienschmidt/httprouter"
 	"net/http"
@@ -15,7 +20,26 @@ import (
 // NewApiServer creates and runs a http server containing all the API
 // endpoints for the software
 //
-// `/compile` - reloads all domains, routes and redirects
+// `/ready` - reports 503 once the server starts draining
+//
+// `/compile` - reloads all domains, routes and redirects or reports the status
+// of the last compile
+//
+// `/export` and `/import` - transfers the routing configuration as JSON or YAML
+//
+// `/audit` - lists the changes made to the owned domains
+//
+// `/history` - lists, compares and rolls back configuration versions
+//
+// `/webhook` - lists the webhook deliveries and sends test payloads
+//
+// `/tail` - streams live requests for the owned domains as server-sent events
+//
+// `/events` - streams configuration changes for the owned domains as
+// server-sent events
+//
+// `/metrics` - outputs prometheus metrics, requires the auth token as a bearer
+// token and is disabled if the auth token is empty
 func NewApiServer(conf *conf.Conf, compileTarget utils.MultiCompilable, authToken string) *http.Server {
 	r := httprouter.New()
 
@@ -23,22 +47,41 @@ func NewApiServer(conf *conf.Conf, compileTarget utils.MultiCompilable, authToke
 		http.Error(rw, "Violet API Endpoint", http.StatusOK)
 	})
 
-	// Endpoint for compile action
-	r.POST("/compile", checkAuthWithPerm(conf.Signer, "violet:compile", func(rw http.ResponseWriter, req *http.Request, _ httprouter.Params, b AuthClaims) {
-		// Trigger the compile action
-		compileTarget.Compile()
-		rw.WriteHeader(http.StatusAccepted)
-	}))
+	// Endpoint for load balancer readiness checks
+	r.Handler(http.MethodGet, "/ready", conf.Ready)
+
+	// Endpoint for prometheus metrics
+	if authToken != "" {
+		metricsHandler := metrics.Handler()
+		r.GET("/metrics", func(rw http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+			if subtle.ConstantTimeCompare([]byte(utils.GetBearer(req)), []byte(authToken)) != 1 {
+				apiError(rw, http.StatusForbidden, "Invalid token", nil)
+				return
+			}
+			metricsHandler.ServeHTTP(rw, req)
+		})
+	}
+
+	SetupCompileApis(r, conf.Signer, compileTarget, conf.Audit)
 
 	// Endpoint for domains
-	domainFunc := domainManage(conf.Signer, conf.Domains)
+	domainFunc := domainManage(conf.Signer, conf.DB, conf.Domains, conf.Audit, conf.History)
 	r.PUT("/domain/:domain", domainFunc)
 	r.DELETE("/domain/:domain", domainFunc)
+	SetupDomainApis(r, conf.Signer, conf.DB, conf.Router, conf.Certs)
 
-	SetupTargetApis(r, conf.Signer, conf.Router)
+	SetupTargetApis(r, conf.Signer, conf.Router, conf.Audit, conf.History)
+	SetupWebsocketApis(r, conf.Signer, conf.Websocket)
+	SetupFaviconApis(r, conf.Signer, conf.Favicons, conf.Audit, conf.History)
+	SetupTailApis(r, conf.Signer, conf.Tail)
+	SetupEventApis(r, conf.Signer, conf.Events)
+	SetupSnapshotApis(r, conf.Signer, conf.DB, compileTarget, conf.Audit, conf.History)
+	SetupAuditApis(r, conf.Signer, conf.Audit)
+	SetupHistoryApis(r, conf.Signer, conf.History, compileTarget, conf.Audit)
+	SetupWebhookApis(r, conf.Signer, conf.Webhooks)
 
 	// Endpoint for acme-challenge
-	acmeChallengeFunc := acmeChallengeManage(conf.Signer, conf.Domains, conf.Acme)
+	acmeChallengeFunc := acmeChallengeManage(conf.Signer, conf.Domains, conf.Acme, conf.Audit)
 	r.PUT("/acme-challenge/:domain/:key/:value", acmeChallengeFunc)
 	r.DELETE("/acme-challenge/:domain/:key", acmeChallengeFunc)
 
@@ -62,26 +105,54 @@ func apiError(rw http.ResponseWriter, code int, m string, err error) {
 	})
 }
 
-func domainManage(keyStore *mjwt.KeyStore, domains utils.DomainProvider) httprouter.Handle {
+func domainManage(keyStore *mjwt.KeyStore, db *database.Queries, domains utils.DomainProvider, log *audit.Log, history *snapshot.History) httprouter.Handle {
 	return checkAuthWithPerm(keyStore, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain := params.ByName("domain")
+		active := req.Method == http.MethodPut
+		before := domainState(req, db, domain)
+
 		// add domain with active state
-		domains.Put(params.ByName("domain"), req.Method == http.MethodPut)
+		domains.Put(domain, active)
 		domains.Compile()
+
+		action := createOrUpdate(before)
+		if !active {
+			action = "delete"
+		}
+		log.Record(req.Context(), b.Subject, action, "domain", domain, before, domainState(req, db, domain))
+		recordHistory(req, history, b, action, "domain")
 		rw.WriteHeader(http.StatusAccepted)
 	})
 }
 
-func acmeChallengeManage(keyStore *mjwt.KeyStore, domains utils.DomainProvider, acme utils.AcmeChallengeProvider) httprouter.Handle {
+// domainState returns the stored domain for the audit log or nil if it
+// doesn't exist
+func domainState(req *http.Request, db *database.Queries, domain string) any {
+	if db == nil {
+		return nil
+	}
+	row, err := db.GetDomain(req.Context(), domain)
+	if err != nil {
+		return nil
+	}
+	return row
+}
+
+func acmeChallengeManage(keyStore *mjwt.KeyStore, domains utils.DomainProvider, acme utils.AcmeChallengeProvider, log *audit.Log) httprouter.Handle {
 	return checkAuthWithPerm(keyStore, "violet:acme-challenge", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
 		domain := params.ByName("domain")
 		if !domains.IsValid(domain) {
 			utils.RespondVioletError(rw, http.StatusBadRequest, "Invalid ACME challenge domain")
 			return
 		}
+		key := params.ByName("key")
 		if req.Method == http.MethodPut {
-			acme.Put(domain, params.ByName("key"), params.ByName("value"))
+			value := params.ByName("value")
+			acme.Put(domain, key, value)
+			log.Record(req.Context(), b.Subject, "create", "acme-challenge", domain, nil, map[string]string{"key": key, "value": value})
 		} else {
-			acme.Delete(domain, params.ByName("key"))
+			acme.Delete(domain, key)
+			log.Record(req.Context(), b.Subject, "delete", "acme-challenge", domain, map[string]string{"key": key}, nil)
 		}
 		rw.WriteHeader(http.StatusAccepted)
 	})
@@ -1,15 +1,59 @@
 package api
 
 import (
+	"github.com/1f349/violet"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/proxy"
+	"github.com/1f349/violet/proxy/websocket"
+	"github.com/1f349/violet/router"
 	"github.com/1f349/violet/servers/conf"
 	"github.com/1f349/violet/utils"
 	"github.com/1f349/violet/utils/fake"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
+// newTestConf creates the config for an API test with an in-memory database
+// named after the test, a route manager and an audit log
+func newTestConf(t *testing.T) *conf.Conf {
+	db, err := violet.InitDB("file:" + t.Name() + "?mode=memory&cache=shared")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return &conf.Conf{
+		DB:      db,
+		Domains: &fake.Domains{},
+		Acme:    utils.NewAcmeChallenge(),
+		Signer:  fake.SnakeOilProv.KeyStore(),
+		Router:  router.NewManager(db, proxy.NewHybridTransport(websocket.NewServer())),
+		Audit:   audit.New(db),
+	}
+}
+
+// testClient sends requests to the API server with the token
+type testClient struct {
+	srv   *http.Server
+	token string
+}
+
+// do sends the request, extra headers are given as name and value pairs and
+// empty values are skipped
+func (c testClient) do(method, path, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "https://example.com"+path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	for i := 0; i+1 < len(header); i += 2 {
+		if header[i+1] != "" {
+			req.Header.Set(header[i], header[i+1])
+		}
+	}
+	rec := httptest.NewRecorder()
+	c.srv.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
 func TestNewApiServer_Compile(t *testing.T) {
 	apiConf := &conf.Conf{
 		Domains: &fake.Domains{},
@@ -125,3 +169,53 @@ func TestNewApiServer_AcmeChallenge_Delete(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
 	assert.Equal(t, "Invalid ACME challenge domain", res.Header.Get("X-Violet-Error"))
 }
+
+func TestNewApiServer_Ready(t *testing.T) {
+	apiConf := &conf.Conf{
+		Domains: &fake.Domains{},
+		Acme:    utils.NewAcmeChallenge(),
+		Signer:  fake.SnakeOilProv.KeyStore(),
+		Ready:   new(utils.Readiness),
+	}
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/ready", nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	apiConf.Ready.Drain()
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestNewApiServer_Metrics(t *testing.T) {
+	apiConf := &conf.Conf{
+		Domains: &fake.Domains{},
+		Acme:    utils.NewAcmeChallenge(),
+		Signer:  fake.SnakeOilProv.KeyStore(),
+	}
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+
+	req, err := http.NewRequest(http.MethodGet, "https://example.com/metrics", nil)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	req.Header.Set("Authorization", "Bearer abc123")
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "violet_rate_limit_rejections_total")
+
+	// metrics are disabled without a token
+	srv = NewApiServer(apiConf, utils.MultiCompilable{}, "")
+	rec = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/logger"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+func SetupAuditApis(r *httprouter.Router, keyStore *mjwt.KeyStore, log *audit.Log) {
+	// Endpoint for listing the audit log of the owned domains
+	r.GET("/audit", checkAuthWithPerm(keyStore, "violet:audit", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+
+		var domains []string
+		if domain := q.Get("domain"); domain != "" {
+			if !validateDomainOwnershipClaims(domain, b.Claims.Perms) {
+				apiError(rw, http.StatusForbidden, "Token cannot view the specified domain", nil)
+				return
+			}
+			domains = []string{audit.KeyDomain(domain)}
+		} else {
+			domains = getDomainOwnershipClaims(b.Claims.Perms)
+
+			// global entries are visible to tokens which can compile
+			if b.Claims.Perms.Has("violet:compile") {
+				domains = append(domains, "")
+			}
+		}
+
+		before, ok := parseQueryInt(rw, q.Get("before"), 0, 0, -1, "Invalid before value")
+		if !ok {
+			return
+		}
+		limit, ok := parseQueryInt(rw, q.Get("limit"), auditDefaultLimit, 1, auditMaxLimit, "Invalid limit value")
+		if !ok {
+			return
+		}
+
+		entries, err := log.List(req.Context(), domains, before, int(limit))
+		if err != nil {
+			logger.Logger.Infof("Failed to get audit log from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get audit log from database", err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(entries)
+	}))
+}
+
+// createOrUpdate returns the audit action for storing an item which existed
+// before if the value is not nil
+func createOrUpdate(before any) string {
+	if before == nil {
+		return "create"
+	}
+	return "update"
+}
+
+// activeAction returns the audit action for enabling or disabling an item
+func activeAction(active bool) string {
+	if active {
+		return "enable"
+	}
+	return "disable"
+}
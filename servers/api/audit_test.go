@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSetupAuditApis(t *testing.T) {
+	srv := NewApiServer(newTestConf(t), utils.MultiCompilable{}, "abc123")
+	com := testClient{srv, fake.GenSnakeOilKey("violet:route", "violet:audit", "domain:owns=example.com")}
+	org := testClient{srv, fake.GenSnakeOilKey("violet:route", "violet:compile", "violet:audit", "domain:owns=example.org")}
+	list := func(c testClient, path string) []audit.Entry {
+		rec := c.do(http.MethodGet, path, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var entries []audit.Entry
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&entries))
+		return entries
+	}
+
+	assert.Equal(t, http.StatusOK, com.do(http.MethodPost, "/route", `{"src":"example.com/a","dst":"127.0.0.1:8080","desc":"","flags":0,"websocket_limit":0,"active":true}`).Code)
+	assert.Equal(t, http.StatusOK, com.do(http.MethodPatch, "/route", `{"src":"example.com/a","dst":"127.0.0.1:8081"}`).Code)
+	assert.Equal(t, http.StatusOK, org.do(http.MethodPost, "/route", `{"src":"example.org","dst":"127.0.0.1:8082","active":true}`).Code)
+	assert.Equal(t, http.StatusOK, com.do(http.MethodDelete, "/route", `{"src":"example.com/a"}`).Code)
+	assert.Equal(t, http.StatusAccepted, org.do(http.MethodPost, "/compile", "").Code)
+
+	// only changes to owned domains are listed
+	entries := list(com, "/audit")
+	if assert.Len(t, entries, 3) {
+		assert.Equal(t, "delete", entries[0].Action)
+		assert.JSONEq(t, `{"src":"example.com/a","dst":"127.0.0.1:8081","desc":"","flags":0,"websocket_limit":0,"active":true}`, string(entries[0].Before))
+		assert.Nil(t, entries[0].After)
+
+		assert.Equal(t, "update", entries[1].Action)
+		assert.JSONEq(t, `{"src":"example.com/a","dst":"127.0.0.1:8080","desc":"","flags":0,"websocket_limit":0,"active":true}`, string(entries[1].Before))
+		assert.JSONEq(t, `{"src":"example.com/a","dst":"127.0.0.1:8081","desc":"","flags":0,"websocket_limit":0,"active":true}`, string(entries[1].After))
+
+		assert.Equal(t, "create", entries[2].Action)
+		assert.Equal(t, "route", entries[2].Type)
+		assert.Equal(t, "abc", entries[2].Actor)
+		assert.Nil(t, entries[2].Before)
+	}
+
+	// compiles are visible to tokens which can compile
+	entries = list(org, "/audit")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "compile", entries[0].Action)
+		assert.Equal(t, "example.org", entries[1].Key)
+	}
+	entries = list(org, "/audit?domain=example.org")
+	assert.Len(t, entries, 1)
+
+	// pagination
+	entries = list(com, "/audit?limit=2")
+	assert.Len(t, entries, 2)
+	entries = list(com, "/audit?limit=2&before="+strconv.FormatInt(entries[1].ID, 10))
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "create", entries[0].Action)
+	}
+
+	assert.Equal(t, http.StatusForbidden, com.do(http.MethodGet, "/audit?domain=example.org", "").Code)
+	assert.Equal(t, http.StatusBadRequest, com.do(http.MethodGet, "/audit?limit=0", "").Code)
+	assert.Equal(t, http.StatusBadRequest, com.do(http.MethodGet, "/audit?before=abc", "").Code)
+	assert.Equal(t, http.StatusForbidden, testClient{srv, fake.GenSnakeOilKey("domain:owns=example.com")}.do(http.MethodGet, "/audit", "").Code)
+}
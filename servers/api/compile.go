@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// compileWaitTimeout is the longest time a request will wait for a compile to
+// finish, this is kept below the write timeout of the server
+const compileWaitTimeout = 50 * time.Second
+
+func SetupCompileApis(r *httprouter.Router, keyStore *mjwt.KeyStore, compileTarget utils.MultiCompilable, log *audit.Log) {
+	// Endpoint for the status of the last compile
+	r.GET("/compile", checkAuthWithPerm(keyStore, "violet:compile", func(rw http.ResponseWriter, req *http.Request, _ httprouter.Params, b AuthClaims) {
+		statuses := make([]utils.CompileStatus, 0, len(compileTarget))
+		for _, i := range compileReporters(compileTarget) {
+			statuses = append(statuses, i.CompileStatus())
+		}
+		_ = json.NewEncoder(rw).Encode(statuses)
+	}))
+
+	// Endpoint for compile action
+	r.POST("/compile", checkAuthWithPerm(keyStore, "violet:compile", func(rw http.ResponseWriter, req *http.Request, _ httprouter.Params, b AuthClaims) {
+		wait := false
+		if v := req.URL.Query().Get("wait"); v != "" {
+			var err error
+			wait, err = strconv.ParseBool(v)
+			if err != nil {
+				apiError(rw, http.StatusBadRequest, "Invalid wait value", err)
+				return
+			}
+		}
+
+		// Trigger the compile action
+		since := time.Now()
+		compileTarget.Compile()
+		if !wait {
+			log.Record(req.Context(), b.Subject, "compile", "compile", "", nil, nil)
+			rw.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), compileWaitTimeout)
+		defer cancel()
+
+		code := http.StatusOK
+		statuses := make([]utils.CompileStatus, 0, len(compileTarget))
+		for _, i := range compileReporters(compileTarget) {
+			status, err := i.WaitCompile(ctx, since)
+			if errors.Is(err, context.DeadlineExceeded) {
+				code = http.StatusGatewayTimeout
+			} else if err != nil {
+				log.Record(req.Context(), b.Subject, "compile", "compile", "", nil, nil)
+				return
+			}
+			if !status.Success {
+				code = max(code, http.StatusInternalServerError)
+			}
+			statuses = append(statuses, status)
+		}
+		log.Record(req.Context(), b.Subject, "compile", "compile", "", nil, statuses)
+		rw.WriteHeader(code)
+		_ = json.NewEncoder(rw).Encode(statuses)
+	}))
+}
+
+// compileReporters returns the items which record their compile status
+func compileReporters(compileTarget utils.MultiCompilable) []utils.CompileReporter {
+	var reporters []utils.CompileReporter
+	for _, i := range compileTarget {
+		if r, ok := i.(utils.CompileReporter); ok {
+			reporters = append(reporters, r)
+		}
+	}
+	return reporters
+}
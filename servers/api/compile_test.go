@@ -0,0 +1,53 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestSetupCompileApis(t *testing.T) {
+	domains := fake.NewReportingCompilable("domains")
+	domains.Items = 3
+	router := fake.NewReportingCompilable("router")
+	srv := NewApiServer(newTestConf(t), utils.MultiCompilable{domains, router, &fake.Compilable{}}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:compile")}
+
+	// nothing has been compiled yet
+	rec := api.do(http.MethodGet, "/compile", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var statuses []utils.CompileStatus
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	assert.Equal(t, []utils.CompileStatus{{Name: "domains"}, {Name: "router"}}, statuses)
+
+	rec = api.do(http.MethodPost, "/compile?wait=true", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	if assert.Len(t, statuses, 2) {
+		assert.True(t, statuses[0].Success)
+		assert.Equal(t, 3, statuses[0].Items)
+		assert.True(t, statuses[1].Success)
+	}
+
+	// failed compiles are reported
+	router.Err = errors.New("bad route")
+	rec = api.do(http.MethodPost, "/compile?wait=true", "")
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	if assert.Len(t, statuses, 2) {
+		assert.True(t, statuses[0].Success)
+		assert.False(t, statuses[1].Success)
+		assert.Equal(t, "bad route", statuses[1].Error)
+	}
+
+	rec = api.do(http.MethodGet, "/compile", "")
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&statuses))
+	assert.Equal(t, "bad route", statuses[1].Error)
+
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPost, "/compile?wait=maybe", "").Code)
+	assert.Equal(t, http.StatusAccepted, api.do(http.MethodPost, "/compile", "").Code)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/router"
+	"github.com/1f349/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+// domainStatus is the state of a domain and the number of items configured
+// for the domain and its subdomains
+type domainStatus struct {
+	Domain    string `json:"domain"`
+	Active    bool   `json:"active"`
+	Routes    int    `json:"routes"`
+	Redirects int    `json:"redirects"`
+	Certs     int    `json:"certs"`
+	Favicons  int    `json:"favicons"`
+}
+
+func SetupDomainApis(r *httprouter.Router, keyStore *mjwt.KeyStore, db *database.Queries, manager *router.Manager, certs utils.CertProvider) {
+	// Endpoint for listing domains
+	r.GET("/domain", checkAuthWithPerm(keyStore, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		rows, err := db.GetAllDomains(req.Context())
+		if err != nil {
+			logger.Logger.Infof("Failed to get domains from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domains from database", err)
+			return
+		}
+
+		domains := make([]domainStatus, 0, len(rows))
+		for _, row := range rows {
+			if validateDomainOwnershipClaims(row.Domain, b.Claims.Perms) {
+				domains = append(domains, domainStatus{Domain: row.Domain, Active: row.Active})
+			}
+		}
+		if err := countDomainItems(req.Context(), db, manager, certs, domains); err != nil {
+			logger.Logger.Infof("Failed to count domain items: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to count domain items", err)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(domains)
+	}))
+	r.GET("/domain/:domain", checkAuthWithPerm(keyStore, "violet:domains", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domain := params.ByName("domain")
+		if !validateDomainOwnershipClaims(domain, b.Claims.Perms) {
+			apiError(rw, http.StatusForbidden, "Token cannot view the specified domain", nil)
+			return
+		}
+
+		row, err := db.GetDomain(req.Context(), domain)
+		if errors.Is(err, sql.ErrNoRows) {
+			apiError(rw, http.StatusNotFound, "Unknown domain", nil)
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to get domain from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get domain from database", err)
+			return
+		}
+
+		domains := []domainStatus{{Domain: row.Domain, Active: row.Active}}
+		if err := countDomainItems(req.Context(), db, manager, certs, domains); err != nil {
+			logger.Logger.Infof("Failed to count domain items: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to count domain items", err)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(domains[0])
+	}))
+}
+
+// countDomainItems fills in the number of routes, redirects, certificates and
+// favicons configured for each domain
+func countDomainItems(ctx context.Context, db *database.Queries, manager *router.Manager, certs utils.CertProvider, domains []domainStatus) error {
+	if len(domains) == 0 {
+		return nil
+	}
+	names := make([]string, len(domains))
+	for i := range domains {
+		names[i] = domains[i].Domain
+	}
+
+	routes, err := manager.GetAllRoutes(names)
+	if err != nil {
+		return err
+	}
+	redirects, err := manager.GetAllRedirects(names)
+	if err != nil {
+		return err
+	}
+	favicons, err := db.GetFavicons(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range domains {
+		d := &domains[i]
+		for _, route := range routes {
+			if route.OnDomain(d.Domain) {
+				d.Routes++
+			}
+		}
+		for _, redirect := range redirects {
+			if redirect.OnDomain(d.Domain) {
+				d.Redirects++
+			}
+		}
+		for _, favicon := range favicons {
+			if utils.IsDomainOrSubdomain(favicon.Host, d.Domain) {
+				d.Favicons++
+			}
+		}
+		if certs != nil {
+			d.Certs = certs.CountCertsForDomain(d.Domain)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/target"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+type fakeCerts map[string]int
+
+func (f fakeCerts) GetCertForDomain(string) *tls.Certificate { return nil }
+func (f fakeCerts) CountCertsForDomain(domain string) int    { return f[domain] }
+func (f fakeCerts) Compile()                                 {}
+
+func TestSetupDomainApis(t *testing.T) {
+	apiConf := newTestConf(t)
+	apiConf.Certs = fakeCerts{"example.com": 2}
+	db := apiConf.DB
+	ctx := context.Background()
+	assert.NoError(t, db.AddDomain(ctx, database.AddDomainParams{Domain: "example.com", Active: true}))
+	assert.NoError(t, db.AddDomain(ctx, database.AddDomainParams{Domain: "example.org", Active: false}))
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.com/a", Destination: "127.0.0.1:8080", Active: true}))
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "*.example.com", Destination: "127.0.0.1:8081", Active: false}))
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.org", Destination: "127.0.0.1:8082", Active: true}))
+	assert.NoError(t, db.AddRedirect(ctx, database.AddRedirectParams{Source: "www.example.com", Destination: "example.com", Flags: target.FlagPre, Active: true}))
+	assert.NoError(t, db.UpdateFaviconCache(ctx, database.UpdateFaviconCacheParams{Host: "www.example.com", Svg: sql.NullString{String: "https://example.com/icon.svg", Valid: true}}))
+
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:domains", "domain:owns=example.com")}
+
+	// only owned domains are listed
+	rec := api.do(http.MethodGet, "/domain", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var domains []domainStatus
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&domains))
+	assert.Equal(t, []domainStatus{{Domain: "example.com", Active: true, Routes: 2, Redirects: 1, Certs: 2, Favicons: 1}}, domains)
+
+	rec = api.do(http.MethodGet, "/domain/example.com", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var domain domainStatus
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&domain))
+	assert.Equal(t, domains[0], domain)
+
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodGet, "/domain/example.org", "").Code)
+
+	api.token = fake.GenSnakeOilKey("violet:domains", "domain:owns=example.net")
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/domain/example.net", "").Code)
+}
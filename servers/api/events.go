@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/events"
+	"github.com/1f349/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+func SetupEventApis(r *httprouter.Router, keyStore *mjwt.KeyStore, stream *events.Stream) {
+	if stream == nil {
+		return
+	}
+
+	// Endpoint for streaming configuration changes as server-sent events
+	r.GET("/events", checkAuthWithPerm(keyStore, "violet:events", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		var types []string
+		if v := q.Get("types"); v != "" {
+			types = strings.Split(v, ",")
+		}
+		domain := q.Get("domain")
+		if domain != "" {
+			if !validateDomainOwnershipClaims(domain, b.Claims.Perms) {
+				apiError(rw, http.StatusForbidden, "Token cannot view the specified domain", nil)
+				return
+			}
+			domain, _ = utils.GetTopFqdn(domain)
+		}
+
+		// browsers send the header when reconnecting
+		lastId := req.Header.Get("Last-Event-ID")
+		if lastId == "" {
+			lastId = q.Get("last_event_id")
+		}
+		var since uint64
+		if lastId != "" {
+			var err error
+			since, err = strconv.ParseUint(lastId, 10, 64)
+			if err != nil {
+				apiError(rw, http.StatusBadRequest, "Invalid last event id", err)
+				return
+			}
+		}
+
+		canCompile := b.Claims.Perms.Has("violet:compile")
+		backlog, resumed, c, cancel := stream.Subscribe(since, func(e events.Event) bool {
+			if types != nil && !slices.Contains(types, e.Type) {
+				return false
+			}
+			if e.Domain == "" {
+				// global events are visible to tokens which can compile
+				return domain == "" && canCompile
+			}
+			if domain != "" {
+				return e.Domain == domain
+			}
+			return validateDomainOwnershipClaims(e.Domain, b.Claims.Perms)
+		}, 64)
+		defer cancel()
+
+		var initial [][]byte
+		if !resumed {
+			// the missed events are no longer available so clients should
+			// reload the full configuration
+			initial = append(initial, []byte("event: reset\ndata: {}\n\n"))
+		}
+		for _, e := range backlog {
+			if msg := formatConfigEvent(e); msg != nil {
+				initial = append(initial, msg)
+			}
+		}
+		serveEventStream(rw, req, initial, c, formatConfigEvent)
+	}))
+}
+
+// formatConfigEvent outputs the event with the id used for resuming
+func formatConfigEvent(e events.Event) []byte {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return nil
+	}
+	return []byte("id: " + strconv.FormatUint(e.ID, 10) + "\nevent: " + e.Type + "\ndata: " + string(raw) + "\n\n")
+}
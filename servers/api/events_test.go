@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/1f349/violet/events"
+	"github.com/1f349/violet/servers/conf"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSetupEventApis(t *testing.T) {
+	stream := events.New(10)
+	apiConf := &conf.Conf{
+		Domains: &fake.Domains{},
+		Acme:    utils.NewAcmeChallenge(),
+		Signer:  fake.SnakeOilProv.KeyStore(),
+		Events:  stream,
+	}
+	srv := httptest.NewServer(NewApiServer(apiConf, utils.MultiCompilable{}, "abc123").Handler)
+	defer srv.Close()
+
+	eventReq := func(query, lastId, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/events"+query, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if lastId != "" {
+			req.Header.Set("Last-Event-ID", lastId)
+		}
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return res
+	}
+	readEvent := func(r *bufio.Reader) (id, name string, e events.Event) {
+		for {
+			line, err := r.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				return
+			case strings.HasPrefix(line, "id: "):
+				id = line[len("id: "):]
+			case strings.HasPrefix(line, "event: "):
+				name = line[len("event: "):]
+			case strings.HasPrefix(line, "data: "):
+				assert.NoError(t, json.Unmarshal([]byte(line[len("data: "):]), &e))
+			}
+		}
+	}
+
+	token := fake.GenSnakeOilKey("violet:events", "domain:owns=example.com")
+	res := eventReq("?domain=example.org", "", token)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	_ = res.Body.Close()
+	res = eventReq("", "abc", token)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	_ = res.Body.Close()
+
+	stream.Publish(events.Event{Type: "route", Action: "create", Key: "example.com/a", Domain: "example.com"})
+	stream.Publish(events.Event{Type: "route", Action: "create", Key: "example.org", Domain: "example.org"})
+	stream.Publish(events.Event{Type: "compile", Action: "compile", Key: "router"})
+	stream.Publish(events.Event{Type: "redirect", Action: "delete", Key: "www.example.com", Domain: "example.com"})
+
+	// an unknown id asks the client to reload
+	res = eventReq("", "1", token)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	r := bufio.NewReader(res.Body)
+	_, name, _ := readEvent(r)
+	assert.Equal(t, "reset", name)
+	_ = res.Body.Close()
+
+	// new events are sent once the stream is open
+	res = eventReq("", "", token)
+	defer res.Body.Close()
+	r = bufio.NewReader(res.Body)
+
+	stream.Publish(events.Event{Type: "route", Action: "update", Key: "example.com/a", Domain: "example.com"})
+	id, name, e := readEvent(r)
+	assert.Equal(t, "route", name)
+	assert.Equal(t, "update", e.Action)
+	assert.Equal(t, strconv.FormatUint(e.ID, 10), id)
+	first := strconv.FormatUint(e.ID-4, 10) // id of the first event
+	_ = res.Body.Close()
+
+	// resuming only sends the owned events published after the id
+	stream.Publish(events.Event{Type: "route", Action: "delete", Key: "example.org", Domain: "example.org"})
+	stream.Publish(events.Event{Type: "domain", Action: "create", Key: "example.com", Domain: "example.com"})
+	res = eventReq("", id, token)
+	defer res.Body.Close()
+	r = bufio.NewReader(res.Body)
+	_, name, e = readEvent(r)
+	assert.Equal(t, "domain", name)
+	assert.Equal(t, "example.com", e.Key)
+
+	// global events are only sent to tokens which can compile
+	res = eventReq("?types=compile,domain", first, fake.GenSnakeOilKey("violet:events", "violet:compile", "domain:owns=example.com"))
+	defer res.Body.Close()
+	r = bufio.NewReader(res.Body)
+	_, name, e = readEvent(r)
+	assert.Equal(t, "compile", name)
+	assert.Equal(t, "router", e.Key)
+	_, name, _ = readEvent(r)
+	assert.Equal(t, "domain", name)
+
+	// closing the stream ends the response
+	stream.Close()
+	_, err := r.ReadString('\n')
+	assert.Error(t, err)
+}
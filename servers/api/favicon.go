@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/favicons"
+	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/snapshot"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strings"
+)
+
+// faviconJson is the request body for setting the favicon urls of a host
+type faviconJson struct {
+	Svg string `json:"svg"`
+	Png string `json:"png"`
+	Ico string `json:"ico"`
+}
+
+func SetupFaviconApis(r *httprouter.Router, keyStore *mjwt.KeyStore, fav *favicons.Favicons, log *audit.Log, history *snapshot.History) {
+	// Endpoints for favicons
+	r.GET("/favicon", checkAuthWithPerm(keyStore, "violet:favicons", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		domains := getDomainOwnershipClaims(b.Claims.Perms)
+
+		icons, err := fav.GetAllFavicons(domains)
+		if err != nil {
+			logger.Logger.Infof("Failed to get favicons from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get favicons from database", err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(icons)
+	}))
+	r.GET("/favicon/:host", checkFaviconHost(keyStore, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		icons, err := fav.GetFavicon(params.ByName("host"))
+		if errors.Is(err, favicons.ErrFaviconNotFound) {
+			apiError(rw, http.StatusNotFound, "Unknown favicon host", nil)
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to get favicon from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get favicon from database", err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(icons)
+	}))
+	r.PUT("/favicon/:host", checkFaviconHost(keyStore, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		var j faviconJson
+		if json.NewDecoder(req.Body).Decode(&j) != nil {
+			apiError(rw, http.StatusBadRequest, "Invalid request body", nil)
+			return
+		}
+		if j.Svg == "" && j.Png == "" && j.Ico == "" {
+			apiError(rw, http.StatusBadRequest, "Missing favicon url", nil)
+			return
+		}
+		for _, i := range []string{j.Svg, j.Png, j.Ico} {
+			if i != "" && !favicons.ValidUrl(i) {
+				apiError(rw, http.StatusBadRequest, "Invalid favicon url", nil)
+				return
+			}
+		}
+
+		icons := favicons.FaviconUrls{Host: params.ByName("host"), Svg: j.Svg, Png: j.Png, Ico: j.Ico}
+		before := faviconState(fav, icons.Host)
+		err := fav.InsertFavicon(icons)
+		if err != nil {
+			logger.Logger.Infof("Failed to insert favicon into database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to insert favicon into database", err)
+			return
+		}
+		fav.CompileHost(icons.Host)
+		log.Record(req.Context(), b.Subject, createOrUpdate(before), "favicon", icons.Host, before, icons)
+		recordHistory(req, history, b, createOrUpdate(before), "favicon")
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(icons)
+	}))
+	r.DELETE("/favicon/:host", checkFaviconHost(keyStore, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host := params.ByName("host")
+		before := faviconState(fav, host)
+		err := fav.DeleteFavicon(host)
+		if errors.Is(err, favicons.ErrFaviconNotFound) {
+			apiError(rw, http.StatusNotFound, "Unknown favicon host", nil)
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to delete favicon from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to delete favicon from database", err)
+			return
+		}
+		fav.CompileHost(host)
+		log.Record(req.Context(), b.Subject, "delete", "favicon", host, before, nil)
+		recordHistory(req, history, b, "delete", "favicon")
+	}))
+}
+
+// faviconState returns the stored favicon urls for the audit log or nil if
+// they don't exist
+func faviconState(fav *favicons.Favicons, host string) any {
+	icons, err := fav.GetFavicon(host)
+	if err != nil {
+		return nil
+	}
+	return icons
+}
+
+// checkFaviconHost validates the host parameter and checks the token owns the
+// domain of the host
+func checkFaviconHost(keyStore *mjwt.KeyStore, cb AuthCallback) httprouter.Handle {
+	return checkAuthWithPerm(keyStore, "violet:favicons", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		host := params.ByName("host")
+		if strings.ContainsAny(host, ":/") {
+			apiError(rw, http.StatusBadRequest, "Invalid favicon host", nil)
+			return
+		}
+		if !validateDomainOwnershipClaims(host, b.Claims.Perms) {
+			apiError(rw, http.StatusBadRequest, "Token cannot modify the specified domain", nil)
+			return
+		}
+		cb(rw, req, params, b)
+	})
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/violet/favicons"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSetupFaviconApis(t *testing.T) {
+	examplePng, err := os.ReadFile("../../favicons/example.png")
+	assert.NoError(t, err)
+	iconSrv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write(examplePng)
+	}))
+	defer iconSrv.Close()
+
+	apiConf := newTestConf(t)
+	fav := favicons.New(apiConf.DB, "inkscape")
+	apiConf.Favicons = fav
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:favicons", "domain:owns=example.com")}
+
+	// invalid requests
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/favicon/example.org", `{"png":"`+iconSrv.URL+`"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/favicon/example.com", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPut, "/favicon/example.com", `{"png":"/icon.png"}`).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/favicon/example.com", "").Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodDelete, "/favicon/example.com", "").Code)
+
+	// create the favicon and wait for the host to compile
+	rec := api.do(http.MethodPut, "/favicon/www.example.com", `{"png":"`+iconSrv.URL+`/icon.png"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	for i := 0; fav.GetIcons("www.example.com") == nil; i++ {
+		if !assert.Less(t, i, 100) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, iconSrv.URL+"/icon.png", fav.GetIcons("www.example.com").Png.Url)
+
+	rec = api.do(http.MethodGet, "/favicon", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var icons []favicons.FaviconUrls
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&icons))
+	assert.Equal(t, []favicons.FaviconUrls{{Host: "www.example.com", Png: iconSrv.URL + "/icon.png"}}, icons)
+
+	rec = api.do(http.MethodGet, "/favicon/www.example.com", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var icon favicons.FaviconUrls
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&icon))
+	assert.Equal(t, icons[0], icon)
+
+	// delete the favicon and wait for the host to be removed
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/favicon/www.example.com", "").Code)
+	for i := 0; fav.GetIcons("www.example.com") != nil; i++ {
+		if !assert.Less(t, i, 100) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+const (
+	historyDefaultLimit = 100
+	historyMaxLimit     = 1000
+)
+
+func SetupHistoryApis(r *httprouter.Router, keyStore *mjwt.KeyStore, history *snapshot.History, compileTarget utils.MultiCompilable, log *audit.Log) {
+	if history == nil {
+		return
+	}
+
+	// Endpoint for listing the configuration versions changing the owned
+	// domains
+	r.GET("/history", checkAuthWithPerm(keyStore, "violet:history", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		before, ok := parseQueryInt(rw, q.Get("before"), 0, 0, -1, "Invalid before value")
+		if !ok {
+			return
+		}
+		limit, ok := parseQueryInt(rw, q.Get("limit"), historyDefaultLimit, 1, historyMaxLimit, "Invalid limit value")
+		if !ok {
+			return
+		}
+
+		versions, err := history.List(req.Context(), getDomainOwnershipClaims(b.Claims.Perms), before, int(limit))
+		if err != nil {
+			logger.Logger.Infof("Failed to get history from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get history from database", err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(versions)
+	}))
+
+	// Endpoint for the owned items in a configuration version
+	r.GET("/history/:id", checkAuthWithPerm(keyStore, "violet:history", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		version, s, ok := getHistoryVersion(rw, req, history, params.ByName("id"))
+		if !ok {
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(struct {
+			snapshot.Version
+			Snapshot *snapshot.Snapshot `json:"snapshot"`
+		}{version, s.Visible(ownershipFilter(b))})
+	}))
+
+	// Endpoint for comparing the owned items in two versions, the current
+	// configuration is used if the to parameter is missing
+	r.GET("/history/:id/diff", checkAuthWithPerm(keyStore, "violet:history", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		_, from, ok := getHistoryVersion(rw, req, history, params.ByName("id"))
+		if !ok {
+			return
+		}
+
+		filter := ownershipFilter(b)
+		var to *snapshot.Snapshot
+		if v := req.URL.Query().Get("to"); v != "" {
+			if _, to, ok = getHistoryVersion(rw, req, history, v); !ok {
+				return
+			}
+		} else {
+			current, err := history.Current(req.Context(), filter)
+			if err != nil {
+				logger.Logger.Infof("Failed to export from database: %s\n", err)
+				apiError(rw, http.StatusInternalServerError, "Failed to export from database", err)
+				return
+			}
+			to = current
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(snapshot.Diff(from.Visible(filter), to.Visible(filter)))
+	}))
+
+	// Endpoint for restoring the owned items from a configuration version
+	r.POST("/history/:id/rollback", checkAuthWithPerm(keyStore, "violet:history", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+		if err != nil {
+			apiError(rw, http.StatusNotFound, "Unknown version", nil)
+			return
+		}
+
+		result, err := history.Rollback(req.Context(), id, ownershipFilter(b), b.Subject)
+		var validationErr snapshot.ValidationError
+		switch {
+		case errors.Is(err, snapshot.ErrVersionNotFound):
+			apiError(rw, http.StatusNotFound, "Unknown version", nil)
+			return
+		case errors.As(err, &validationErr):
+			apiError(rw, http.StatusConflict, validationErr.Error(), nil)
+			return
+		case err != nil:
+			logger.Logger.Infof("Failed to roll back configuration: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to roll back configuration", err)
+			return
+		}
+		if len(result.Changes) > 0 {
+			compileTarget.Compile()
+			log.RecordChanges(req.Context(), b.Subject, result.Differences)
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(result)
+	}))
+}
+
+// getHistoryVersion reads the version with the id or responds with 404
+func getHistoryVersion(rw http.ResponseWriter, req *http.Request, history *snapshot.History, id string) (snapshot.Version, *snapshot.Snapshot, bool) {
+	n, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		apiError(rw, http.StatusNotFound, "Unknown version", nil)
+		return snapshot.Version{}, nil, false
+	}
+	version, s, err := history.Get(req.Context(), n)
+	if errors.Is(err, snapshot.ErrVersionNotFound) {
+		apiError(rw, http.StatusNotFound, "Unknown version", nil)
+		return snapshot.Version{}, nil, false
+	}
+	if err != nil {
+		logger.Logger.Infof("Failed to get version from database: %s\n", err)
+		apiError(rw, http.StatusInternalServerError, "Failed to get version from database", err)
+		return snapshot.Version{}, nil, false
+	}
+	return version, s, true
+}
+
+// parseQueryInt parses an optional integer query value between min and max, a
+// negative max disables the upper bound
+func parseQueryInt(rw http.ResponseWriter, v string, def, min, max int64, msg string) (int64, bool) {
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < min || (max >= 0 && n > max) {
+		apiError(rw, http.StatusBadRequest, msg, err)
+		return 0, false
+	}
+	return n, true
+}
+
+// recordHistory stores the configuration in the history after a change made
+// through the API. A failure is logged so the change isn't reported as failed.
+// The key is left out of the reason as the version can be listed by owners of
+// other changed domains.
+func recordHistory(req *http.Request, history *snapshot.History, b AuthClaims, action, t string) {
+	reason := action + " " + t
+	if _, err := history.Record(context.WithoutCancel(req.Context()), b.Subject, reason); err != nil {
+		logger.Logger.Warn("Failed to record configuration history", "reason", reason, "err", err)
+	}
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestSetupHistoryApis(t *testing.T) {
+	apiConf := newTestConf(t)
+	apiConf.History = snapshot.NewHistory(apiConf.DB, 0)
+	manager := apiConf.Router
+	assert.NoError(t, apiConf.DB.AddRoute(context.Background(), database.AddRouteParams{Source: "example.org", Destination: "127.0.0.1:8081", Active: true}))
+
+	compiled := &fake.Compilable{}
+	srv := NewApiServer(apiConf, utils.MultiCompilable{compiled}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:route", "violet:history", "violet:import", "domain:owns=example.com")}
+	versions := func() []snapshot.Version {
+		rec := api.do(http.MethodGet, "/history", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var v []snapshot.Version
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&v))
+		return v
+	}
+
+	// each change creates a version
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPost, "/route", `{"src":"example.com/a","dst":"127.0.0.1:8080","active":true}`).Code)
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/route", `{"src":"example.com/a"}`).Code)
+	v := versions()
+	if !assert.Len(t, v, 2) {
+		return
+	}
+	assert.Equal(t, "delete route", v[0].Reason)
+	assert.Equal(t, "abc", v[0].Actor)
+	assert.Equal(t, "create route", v[1].Reason)
+	created := strconv.FormatInt(v[1].ID, 10)
+
+	// only owned items are shown
+	rec := api.do(http.MethodGet, "/history/"+created, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var version struct {
+		snapshot.Version
+		Snapshot snapshot.Snapshot `json:"snapshot"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&version))
+	assert.Equal(t, v[1].ID, version.ID)
+	assert.Equal(t, []snapshot.Route{{Src: "example.com/a", Dst: "127.0.0.1:8080", Active: true}}, version.Snapshot.Routes)
+
+	rec = api.do(http.MethodGet, "/history/"+created+"/diff", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"type":"route","key":"example.com/a","action":"delete","before":{"src":"example.com/a","dst":"127.0.0.1:8080","active":true}}]`, rec.Body.String())
+	rec = api.do(http.MethodGet, "/history/"+created+"/diff?to="+created, "")
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	// rollback restores the route and compiles
+	rec = api.do(http.MethodPost, "/history/"+created+"/rollback", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result snapshot.Result
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, []snapshot.Change{{Type: "route", Key: "example.com/a", Action: snapshot.ActionCreate}}, result.Changes)
+	assert.True(t, compiled.Done)
+	route, _, err := manager.GetRoute("example.com/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8080", route.Dst)
+	_, _, err = manager.GetRoute("example.org")
+	assert.NoError(t, err)
+
+	v = versions()
+	if assert.Len(t, v, 3) {
+		assert.Equal(t, "rollback to version "+created, v[0].Reason)
+	}
+
+	// imports are recorded once
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPost, "/import", `{"routes":[{"src":"example.com/b","dst":"127.0.0.1:8080","active":true}]}`).Code)
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPost, "/import?dry_run=true", `{"routes":[{"src":"example.com/c","dst":"127.0.0.1:8080","active":true}]}`).Code)
+	v = versions()
+	if assert.Len(t, v, 4) {
+		assert.Equal(t, "import", v[0].Reason)
+		assert.Equal(t, "abc", v[0].Actor)
+	}
+
+	// versions which don't change the owned domains are hidden
+	api.token = fake.GenSnakeOilKey("violet:history", "domain:owns=example.net")
+	assert.Empty(t, versions())
+
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/history/1000", "").Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/history/abc/diff", "").Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodPost, "/history/1000/rollback", "").Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/history?limit=0", "").Code)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func SetupSnapshotApis(r *httprouter.Router, keyStore *mjwt.KeyStore, db *database.Queries, compileTarget utils.MultiCompilable, log *audit.Log, history *snapshot.History) {
+	// Endpoint for exporting the routing configuration
+	r.GET("/export", checkAuthWithPerm(keyStore, "violet:export", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		format, err := snapshot.ParseFormat(req.URL.Query().Get("format"))
+		if err != nil {
+			apiError(rw, http.StatusBadRequest, "Invalid format", err)
+			return
+		}
+
+		labels, ok := parseLabelSelector(rw, req.URL.Query())
+		if !ok {
+			return
+		}
+
+		s, err := snapshot.Export(req.Context(), db, ownershipFilter(b))
+		if err != nil {
+			logger.Logger.Infof("Failed to export from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to export from database", err)
+			return
+		}
+		if len(labels) > 0 {
+			s = s.Labelled(labels)
+		}
+		rw.Header().Set("Content-Type", format.ContentType())
+		rw.WriteHeader(http.StatusOK)
+		_ = s.Encode(rw, format)
+	}))
+
+	// Endpoint for importing the routing configuration
+	r.POST("/import", checkAuthWithPerm(keyStore, "violet:import", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		name := q.Get("format")
+		if name == "" && strings.Contains(req.Header.Get("Content-Type"), "yaml") {
+			name = "yaml"
+		}
+		format, err := snapshot.ParseFormat(name)
+		if err != nil {
+			apiError(rw, http.StatusBadRequest, "Invalid format", err)
+			return
+		}
+		var opts snapshot.ImportOptions
+		opts.DryRun, _ = strconv.ParseBool(q.Get("dry_run"))
+		opts.Prune, _ = strconv.ParseBool(q.Get("prune"))
+
+		s, err := snapshot.Decode(req.Body, format)
+		if err != nil {
+			apiError(rw, http.StatusBadRequest, "Invalid request body", err)
+			return
+		}
+
+		if !opts.DryRun {
+			opts.Record = history.Recorder(req.Context(), b.Subject, "import")
+		}
+
+		result, err := snapshot.Import(req.Context(), db, s, ownershipFilter(b), opts)
+		var validationErr snapshot.ValidationError
+		if errors.As(err, &validationErr) {
+			apiError(rw, http.StatusBadRequest, validationErr.Error(), nil)
+			return
+		}
+		if errors.Is(err, snapshot.ErrPartialPrune) {
+			apiError(rw, http.StatusBadRequest, "Partial snapshot can't be imported with pruning", err)
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to import into database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to import into database", err)
+			return
+		}
+		if !opts.DryRun && len(result.Changes) > 0 {
+			compileTarget.Compile()
+			log.RecordChanges(req.Context(), b.Subject, result.Differences)
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(result)
+	}))
+}
+
+// ownershipFilter only allows hosts on the domains owned by the token
+func ownershipFilter(b AuthClaims) snapshot.Filter {
+	return func(host string) bool {
+		return validateDomainOwnershipClaims(host, b.Claims.Perms)
+	}
+}
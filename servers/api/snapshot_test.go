@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestSetupSnapshotApis(t *testing.T) {
+	apiConf := newTestConf(t)
+	db := apiConf.DB
+	ctx := context.Background()
+	assert.NoError(t, db.AddDomain(ctx, database.AddDomainParams{Domain: "example.com", Active: true}))
+	assert.NoError(t, db.AddDomain(ctx, database.AddDomainParams{Domain: "example.org", Active: true}))
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.com/a", Destination: "127.0.0.1:8080", Active: true}))
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.org", Destination: "127.0.0.1:8081", Active: true}))
+
+	compiled := &fake.Compilable{}
+	srv := NewApiServer(apiConf, utils.MultiCompilable{compiled}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:export", "violet:import", "domain:owns=example.com")}
+
+	// only owned items are exported
+	rec := api.do(http.MethodGet, "/export", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	s, err := snapshot.Decode(rec.Body, snapshot.FormatJson)
+	assert.NoError(t, err)
+	assert.Equal(t, []snapshot.Domain{{Domain: "example.com", Active: true}}, s.Domains)
+	assert.Equal(t, []snapshot.Route{{Src: "example.com/a", Dst: "127.0.0.1:8080", Active: true}}, s.Routes)
+
+	rec = api.do(http.MethodGet, "/export?format=yaml", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/yaml", rec.Header().Get("Content-Type"))
+	assert.Contains(t, rec.Body.String(), "src: example.com/a\n")
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/export?format=xml", "").Code)
+
+	// dry run reports without changing anything
+	body := "routes:\n  - src: example.com/a\n    dst: 127.0.0.1:9090\n    active: true\n  - src: example.com/b\n    dst: 127.0.0.1:9091\n    active: true\n"
+	rec = api.do(http.MethodPost, "/import?dry_run=true", body, "Content-Type", "application/yaml")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var result snapshot.Result
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, snapshot.Result{DryRun: true, Changes: []snapshot.Change{
+		{Type: "route", Key: "example.com/a", Action: snapshot.ActionUpdate},
+		{Type: "route", Key: "example.com/b", Action: snapshot.ActionCreate},
+	}}, result)
+	assert.False(t, compiled.Done)
+	row, err := db.GetRoute(ctx, "example.com/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8080", row.Destination)
+
+	rec = api.do(http.MethodPost, "/import", body, "Content-Type", "application/yaml")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, compiled.Done)
+	row, err = db.GetRoute(ctx, "example.com/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9090", row.Destination)
+
+	// items on other domains are rejected
+	rec = api.do(http.MethodPost, "/import", `{"routes":[{"src":"example.org","dst":"127.0.0.1:9092","active":true}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "domain not allowed")
+	row, err = db.GetRoute(ctx, "example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:8081", row.Destination)
+
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPost, "/import", `{"routes":`).Code)
+
+	// pruning only removes owned items
+	rec = api.do(http.MethodPost, "/import?prune=true", `{"routes":[{"src":"example.com/b","dst":"127.0.0.1:9091","active":true}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, []snapshot.Change{{Type: "route", Key: "example.com/a", Action: snapshot.ActionDelete}}, result.Changes)
+	_, err = db.GetRoute(ctx, "example.org")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/tail"
+	"github.com/1f349/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"time"
+)
+
+// tailKeepAlive is the interval between comments sent to keep idle streams open
+const tailKeepAlive = 30 * time.Second
+
+func SetupTailApis(r *httprouter.Router, keyStore *mjwt.KeyStore, t *tail.Tail) {
+	if t == nil {
+		return
+	}
+
+	// Endpoint for streaming live requests as server-sent events
+	r.GET("/tail", checkAuthWithPerm(keyStore, "violet:tail", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		host := q.Get("host")
+		route := q.Get("route")
+		if host != "" && !validateDomainOwnershipClaims(host, b.Claims.Perms) {
+			apiError(rw, http.StatusForbidden, "Token cannot tail this domain", nil)
+			return
+		}
+		routeHost, _ := utils.SplitHostPath(route)
+		if route != "" && !validateDomainOwnershipClaims(utils.GetDomainWithoutPort(routeHost), b.Claims.Perms) {
+			apiError(rw, http.StatusForbidden, "Token cannot tail this route", nil)
+			return
+		}
+
+		events, cancel := t.Subscribe(func(e tail.Event) bool {
+			if host != "" && e.Host != host {
+				return false
+			}
+			if route != "" && e.Route != route {
+				return false
+			}
+			return validateDomainOwnershipClaims(e.Host, b.Claims.Perms)
+		}, 64)
+		defer cancel()
+
+		serveEventStream(rw, req, nil, events, func(e tail.Event) []byte {
+			raw, err := json.Marshal(e)
+			if err != nil {
+				return nil
+			}
+			return []byte("event: request\ndata: " + string(raw) + "\n\n")
+		})
+	}))
+}
+
+// serveEventStream writes the initial messages and then each message from the
+// channel as server-sent events until the client disconnects or the channel is
+// closed. Events formatted as nil are skipped.
+func serveEventStream[T any](rw http.ResponseWriter, req *http.Request, initial [][]byte, events <-chan T, format func(T) []byte) {
+	// the stream outlives the server write timeout
+	rc := http.NewResponseController(rw)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(http.StatusOK)
+	for _, msg := range initial {
+		if _, err := rw.Write(msg); err != nil {
+			return
+		}
+	}
+	_ = rc.Flush()
+
+	ticker := time.NewTicker(tailKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+			if _, err := rw.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			msg := format(e)
+			if msg == nil {
+				continue
+			}
+			if _, err := rw.Write(msg); err != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/1f349/violet/servers/conf"
+	"github.com/1f349/violet/tail"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSetupTailApis(t *testing.T) {
+	tl := tail.New()
+	apiConf := &conf.Conf{
+		Domains: &fake.Domains{},
+		Acme:    utils.NewAcmeChallenge(),
+		Signer:  fake.SnakeOilProv.KeyStore(),
+		Tail:    tl,
+	}
+	srv := httptest.NewServer(NewApiServer(apiConf, utils.MultiCompilable{}, "abc123").Handler)
+	defer srv.Close()
+
+	tailReq := func(query, token string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/tail"+query, nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		return res
+	}
+
+	// tailing requires ownership of the filtered domain
+	res := tailReq("?host=example.org", fake.GenSnakeOilKey("violet:tail", "domain:owns=example.com"))
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	_ = res.Body.Close()
+
+	res = tailReq("?route=example.org/api", fake.GenSnakeOilKey("violet:tail", "domain:owns=example.com"))
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+	_ = res.Body.Close()
+
+	// the route filter is checked using the host of the source
+	res = tailReq("?route=www.example.com/hello", fake.GenSnakeOilKey("violet:tail", "domain:owns=example.com"))
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	for i := 0; !tl.Active(); i++ {
+		if !assert.Less(t, i, 100) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// events for domains the token doesn't own are skipped
+	tl.Publish(tail.Event{Host: "example.org", Path: "/hidden", Status: http.StatusOK})
+	tl.Publish(tail.Event{Host: "www.example.com", Path: "/hello", Route: "www.example.com/hello", Status: http.StatusNotFound})
+
+	r := bufio.NewReader(res.Body)
+	line, err := r.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: request\n", line)
+	line, err = r.ReadString('\n')
+	assert.NoError(t, err)
+	data, ok := strings.CutPrefix(line, "data: ")
+	assert.True(t, ok)
+	var e tail.Event
+	assert.NoError(t, json.Unmarshal([]byte(data), &e))
+	assert.Equal(t, "www.example.com", e.Host)
+	assert.Equal(t, "/hello", e.Path)
+	assert.Equal(t, http.StatusNotFound, e.Status)
+
+	// closing the tail ends the stream
+	tl.Close()
+	_, _ = r.ReadString('\n')
+	_, err = r.ReadString('\n')
+	assert.Error(t, err)
+}
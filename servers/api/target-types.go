@@ -18,10 +18,69 @@ type redirectSource target.RedirectWithActive
 
 func (r redirectSource) GetSource() string { return r.Src }
 
+// routePatch contains the route source and the optional fields to update
+type routePatch struct {
+	Src            string         `json:"src"`
+	Dst            *string        `json:"dst"`
+	Desc           *string        `json:"desc"`
+	Flags          *target.Flags  `json:"flags"`
+	WebsocketLimit *int64         `json:"websocket_limit"`
+	Labels         *target.Labels `json:"labels"`
+	Active         *bool          `json:"active"`
+}
+
+func (r routePatch) GetSource() string { return r.Src }
+
+// apply updates the route with the fields set in the patch
+func (r routePatch) apply(route *target.RouteWithActive) {
+	setIfPresent(&route.Dst, r.Dst)
+	setIfPresent(&route.Desc, r.Desc)
+	setIfPresent(&route.Flags, r.Flags)
+	setIfPresent(&route.WebsocketLimit, r.WebsocketLimit)
+	setIfPresent(&route.Labels, r.Labels)
+	setIfPresent(&route.Active, r.Active)
+}
+
+// redirectPatch contains the redirect source and the optional fields to update
+type redirectPatch struct {
+	Src    string         `json:"src"`
+	Dst    *string        `json:"dst"`
+	Desc   *string        `json:"desc"`
+	Flags  *target.Flags  `json:"flags"`
+	Code   *int64         `json:"code"`
+	Labels *target.Labels `json:"labels"`
+	Active *bool          `json:"active"`
+}
+
+func (r redirectPatch) GetSource() string { return r.Src }
+
+// apply updates the redirect with the fields set in the patch
+func (r redirectPatch) apply(redirect *target.RedirectWithActive) {
+	setIfPresent(&redirect.Dst, r.Dst)
+	setIfPresent(&redirect.Desc, r.Desc)
+	setIfPresent(&redirect.Flags, r.Flags)
+	setIfPresent(&redirect.Code, r.Code)
+	setIfPresent(&redirect.Labels, r.Labels)
+	setIfPresent(&redirect.Active, r.Active)
+}
+
+func setIfPresent[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
 var (
 	_ sourceGetter = sourceJson{}
 	_ sourceGetter = routeSource{}
 	_ sourceGetter = redirectSource{}
+	_ sourceGetter = routePatch{}
+	_ sourceGetter = redirectPatch{}
 )
 
+// bulkResult contains the sources changed by a bulk operation
+type bulkResult struct {
+	Changed []string `json:"changed"`
+}
+
 type sourceGetter interface{ GetSource() string }
@@ -2,91 +2,649 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/audit"
 	"github.com/1f349/violet/logger"
 	"github.com/1f349/violet/router"
+	"github.com/1f349/violet/snapshot"
 	"github.com/1f349/violet/target"
 	"github.com/1f349/violet/utils"
 	"github.com/julienschmidt/httprouter"
 )
 
-func SetupTargetApis(r *httprouter.Router, keyStore *mjwt.KeyStore, manager *router.Manager) {
+// targetMaxLimit is the largest page of routes or redirects
+const targetMaxLimit = 1000
+
+func SetupTargetApis(r *httprouter.Router, keyStore *mjwt.KeyStore, manager *router.Manager, log *audit.Log, history *snapshot.History) {
 	// Endpoint for routes
 	r.GET("/route", checkAuthWithPerm(keyStore, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
-		domains := getDomainOwnershipClaims(b.Claims.Perms)
+		opts, ok := parseListOptions(rw, req, b)
+		if !ok {
+			return
+		}
 
-		routes, err := manager.GetAllRoutes(domains)
+		routes, next, err := manager.ListRoutes(opts)
 		if err != nil {
 			logger.Logger.Infof("Failed to get routes from database: %s\n", err)
 			apiError(rw, http.StatusInternalServerError, "Failed to get routes from database", err)
 			return
 		}
+		setNextCursor(rw, next)
 		rw.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(rw).Encode(routes)
 	}))
+	r.GET("/route/source", checkAuthWithPerm(keyStore, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		src := req.URL.Query().Get("src")
+		if !checkSourceOwnership(rw, src, b) {
+			return
+		}
+		respondRoute(rw, manager, src, http.StatusOK)
+	}))
 	r.POST("/route", parseJsonAndCheckOwnership[routeSource](keyStore, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t routeSource) {
+		version, conditional, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
 		route := target.RouteWithActive(t)
-		err := manager.InsertRoute(route)
+		if !validLabels(rw, route.Labels) {
+			return
+		}
+		before := routeState(manager, route.Src)
+		var err error
+		if conditional {
+			// only replace an existing route
+			err = manager.UpdateRoute(route, version)
+			if errors.Is(err, router.ErrTargetNotFound) {
+				err = router.ErrVersionConflict
+			}
+		} else {
+			err = manager.InsertRoute(route)
+		}
+		if targetStateError(rw, err, "route") {
+			return
+		}
 		if err != nil {
 			logger.Logger.Infof("Failed to insert route into database: %s\n", err)
 			apiError(rw, http.StatusInternalServerError, "Failed to insert route into database", err)
 			return
 		}
 		manager.Compile()
+		log.Record(req.Context(), b.Subject, createOrUpdate(before), "route", route.Src, before, routeState(manager, route.Src))
+		recordHistory(req, history, b, createOrUpdate(before), "route")
 
-		rw.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(rw).Encode(route)
+		respondRoute(rw, manager, route.Src, http.StatusOK)
+	}))
+	r.PATCH("/route", parseJsonAndCheckOwnership[routePatch](keyStore, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t routePatch) {
+		expected, _, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
+		route, version, err := manager.GetRoute(t.Src)
+		if targetStateError(rw, err, "route") {
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to get route from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get route from database", err)
+			return
+		}
+		if expected != 0 && expected != version {
+			targetStateError(rw, router.ErrVersionConflict, "route")
+			return
+		}
+
+		// the update fails if the route changes after it was read
+		before := route
+		t.apply(&route)
+		if !validLabels(rw, route.Labels) {
+			return
+		}
+		err = manager.UpdateRoute(route, version)
+		if targetStateError(rw, err, "route") {
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to update route in database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to update route in database", err)
+			return
+		}
+		manager.Compile()
+		log.Record(req.Context(), b.Subject, "update", "route", route.Src, before, routeState(manager, route.Src))
+		recordHistory(req, history, b, "update", "route")
+
+		respondRoute(rw, manager, route.Src, http.StatusOK)
 	}))
+	r.POST("/route/enable", routeActiveManage(keyStore, manager, log, history, true))
+	r.POST("/route/disable", routeActiveManage(keyStore, manager, log, history, false))
+	r.POST("/route/bulk/enable", bulkManage(keyStore, "route", manager, log, history, activeAction(true), routeBulkActive(manager, true)))
+	r.POST("/route/bulk/disable", bulkManage(keyStore, "route", manager, log, history, activeAction(false), routeBulkActive(manager, false)))
+	r.DELETE("/route/bulk", bulkManage(keyStore, "route", manager, log, history, "delete", routeBulkDelete(manager)))
 	r.DELETE("/route", parseJsonAndCheckOwnership[sourceJson](keyStore, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
-		err := manager.DeleteRoute(t.Src)
+		version, _, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
+		before := routeState(manager, t.Src)
+		err := manager.DeleteRoute(t.Src, version)
+		if targetStateError(rw, err, "route") {
+			return
+		}
 		if err != nil {
 			logger.Logger.Infof("Failed to delete route from database: %s\n", err)
 			apiError(rw, http.StatusInternalServerError, "Failed to delete route from database", err)
 			return
 		}
 		manager.Compile()
+		log.Record(req.Context(), b.Subject, "delete", "route", t.Src, before, nil)
+		recordHistory(req, history, b, "delete", "route")
+	}))
+
+	// Endpoint for explaining how a url is routed
+	r.GET("/explain", checkAuthWithPerm(keyStore, "violet:route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		u, ok := parseExplainUrl(req.URL.Query().Get("url"))
+		if !ok {
+			apiError(rw, http.StatusBadRequest, "Invalid url", nil)
+			return
+		}
+		if !validateDomainOwnershipClaims(u.Hostname(), b.Claims.Perms) {
+			apiError(rw, http.StatusForbidden, "Token cannot view the specified domain", nil)
+			return
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(manager.Explain(u.Host, u.Path, u.RawQuery))
 	}))
 
 	// Endpoint for redirects
 	r.GET("/redirect", checkAuthWithPerm(keyStore, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
-		domains := getDomainOwnershipClaims(b.Claims.Perms)
+		opts, ok := parseListOptions(rw, req, b)
+		if !ok {
+			return
+		}
 
-		redirects, err := manager.GetAllRedirects(domains)
+		redirects, next, err := manager.ListRedirects(opts)
 		if err != nil {
 			logger.Logger.Infof("Failed to get redirects from database: %s\n", err)
 			apiError(rw, http.StatusInternalServerError, "Failed to get redirects from database", err)
 			return
 		}
+		setNextCursor(rw, next)
 		rw.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(rw).Encode(redirects)
 	}))
+	r.GET("/redirect/source", checkAuthWithPerm(keyStore, "violet:redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		src := req.URL.Query().Get("src")
+		if !checkSourceOwnership(rw, src, b) {
+			return
+		}
+		respondRedirect(rw, manager, src, http.StatusOK)
+	}))
 	r.POST("/redirect", parseJsonAndCheckOwnership[redirectSource](keyStore, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t redirectSource) {
+		version, conditional, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
 		redirect := target.RedirectWithActive(t)
-		err := manager.InsertRedirect(redirect)
+		if !validLabels(rw, redirect.Labels) {
+			return
+		}
+		before := redirectState(manager, redirect.Src)
+		var err error
+		if conditional {
+			// only replace an existing redirect
+			err = manager.UpdateRedirect(redirect, version)
+			if errors.Is(err, router.ErrTargetNotFound) {
+				err = router.ErrVersionConflict
+			}
+		} else {
+			err = manager.InsertRedirect(redirect)
+		}
+		if targetStateError(rw, err, "redirect") {
+			return
+		}
 		if err != nil {
 			logger.Logger.Infof("Failed to insert redirect into database: %s\n", err)
 			apiError(rw, http.StatusInternalServerError, "Failed to insert redirect into database", err)
 			return
 		}
 		manager.Compile()
+		log.Record(req.Context(), b.Subject, createOrUpdate(before), "redirect", redirect.Src, before, redirectState(manager, redirect.Src))
+		recordHistory(req, history, b, createOrUpdate(before), "redirect")
 
-		rw.WriteHeader(http.StatusOK)
-		_ = json.NewEncoder(rw).Encode(redirect)
+		respondRedirect(rw, manager, redirect.Src, http.StatusOK)
 	}))
+	r.PATCH("/redirect", parseJsonAndCheckOwnership[redirectPatch](keyStore, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t redirectPatch) {
+		expected, _, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
+		redirect, version, err := manager.GetRedirect(t.Src)
+		if targetStateError(rw, err, "redirect") {
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to get redirect from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get redirect from database", err)
+			return
+		}
+		if expected != 0 && expected != version {
+			targetStateError(rw, router.ErrVersionConflict, "redirect")
+			return
+		}
+
+		// the update fails if the redirect changes after it was read
+		before := redirect
+		t.apply(&redirect)
+		if !validLabels(rw, redirect.Labels) {
+			return
+		}
+		err = manager.UpdateRedirect(redirect, version)
+		if targetStateError(rw, err, "redirect") {
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to update redirect in database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to update redirect in database", err)
+			return
+		}
+		manager.Compile()
+		log.Record(req.Context(), b.Subject, "update", "redirect", redirect.Src, before, redirectState(manager, redirect.Src))
+		recordHistory(req, history, b, "update", "redirect")
+
+		respondRedirect(rw, manager, redirect.Src, http.StatusOK)
+	}))
+	r.POST("/redirect/enable", redirectActiveManage(keyStore, manager, log, history, true))
+	r.POST("/redirect/disable", redirectActiveManage(keyStore, manager, log, history, false))
+	r.POST("/redirect/bulk/enable", bulkManage(keyStore, "redirect", manager, log, history, activeAction(true), redirectBulkActive(manager, true)))
+	r.POST("/redirect/bulk/disable", bulkManage(keyStore, "redirect", manager, log, history, activeAction(false), redirectBulkActive(manager, false)))
+	r.DELETE("/redirect/bulk", bulkManage(keyStore, "redirect", manager, log, history, "delete", redirectBulkDelete(manager)))
 	r.DELETE("/redirect", parseJsonAndCheckOwnership[sourceJson](keyStore, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
-		err := manager.DeleteRedirect(t.Src)
+		version, _, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
+		before := redirectState(manager, t.Src)
+		err := manager.DeleteRedirect(t.Src, version)
+		if targetStateError(rw, err, "redirect") {
+			return
+		}
 		if err != nil {
 			logger.Logger.Infof("Failed to delete redirect from database: %s\n", err)
 			apiError(rw, http.StatusInternalServerError, "Failed to delete redirect from database", err)
 			return
 		}
 		manager.Compile()
+		log.Record(req.Context(), b.Subject, "delete", "redirect", t.Src, before, nil)
+		recordHistory(req, history, b, "delete", "redirect")
 	}))
 }
 
+// parseListOptions parses the filters and page for listing the routes or
+// redirects on the owned domains
+func parseListOptions(rw http.ResponseWriter, req *http.Request, b AuthClaims) (router.ListOptions, bool) {
+	q := req.URL.Query()
+	opts := router.ListOptions{Search: q.Get("search")}
+
+	var ok bool
+	opts.Hosts, ok = parseDomainHosts(rw, q, b)
+	if !ok {
+		return opts, false
+	}
+	opts.Labels, ok = parseLabelSelector(rw, q)
+	if !ok {
+		return opts, false
+	}
+
+	if v := q.Get("active"); v != "" {
+		active, err := strconv.ParseBool(v)
+		if err != nil {
+			apiError(rw, http.StatusBadRequest, "Invalid active value", err)
+			return opts, false
+		}
+		opts.Active = &active
+	}
+
+	flags, ok := parseQueryInt(rw, q.Get("flags"), 0, 0, -1, "Invalid flags value")
+	if !ok {
+		return opts, false
+	}
+	opts.Flags = target.Flags(flags)
+
+	// every item is listed if the limit is missing
+	after, ok := parseQueryInt(rw, q.Get("cursor"), 0, 0, -1, "Invalid cursor value")
+	if !ok {
+		return opts, false
+	}
+	limit, ok := parseQueryInt(rw, q.Get("limit"), 0, 1, targetMaxLimit, "Invalid limit value")
+	if !ok {
+		return opts, false
+	}
+	opts.After, opts.Limit = after, int(limit)
+	return opts, true
+}
+
+// parseDomainHosts returns the domain in the query if the token owns it,
+// otherwise every owned domain
+func parseDomainHosts(rw http.ResponseWriter, q url.Values, b AuthClaims) ([]string, bool) {
+	domain := q.Get("domain")
+	if domain == "" {
+		return getDomainOwnershipClaims(b.Claims.Perms), true
+	}
+	if !validateDomainOwnershipClaims(domain, b.Claims.Perms) {
+		apiError(rw, http.StatusForbidden, "Token cannot view the specified domain", nil)
+		return nil, false
+	}
+	return []string{domain}, true
+}
+
+// parseLabelSelector merges every label selector in the query, each can be a
+// single "key=value" or a comma separated list of them
+func parseLabelSelector(rw http.ResponseWriter, q url.Values) (target.Labels, bool) {
+	labels := make(target.Labels)
+	for _, i := range q["label"] {
+		l, err := target.ParseSelector(i)
+		if err != nil {
+			apiError(rw, http.StatusBadRequest, "Invalid label selector", err)
+			return nil, false
+		}
+		for k, v := range l {
+			if a, found := labels[k]; found && a != v {
+				apiError(rw, http.StatusBadRequest, "Conflicting label selector", nil)
+				return nil, false
+			}
+			labels[k] = v
+		}
+	}
+	return labels, true
+}
+
+// validLabels responds with 400 if the labels are not allowed
+func validLabels(rw http.ResponseWriter, labels target.Labels) bool {
+	if err := labels.Validate(); err != nil {
+		apiError(rw, http.StatusBadRequest, "Invalid labels", err)
+		return false
+	}
+	return true
+}
+
+// setNextCursor outputs the cursor for the next page if there is one
+func setNextCursor(rw http.ResponseWriter, next int64) {
+	if next != 0 {
+		rw.Header().Set("X-Next-Cursor", strconv.FormatInt(next, 10))
+	}
+}
+
+// parseExplainUrl parses an absolute url or a host and path without a scheme
+func parseExplainUrl(a string) (*url.URL, bool) {
+	if !strings.Contains(a, "://") {
+		a = "https://" + a
+	}
+	u, err := url.Parse(a)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+	return u, true
+}
+
+// routeActiveManage enables or disables a route and responds with the stored
+// route
+func routeActiveManage(keyStore *mjwt.KeyStore, manager *router.Manager, log *audit.Log, history *snapshot.History, active bool) httprouter.Handle {
+	return parseJsonAndCheckOwnership[sourceJson](keyStore, "route", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
+		version, _, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
+		before := routeState(manager, t.Src)
+		err := manager.SetRouteActive(t.Src, active, version)
+		if targetStateError(rw, err, "route") {
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to update route in database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to update route in database", err)
+			return
+		}
+		manager.Compile()
+		log.Record(req.Context(), b.Subject, activeAction(active), "route", t.Src, before, routeState(manager, t.Src))
+		recordHistory(req, history, b, activeAction(active), "route")
+
+		respondRoute(rw, manager, t.Src, http.StatusOK)
+	})
+}
+
+// redirectActiveManage enables or disables a redirect and responds with the
+// stored redirect
+func redirectActiveManage(keyStore *mjwt.KeyStore, manager *router.Manager, log *audit.Log, history *snapshot.History, active bool) httprouter.Handle {
+	return parseJsonAndCheckOwnership[sourceJson](keyStore, "redirect", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t sourceJson) {
+		version, _, ok := ifMatchVersion(rw, req)
+		if !ok {
+			return
+		}
+
+		before := redirectState(manager, t.Src)
+		err := manager.SetRedirectActive(t.Src, active, version)
+		if targetStateError(rw, err, "redirect") {
+			return
+		}
+		if err != nil {
+			logger.Logger.Infof("Failed to update redirect in database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to update redirect in database", err)
+			return
+		}
+		manager.Compile()
+		log.Record(req.Context(), b.Subject, activeAction(active), "redirect", t.Src, before, redirectState(manager, t.Src))
+		recordHistory(req, history, b, activeAction(active), "redirect")
+
+		respondRedirect(rw, manager, t.Src, http.StatusOK)
+	})
+}
+
+// bulkChange makes a bulk change to the items on the hosts with all the
+// labels and returns the key and values before and after for each change
+type bulkChange func(hosts []string, labels target.Labels) ([]audit.Change, error)
+
+// bulkManage changes every route or redirect on the owned domains with all the
+// labels in the selector and responds with the changed sources. A selector is
+// required so a missing query can't change every item.
+func bulkManage(keyStore *mjwt.KeyStore, t string, manager *router.Manager, log *audit.Log, history *snapshot.History, action string, change bulkChange) httprouter.Handle {
+	return checkAuthWithPerm(keyStore, "violet:"+t, func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		labels, ok := parseLabelSelector(rw, q)
+		if !ok {
+			return
+		}
+		if len(labels) == 0 {
+			apiError(rw, http.StatusBadRequest, "Missing label selector", nil)
+			return
+		}
+		hosts, ok := parseDomainHosts(rw, q, b)
+		if !ok {
+			return
+		}
+
+		changes, err := change(hosts, labels)
+		if err != nil {
+			logger.Logger.Infof("Failed to update %ss in database: %s\n", t, err)
+			apiError(rw, http.StatusInternalServerError, "Failed to update "+t+"s in database", err)
+			return
+		}
+		changed := make([]string, 0, len(changes))
+		for i := range changes {
+			changes[i].Action, changes[i].Type = action, t
+			changed = append(changed, changes[i].Key)
+		}
+		slices.Sort(changed)
+
+		if len(changes) > 0 {
+			manager.Compile()
+			log.RecordBatch(req.Context(), b.Subject, changes)
+			recordHistory(req, history, b, "bulk "+action, t+"s")
+		}
+
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(bulkResult{Changed: changed})
+	})
+}
+
+// routeBulkActive enables or disables the routes
+func routeBulkActive(manager *router.Manager, active bool) bulkChange {
+	return func(hosts []string, labels target.Labels) ([]audit.Change, error) {
+		routes, err := manager.SetRoutesActiveByLabels(hosts, labels, active)
+		changes := make([]audit.Change, 0, len(routes))
+		for _, before := range routes {
+			after := before
+			after.Active = active
+			changes = append(changes, audit.Change{Key: before.Src, Before: before, After: after})
+		}
+		return changes, err
+	}
+}
+
+// routeBulkDelete removes the routes
+func routeBulkDelete(manager *router.Manager) bulkChange {
+	return func(hosts []string, labels target.Labels) ([]audit.Change, error) {
+		routes, err := manager.DeleteRoutesByLabels(hosts, labels)
+		changes := make([]audit.Change, 0, len(routes))
+		for _, before := range routes {
+			changes = append(changes, audit.Change{Key: before.Src, Before: before})
+		}
+		return changes, err
+	}
+}
+
+// redirectBulkActive enables or disables the redirects
+func redirectBulkActive(manager *router.Manager, active bool) bulkChange {
+	return func(hosts []string, labels target.Labels) ([]audit.Change, error) {
+		redirects, err := manager.SetRedirectsActiveByLabels(hosts, labels, active)
+		changes := make([]audit.Change, 0, len(redirects))
+		for _, before := range redirects {
+			after := before
+			after.Active = active
+			changes = append(changes, audit.Change{Key: before.Src, Before: before, After: after})
+		}
+		return changes, err
+	}
+}
+
+// redirectBulkDelete removes the redirects
+func redirectBulkDelete(manager *router.Manager) bulkChange {
+	return func(hosts []string, labels target.Labels) ([]audit.Change, error) {
+		redirects, err := manager.DeleteRedirectsByLabels(hosts, labels)
+		changes := make([]audit.Change, 0, len(redirects))
+		for _, before := range redirects {
+			changes = append(changes, audit.Change{Key: before.Src, Before: before})
+		}
+		return changes, err
+	}
+}
+
+// respondRoute outputs the stored route with the version as the ETag
+func respondRoute(rw http.ResponseWriter, manager *router.Manager, src string, code int) {
+	route, version, err := manager.GetRoute(src)
+	if targetStateError(rw, err, "route") {
+		return
+	}
+	if err != nil {
+		logger.Logger.Infof("Failed to get route from database: %s\n", err)
+		apiError(rw, http.StatusInternalServerError, "Failed to get route from database", err)
+		return
+	}
+	rw.Header().Set("ETag", versionETag(version))
+	rw.WriteHeader(code)
+	_ = json.NewEncoder(rw).Encode(route)
+}
+
+// respondRedirect outputs the stored redirect with the version as the ETag
+func respondRedirect(rw http.ResponseWriter, manager *router.Manager, src string, code int) {
+	redirect, version, err := manager.GetRedirect(src)
+	if targetStateError(rw, err, "redirect") {
+		return
+	}
+	if err != nil {
+		logger.Logger.Infof("Failed to get redirect from database: %s\n", err)
+		apiError(rw, http.StatusInternalServerError, "Failed to get redirect from database", err)
+		return
+	}
+	rw.Header().Set("ETag", versionETag(version))
+	rw.WriteHeader(code)
+	_ = json.NewEncoder(rw).Encode(redirect)
+}
+
+// routeState returns the stored route for the audit log or nil if it doesn't
+// exist
+func routeState(manager *router.Manager, src string) any {
+	route, _, err := manager.GetRoute(src)
+	if err != nil {
+		return nil
+	}
+	return route
+}
+
+// redirectState returns the stored redirect for the audit log or nil if it
+// doesn't exist
+func redirectState(manager *router.Manager, src string) any {
+	redirect, _, err := manager.GetRedirect(src)
+	if err != nil {
+		return nil
+	}
+	return redirect
+}
+
+// targetStateError responds with 404 if the route or redirect doesn't exist
+// and 412 if the version doesn't match
+func targetStateError(rw http.ResponseWriter, err error, t string) bool {
+	switch {
+	case errors.Is(err, router.ErrTargetNotFound):
+		apiError(rw, http.StatusNotFound, "Unknown "+t+" source", nil)
+		return true
+	case errors.Is(err, router.ErrVersionConflict):
+		apiError(rw, http.StatusPreconditionFailed, "The "+t+" has been modified", nil)
+		return true
+	}
+	return false
+}
+
+// versionETag formats the row version as a strong entity tag
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatchVersion parses the If-Match header into the expected row version,
+// zero matches any version. The conditional result is true if the header was
+// provided. An unknown entity tag can never match so responds with 412.
+func ifMatchVersion(rw http.ResponseWriter, req *http.Request) (version int64, conditional bool, ok bool) {
+	v := strings.TrimSpace(req.Header.Get("If-Match"))
+	if v == "" {
+		return 0, false, true
+	}
+	if v == "*" {
+		return 0, true, true
+	}
+	if unquoted, found := strings.CutPrefix(v, `"`); found {
+		if unquoted, found = strings.CutSuffix(unquoted, `"`); found {
+			if n, err := strconv.ParseInt(unquoted, 10, 64); err == nil && n > 0 {
+				return n, true, true
+			}
+		}
+	}
+	apiError(rw, http.StatusPreconditionFailed, "Invalid If-Match header", nil)
+	return 0, true, false
+}
+
 type AuthWithJsonCallback[T any] func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims, t T)
 
 func parseJsonAndCheckOwnership[T sourceGetter](keyStore *mjwt.KeyStore, t string, cb AuthWithJsonCallback[T]) httprouter.Handle {
@@ -97,18 +655,26 @@ func parseJsonAndCheckOwnership[T sourceGetter](keyStore *mjwt.KeyStore, t strin
 			return
 		}
 
-		// check token owns this domain
-		host, _ := utils.SplitHostPath(j.GetSource())
-		if strings.IndexByte(host, ':') != -1 {
-			apiError(rw, http.StatusBadRequest, "Invalid route source", nil)
-			return
-		}
-
-		if !validateDomainOwnershipClaims(host, b.Claims.Perms) {
-			apiError(rw, http.StatusBadRequest, "Token cannot modify the specified domain", nil)
+		if !checkSourceOwnership(rw, j.GetSource(), b) {
 			return
 		}
 
 		cb(rw, req, params, b, j)
 	})
 }
+
+// checkSourceOwnership validates the token owns the domain of the source
+func checkSourceOwnership(rw http.ResponseWriter, src string, b AuthClaims) bool {
+	// check token owns this domain
+	host, _ := utils.SplitHostPath(src)
+	if strings.IndexByte(host, ':') != -1 {
+		apiError(rw, http.StatusBadRequest, "Invalid route source", nil)
+		return false
+	}
+
+	if !validateDomainOwnershipClaims(host, b.Claims.Perms) {
+		apiError(rw, http.StatusBadRequest, "Token cannot modify the specified domain", nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/violet/router"
+	"github.com/1f349/violet/target"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSetupTargetApis_Patch(t *testing.T) {
+	apiConf := newTestConf(t)
+	manager := apiConf.Router
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.com/a", Dst: "127.0.0.1:8080", Desc: "first", Flags: target.FlagPre}, Active: true}))
+	assert.NoError(t, manager.InsertRedirect(target.RedirectWithActive{Redirect: target.Redirect{Src: "www.example.com", Dst: "example.com", Code: 308}, Active: true}))
+
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:route", "violet:redirect", "domain:owns=example.com")}
+
+	// only the provided fields are changed
+	rec := api.do(http.MethodPatch, "/route", `{"src":"example.com/a","desc":"updated"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var route target.RouteWithActive
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&route))
+	assert.Equal(t, target.RouteWithActive{Route: target.Route{Src: "example.com/a", Dst: "127.0.0.1:8080", Desc: "updated", Flags: target.FlagPre}, Active: true}, route)
+
+	rec = api.do(http.MethodPost, "/route/disable", `{"src":"example.com/a"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&route))
+	assert.False(t, route.Active)
+	assert.Equal(t, "updated", route.Desc)
+
+	rec = api.do(http.MethodPost, "/route/enable", `{"src":"example.com/a"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&route))
+	assert.True(t, route.Active)
+
+	rec = api.do(http.MethodPatch, "/redirect", `{"src":"www.example.com","code":301,"active":false}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var redirect target.RedirectWithActive
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&redirect))
+	assert.Equal(t, target.RedirectWithActive{Redirect: target.Redirect{Src: "www.example.com", Dst: "example.com", Code: 301}}, redirect)
+
+	rec = api.do(http.MethodPost, "/redirect/enable", `{"src":"www.example.com"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&redirect))
+	assert.True(t, redirect.Active)
+
+	// missing sources
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodPatch, "/route", `{"src":"example.com/missing","desc":"a"}`).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodPost, "/route/disable", `{"src":"example.com/missing"}`).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodDelete, "/route", `{"src":"example.com/missing"}`).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodPatch, "/redirect", `{"src":"example.com/missing"}`).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodPost, "/redirect/enable", `{"src":"example.com/missing"}`).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodDelete, "/redirect", `{"src":"example.com/missing"}`).Code)
+
+	// ownership is checked
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPatch, "/route", `{"src":"example.org/a","desc":"a"}`).Code)
+}
+
+func TestSetupTargetApis_IfMatch(t *testing.T) {
+	apiConf := newTestConf(t)
+	manager := apiConf.Router
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.com/a", Dst: "127.0.0.1:8080"}, Active: true}))
+	assert.NoError(t, manager.InsertRedirect(target.RedirectWithActive{Redirect: target.Redirect{Src: "www.example.com", Dst: "example.com", Code: 308}, Active: true}))
+
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:route", "violet:redirect", "domain:owns=example.com")}
+
+	rec := api.do(http.MethodGet, "/route/source?src=example.com/a", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+	var route target.RouteWithActive
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&route))
+	assert.Equal(t, "127.0.0.1:8080", route.Dst)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodGet, "/route/source?src=example.com/missing", "").Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/route/source?src=example.org/a", "").Code)
+
+	// the first editor wins
+	rec = api.do(http.MethodPatch, "/route", `{"src":"example.com/a","desc":"first"}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	rec = api.do(http.MethodPatch, "/route", `{"src":"example.com/a","desc":"second"}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	route, _, err := manager.GetRoute("example.com/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "first", route.Desc)
+
+	// conditional replace only updates an existing route
+	rec = api.do(http.MethodPost, "/route", `{"src":"example.com/a","dst":"127.0.0.1:8081"}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = api.do(http.MethodPost, "/route", `{"src":"example.com/b","dst":"127.0.0.1:8081"}`, "If-Match", `*`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = api.do(http.MethodPost, "/route", `{"src":"example.com/a","dst":"127.0.0.1:8081"}`, "If-Match", `"2"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+
+	// unknown entity tags never match
+	assert.Equal(t, http.StatusPreconditionFailed, api.do(http.MethodPost, "/route/disable", `{"src":"example.com/a"}`, "If-Match", `W/"3"`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, api.do(http.MethodDelete, "/route", `{"src":"example.com/a"}`, "If-Match", `"abc"`).Code)
+
+	rec = api.do(http.MethodPost, "/route/disable", `{"src":"example.com/a"}`, "If-Match", `"3"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"4"`, rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusPreconditionFailed, api.do(http.MethodDelete, "/route", `{"src":"example.com/a"}`, "If-Match", `"3"`).Code)
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/route", `{"src":"example.com/a"}`, "If-Match", `"4"`).Code)
+	assert.Equal(t, http.StatusNotFound, api.do(http.MethodDelete, "/route", `{"src":"example.com/a"}`, "If-Match", `"4"`).Code)
+
+	// redirects
+	rec = api.do(http.MethodGet, "/redirect/source?src=www.example.com", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+	rec = api.do(http.MethodPatch, "/redirect", `{"src":"www.example.com","code":301}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+	assert.Equal(t, http.StatusPreconditionFailed, api.do(http.MethodPatch, "/redirect", `{"src":"www.example.com","code":302}`, "If-Match", `"1"`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, api.do(http.MethodPost, "/redirect/enable", `{"src":"www.example.com"}`, "If-Match", `"1"`).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, api.do(http.MethodDelete, "/redirect", `{"src":"www.example.com"}`, "If-Match", `"1"`).Code)
+	assert.Equal(t, http.StatusOK, api.do(http.MethodDelete, "/redirect", `{"src":"www.example.com"}`, "If-Match", `"2"`).Code)
+}
+
+func TestSetupTargetApis_Explain(t *testing.T) {
+	apiConf := newTestConf(t)
+	manager := apiConf.Router
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.com", Dst: "127.0.0.1:8080", Flags: target.FlagPre}, Active: true}))
+	manager.Compile()
+	assert.Eventually(t, func() bool { return manager.Explain("example.com", "/", "").Match != nil }, time.Second, 10*time.Millisecond)
+
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:route", "domain:owns=example.com")}
+
+	get := func(u string) *httptest.ResponseRecorder {
+		return api.do(http.MethodGet, "/explain?url="+url.QueryEscape(u), "")
+	}
+
+	rec := get("example.com/a/b?c=d")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var e router.Explanation
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&e))
+	assert.Equal(t, "http://127.0.0.1:8080/a/b?c=d", e.Upstream)
+	assert.Len(t, e.Candidates, 1)
+
+	assert.Equal(t, http.StatusForbidden, get("https://example.org/").Code)
+	assert.Equal(t, http.StatusBadRequest, get("https://").Code)
+}
+
+func TestSetupTargetApis_List(t *testing.T) {
+	apiConf := newTestConf(t)
+	manager := apiConf.Router
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.com", Dst: "127.0.0.1:8080"}, Active: true}))
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "api.example.com", Dst: "127.0.0.1:9000", Flags: target.FlagCors}, Active: true}))
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.com/old", Dst: "127.0.0.1:8081"}, Active: false}))
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.org", Dst: "127.0.0.1:8082"}, Active: true}))
+	assert.NoError(t, manager.InsertRedirect(target.RedirectWithActive{Redirect: target.Redirect{Src: "www.example.com", Dst: "example.com", Code: 308}, Active: true}))
+
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:route", "violet:redirect", "domain:owns=example.com")}
+
+	list := func(path string) ([]string, string) {
+		rec := api.do(http.MethodGet, path, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var items []target.RouteWithActive
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&items))
+		src := make([]string, 0, len(items))
+		for _, i := range items {
+			src = append(src, i.Src)
+		}
+		return src, rec.Header().Get("X-Next-Cursor")
+	}
+	code := func(path string) int {
+		return api.do(http.MethodGet, path, "").Code
+	}
+
+	// every owned route is listed without a limit
+	src, next := list("/route")
+	assert.Equal(t, []string{"example.com", "api.example.com", "example.com/old"}, src)
+	assert.Empty(t, next)
+
+	src, _ = list("/route?domain=api.example.com")
+	assert.Equal(t, []string{"api.example.com"}, src)
+	src, _ = list("/route?search=old")
+	assert.Equal(t, []string{"example.com/old"}, src)
+	src, _ = list("/route?active=true&flags=" + strconv.FormatUint(uint64(target.FlagCors), 10))
+	assert.Equal(t, []string{"api.example.com"}, src)
+
+	src, next = list("/route?limit=2")
+	assert.Equal(t, []string{"example.com", "api.example.com"}, src)
+	assert.NotEmpty(t, next)
+	src, next = list("/route?limit=2&cursor=" + next)
+	assert.Equal(t, []string{"example.com/old"}, src)
+	assert.Empty(t, next)
+
+	src, _ = list("/redirect?search=www")
+	assert.Equal(t, []string{"www.example.com"}, src)
+
+	assert.Equal(t, http.StatusForbidden, code("/route?domain=example.org"))
+	assert.Equal(t, http.StatusBadRequest, code("/route?active=maybe"))
+	assert.Equal(t, http.StatusBadRequest, code("/redirect?limit=0"))
+	assert.Equal(t, http.StatusBadRequest, code("/redirect?cursor=abc"))
+}
+
+func TestSetupTargetApis_Labels(t *testing.T) {
+	apiConf := newTestConf(t)
+	manager := apiConf.Router
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.com/b", Dst: "127.0.0.1:8081", Labels: target.Labels{"team": "payments"}}, Active: true}))
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.com/c", Dst: "127.0.0.1:8082"}, Active: true}))
+	assert.NoError(t, manager.InsertRoute(target.RouteWithActive{Route: target.Route{Src: "example.org", Dst: "127.0.0.1:8083", Labels: target.Labels{"team": "payments"}}, Active: true}))
+	assert.NoError(t, manager.InsertRedirect(target.RedirectWithActive{Redirect: target.Redirect{Src: "www.example.com", Dst: "example.com", Code: 308, Labels: target.Labels{"team": "payments"}}, Active: true}))
+
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	api := testClient{srv, fake.GenSnakeOilKey("violet:route", "violet:redirect", "violet:export", "violet:import", "domain:owns=example.com")}
+
+	changed := func(rec *httptest.ResponseRecorder) []string {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var r bulkResult
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&r))
+		return r.Changed
+	}
+
+	// labels are stored and can be patched
+	rec := api.do(http.MethodPost, "/route", `{"src":"example.com/a","dst":"127.0.0.1:8080","labels":{"team":"payments","env":"staging"},"active":true}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"labels":{"env":"staging","team":"payments"}`)
+	rec = api.do(http.MethodPatch, "/route", `{"src":"example.com/c","labels":{"team":"search"}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"labels":{"team":"search"}`)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPost, "/route", `{"src":"example.com/d","dst":"127.0.0.1:8080","labels":{"team name":"a"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPatch, "/redirect", `{"src":"www.example.com","labels":{"team":"a=b"}}`).Code)
+
+	// list filters by every selector
+	var routes []target.RouteWithActive
+	assert.NoError(t, json.NewDecoder(api.do(http.MethodGet, "/route?label=team=payments", "").Body).Decode(&routes))
+	assert.Len(t, routes, 2)
+	routes = nil
+	assert.NoError(t, json.NewDecoder(api.do(http.MethodGet, "/route?label=team=payments&label=env=staging", "").Body).Decode(&routes))
+	assert.Len(t, routes, 1)
+	assert.Equal(t, "example.com/a", routes[0].Src)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/route?label=team", "").Code)
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodGet, "/route?label=team=a&label=team=b", "").Code)
+
+	// export only contains the labelled routes and redirects
+	rec = api.do(http.MethodGet, "/export?label=team=payments", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"example.com/a"`)
+	assert.Contains(t, rec.Body.String(), `"www.example.com"`)
+	assert.NotContains(t, rec.Body.String(), `"example.com/c"`)
+	assert.Contains(t, rec.Body.String(), `"partial": true`)
+
+	// the labelled export can't be imported with pruning
+	exported := rec.Body.String()
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPost, "/import?prune=true", exported).Code)
+	assert.Equal(t, http.StatusOK, api.do(http.MethodPost, "/import", exported).Code)
+
+	// bulk operations require a selector and only change owned domains
+	assert.Equal(t, http.StatusBadRequest, api.do(http.MethodPost, "/route/bulk/disable", "").Code)
+	assert.Equal(t, http.StatusForbidden, api.do(http.MethodPost, "/route/bulk/disable?label=team=payments&domain=example.org", "").Code)
+	assert.Equal(t, []string{"example.com/a", "example.com/b"}, changed(api.do(http.MethodPost, "/route/bulk/disable?label=team=payments", "")))
+	assert.Equal(t, []string{}, changed(api.do(http.MethodPost, "/route/bulk/disable?label=team=payments", "")))
+	route, _, err := manager.GetRoute("example.com/b")
+	assert.NoError(t, err)
+	assert.False(t, route.Active)
+	route, _, err = manager.GetRoute("example.org")
+	assert.NoError(t, err)
+	assert.True(t, route.Active)
+	assert.Equal(t, []string{"example.com/a", "example.com/b"}, changed(api.do(http.MethodPost, "/route/bulk/enable?label=team=payments&domain=example.com", "")))
+
+	assert.Equal(t, []string{"example.com/a"}, changed(api.do(http.MethodDelete, "/route/bulk?label=env=staging", "")))
+	_, _, err = manager.GetRoute("example.com/a")
+	assert.ErrorIs(t, err, router.ErrTargetNotFound)
+	assert.Equal(t, []string{"www.example.com"}, changed(api.do(http.MethodDelete, "/redirect/bulk?label=team=payments", "")))
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/webhook"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+const (
+	webhookDefaultLimit = 100
+	webhookMaxLimit     = 1000
+)
+
+func SetupWebhookApis(r *httprouter.Router, keyStore *mjwt.KeyStore, hooks *webhook.Dispatcher) {
+	if hooks == nil {
+		return
+	}
+
+	// Endpoint for listing the delivery attempts
+	r.GET("/webhook/deliveries", checkAuthWithPerm(keyStore, "violet:webhook", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		before, ok := parseQueryInt(rw, q.Get("before"), 0, 0, -1, "Invalid before value")
+		if !ok {
+			return
+		}
+		limit, ok := parseQueryInt(rw, q.Get("limit"), webhookDefaultLimit, 1, webhookMaxLimit, "Invalid limit value")
+		if !ok {
+			return
+		}
+
+		deliveries, err := hooks.Deliveries(req.Context(), before, int(limit))
+		if err != nil {
+			logger.Logger.Infof("Failed to get webhook deliveries from database: %s\n", err)
+			apiError(rw, http.StatusInternalServerError, "Failed to get webhook deliveries from database", err)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(deliveries)
+	}))
+
+	// Endpoint for sending a ping payload to every endpoint
+	r.POST("/webhook/ping", checkAuthWithPerm(keyStore, "violet:webhook", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		hooks.Send(webhook.EventPing, struct {
+			Actor string `json:"actor"`
+		}{b.Subject})
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+}
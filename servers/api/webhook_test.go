@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/1f349/violet/webhook"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetupWebhookApis(t *testing.T) {
+	apiConf := newTestConf(t)
+
+	received := make(chan string, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		received <- req.Header.Get("X-Violet-Event")
+	}))
+	defer receiver.Close()
+
+	hooks := webhook.New(apiConf.DB, webhook.Options{Endpoints: []webhook.Endpoint{{Url: receiver.URL, Secret: "secret"}}})
+	defer hooks.Close()
+	hooks.WatchAudit(apiConf.Audit)
+	apiConf.Webhooks = hooks
+	srv := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+	next := func() string {
+		select {
+		case e := <-received:
+			return e
+		case <-time.After(2 * time.Second):
+			return ""
+		}
+	}
+
+	route := testClient{srv, fake.GenSnakeOilKey("violet:route", "domain:owns=example.com")}
+	hook := testClient{srv, fake.GenSnakeOilKey("violet:webhook")}
+
+	// creating a route sends a webhook
+	assert.Equal(t, http.StatusOK, route.do(http.MethodPost, "/route", `{"src":"example.com/a","dst":"127.0.0.1:8080","active":true}`).Code)
+	assert.Equal(t, webhook.EventRouteCreated, next())
+
+	// the ping endpoint requires the webhook permission
+	assert.Equal(t, http.StatusForbidden, route.do(http.MethodPost, "/webhook/ping", "").Code)
+	assert.Equal(t, http.StatusAccepted, hook.do(http.MethodPost, "/webhook/ping", "").Code)
+	assert.Equal(t, webhook.EventPing, next())
+
+	// wait for the ping to be logged
+	var deliveries []webhook.Delivery
+	assert.Eventually(t, func() bool {
+		rec := hook.do(http.MethodGet, "/webhook/deliveries", "")
+		deliveries = nil
+		_ = json.NewDecoder(rec.Body).Decode(&deliveries)
+		return len(deliveries) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, webhook.EventPing, deliveries[0].Event)
+	assert.Equal(t, int64(http.StatusOK), deliveries[0].Status)
+	assert.Equal(t, webhook.EventRouteCreated, deliveries[1].Event)
+
+	assert.Equal(t, http.StatusForbidden, route.do(http.MethodGet, "/webhook/deliveries", "").Code)
+	assert.Equal(t, http.StatusBadRequest, hook.do(http.MethodGet, "/webhook/deliveries?limit=0", "").Code)
+}
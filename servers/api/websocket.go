@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/mjwt"
+	"github.com/1f349/mjwt/auth"
+	"github.com/1f349/violet/proxy/websocket"
+	"github.com/1f349/violet/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+)
+
+func SetupWebsocketApis(r *httprouter.Router, keyStore *mjwt.KeyStore, ws *websocket.Server) {
+	// Endpoint for listing websocket connections
+	r.GET("/websocket", checkAuthWithPerm(keyStore, "violet:websocket", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		match := websocketQueryMatcher(req)
+		conns := make([]websocket.ConnInfo, 0)
+		for _, i := range ws.Conns() {
+			if ownsWebsocketConn(i, b.Claims.Perms) && match(i) {
+				conns = append(conns, i)
+			}
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(conns)
+	}))
+
+	// Endpoints for closing websocket connections
+	r.DELETE("/websocket", checkAuthWithPerm(keyStore, "violet:websocket", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		q := req.URL.Query()
+		if q.Get("host") == "" && q.Get("route") == "" {
+			apiError(rw, http.StatusBadRequest, "Missing host or route filter", nil)
+			return
+		}
+		match := websocketQueryMatcher(req)
+		n := ws.CloseFunc(func(info websocket.ConnInfo) bool {
+			return ownsWebsocketConn(info, b.Claims.Perms) && match(info)
+		})
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(map[string]int{"closed": n})
+	}))
+	r.DELETE("/websocket/:id", checkAuthWithPerm(keyStore, "violet:websocket", func(rw http.ResponseWriter, req *http.Request, params httprouter.Params, b AuthClaims) {
+		id := params.ByName("id")
+		n := ws.CloseFunc(func(info websocket.ConnInfo) bool {
+			return info.ID == id && ownsWebsocketConn(info, b.Claims.Perms)
+		})
+		if n == 0 {
+			apiError(rw, http.StatusNotFound, "Unknown websocket connection", nil)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(rw).Encode(map[string]int{"closed": n})
+	}))
+}
+
+// websocketQueryMatcher returns a function matching connections against the
+// optional host and route query parameters
+func websocketQueryMatcher(req *http.Request) func(info websocket.ConnInfo) bool {
+	q := req.URL.Query()
+	host := q.Get("host")
+	route := q.Get("route")
+	return func(info websocket.ConnInfo) bool {
+		if host != "" && utils.GetDomainWithoutPort(info.Host) != host {
+			return false
+		}
+		return route == "" || info.Route == route
+	}
+}
+
+// ownsWebsocketConn returns true if the claims own the domain of the
+// connection host
+func ownsWebsocketConn(info websocket.ConnInfo, perms *auth.PermStorage) bool {
+	return validateDomainOwnershipClaims(utils.GetDomainWithoutPort(info.Host), perms)
+}
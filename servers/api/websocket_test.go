@@ -0,0 +1,87 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/1f349/violet/proxy/websocket"
+	"github.com/1f349/violet/servers/conf"
+	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/utils/fake"
+	websocket2 "github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestSetupWebsocketApis(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c, err := (&websocket2.Upgrader{}).Upgrade(rw, req, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		_, _, _ = c.ReadMessage()
+	}))
+	defer internal.Close()
+	internalUrl, err := url.Parse(internal.URL)
+	assert.NoError(t, err)
+
+	ws := websocket.NewServer()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req.URL.Host = internalUrl.Host
+		ws.Upgrade(rw, req, websocket.Details{Route: "example.com/ws", Host: "example.com"})
+	}))
+	defer srv.Close()
+
+	c, _, err := websocket2.DefaultDialer.Dial(strings.Replace(srv.URL, "http://", "ws://", 1), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	apiConf := &conf.Conf{
+		Domains:   &fake.Domains{},
+		Acme:      utils.NewAcmeChallenge(),
+		Signer:    fake.SnakeOilProv.KeyStore(),
+		Websocket: ws,
+	}
+	api := NewApiServer(apiConf, utils.MultiCompilable{}, "abc123")
+
+	listConns := func(token string) []websocket.ConnInfo {
+		req, err := http.NewRequest(http.MethodGet, "https://example.com/websocket", nil)
+		assert.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		api.Handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var conns []websocket.ConnInfo
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&conns))
+		return conns
+	}
+
+	// the connection is not visible without owning the domain
+	assert.Empty(t, listConns(fake.GenSnakeOilKey("violet:websocket", "domain:owns=example.org")))
+
+	ownerKey := fake.GenSnakeOilKey("violet:websocket", "domain:owns=example.com")
+	conns := listConns(ownerKey)
+	if !assert.Len(t, conns, 1) {
+		return
+	}
+	assert.Equal(t, "example.com/ws", conns[0].Route)
+
+	// close the connection by id
+	req, err := http.NewRequest(http.MethodDelete, "https://example.com/websocket/"+conns[0].ID, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+ownerKey)
+	rec := httptest.NewRecorder()
+	api.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, ws.Conns())
+
+	// the connection is already closed
+	rec = httptest.NewRecorder()
+	api.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
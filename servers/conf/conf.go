@@ -2,11 +2,19 @@ package conf
 
 import (
 	"github.com/1f349/mjwt"
+	"github.com/1f349/violet/accesslog"
+	"github.com/1f349/violet/audit"
 	"github.com/1f349/violet/database"
 	errorPages "github.com/1f349/violet/error-pages"
+	"github.com/1f349/violet/events"
 	"github.com/1f349/violet/favicons"
+	"github.com/1f349/violet/proxy/websocket"
 	"github.com/1f349/violet/router"
+	"github.com/1f349/violet/snapshot"
+	"github.com/1f349/violet/tail"
 	"github.com/1f349/violet/utils"
+	"github.com/1f349/violet/webhook"
+	"net/netip"
 )
 
 // Conf stores the shared configuration for the API, HTTP and HTTPS servers.
@@ -20,4 +28,18 @@ type Conf struct {
 	Signer     *mjwt.KeyStore
 	ErrorPages *errorPages.ErrorPages
 	Router     *router.Manager
+	Websocket  *websocket.Server
+	Ready      *utils.Readiness
+	AccessLog  *accesslog.AccessLog
+	Tail       *tail.Tail
+	Audit      *audit.Log
+	History    *snapshot.History
+	Events     *events.Stream
+	Webhooks   *webhook.Dispatcher
+
+	// TrustRequestId lists the client networks allowed to set X-Request-Id
+	TrustRequestId []netip.Prefix
+
+	// TrustTiming lists the client networks which receive upstream timings
+	TrustTiming []netip.Prefix
 }
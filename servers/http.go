@@ -59,7 +59,7 @@ func NewHttpServer(httpsPort uint16, conf *conf.Conf) *http.Server {
 
 	// Create and run http server
 	return &http.Server{
-		Handler:           r,
+		Handler:           setupRequestId(conf.TrustRequestId, setupAccessLog(conf.AccessLog, r)),
 		ReadTimeout:       time.Minute,
 		ReadHeaderTimeout: time.Minute,
 		WriteTimeout:      time.Minute,
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/1f349/violet/favicons"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
 	"github.com/1f349/violet/servers/conf"
 	"github.com/1f349/violet/utils"
 	"github.com/sethvargo/go-limiter/httplimit"
@@ -23,14 +24,14 @@ func NewHttpsServer(conf *conf.Conf) *http.Server {
 		conf.Router.ServeHTTP(rw, req)
 	})
 	favMiddleware := setupFaviconMiddleware(conf.Favicons, r)
-	rateLimiter := setupRateLimiter(conf.RateLimit, favMiddleware)
+	rateLimiter := setupRateLimiter(conf.RateLimit, conf.Webhooks.RateLimited, favMiddleware)
 	hsts := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
 		rateLimiter.ServeHTTP(rw, req)
 	})
 
 	return &http.Server{
-		Handler: hsts,
+		Handler: setupRequestId(conf.TrustRequestId, setupTrustTiming(conf.TrustTiming, setupTracing(setupAccessLog(conf.AccessLog, setupTail(conf.Tail, setupMetrics(hsts)))))),
 		TLSConfig: &tls.Config{
 			// Suggested by https://ssl-config.mozilla.org/#server=go&version=1.21.5&config=intermediate
 			MinVersion: tls.VersionTLS12,
@@ -67,8 +68,8 @@ func NewHttpsServer(conf *conf.Conf) *http.Server {
 }
 
 // setupRateLimiter is an internal function to create a middleware to manage
-// rate limits.
-func setupRateLimiter(rateLimit uint64, next http.Handler) http.Handler {
+// rate limits. The rejected function is called for each rate limited request.
+func setupRateLimiter(rateLimit uint64, rejected func(), next http.Handler) http.Handler {
 	// create memory store
 	store, err := memorystore.New(&memorystore.Config{
 		Tokens:   rateLimit,
@@ -83,7 +84,18 @@ func setupRateLimiter(rateLimit uint64, next http.Handler) http.Handler {
 	if err != nil {
 		logger.Logger.Fatal("Failed to initialize httplimit middleware", "err", err)
 	}
-	return middleware.Handle(next)
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		// the next handler is only called when the request is allowed
+		allowed := false
+		middleware.Handle(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			allowed = true
+			next.ServeHTTP(rw, req)
+		})).ServeHTTP(rw, req)
+		if !allowed {
+			metrics.RateLimitRejections.Inc()
+			rejected()
+		}
+	})
 }
 
 func setupFaviconMiddleware(fav *favicons.Favicons, next http.Handler) http.Handler {
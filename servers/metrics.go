@@ -0,0 +1,39 @@
+package servers
+
+import (
+	"github.com/1f349/violet/metrics"
+	"github.com/1f349/violet/utils"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setupMetrics is an internal function to create a middleware which records
+// the request metrics.
+func setupMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req, info := utils.WithRequestInfo(req)
+		rec := newResponseRecorder(rw)
+		var body *countReader
+		if req.Body != nil && req.Body != http.NoBody {
+			body = &countReader{ReadCloser: req.Body}
+			req.Body = body
+		}
+
+		next.ServeHTTP(rec, req)
+
+		// the host is only recorded for matched routes, this prevents unknown
+		// hosts from creating new series
+		var host string
+		if info.Route != "" {
+			host = utils.GetDomainWithoutPort(req.Host)
+		}
+		labels := []string{host, info.Route, strconv.Itoa(rec.Status())}
+		metrics.Requests.WithLabelValues(labels...).Inc()
+		metrics.RequestDuration.WithLabelValues(labels...).Observe(time.Since(info.Start).Seconds())
+		metrics.ResponseBytes.WithLabelValues(labels...).Add(float64(rec.written))
+		if body != nil {
+			metrics.RequestBytes.WithLabelValues(labels...).Add(float64(body.n))
+		}
+	})
+}
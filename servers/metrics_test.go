@@ -0,0 +1,47 @@
+package servers
+
+import (
+	"github.com/1f349/violet/metrics"
+	"github.com/1f349/violet/utils"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetupMetrics(t *testing.T) {
+	h := setupMetrics(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		utils.GetRequestInfo(req).Route = "example.com/metrics"
+		_, _ = io.Copy(io.Discard, req.Body)
+		rw.WriteHeader(http.StatusCreated)
+		_, _ = rw.Write([]byte("hello"))
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "https://example.com:8443/test", strings.NewReader("abc"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+
+	labels := []string{"example.com", "example.com/metrics", "201"}
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Requests.WithLabelValues(labels...)))
+	assert.Equal(t, 3.0, testutil.ToFloat64(metrics.RequestBytes.WithLabelValues(labels...)))
+	assert.Equal(t, 5.0, testutil.ToFloat64(metrics.ResponseBytes.WithLabelValues(labels...)))
+}
+
+func TestSetupMetrics_Unmatched(t *testing.T) {
+	h := setupMetrics(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		utils.RespondVioletError(rw, http.StatusTeapot, "No route")
+	}))
+
+	unmatched := metrics.Requests.WithLabelValues("", "", "418")
+	before := testutil.ToFloat64(unmatched)
+	req := httptest.NewRequest(http.MethodGet, "https://unmatched.example.com/test", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// unmatched hosts are not recorded
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.Requests.WithLabelValues("unmatched.example.com", "", "418")))
+	assert.Equal(t, before+1, testutil.ToFloat64(unmatched))
+}
@@ -0,0 +1,85 @@
+package servers
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// responseRecorder wraps a http.ResponseWriter to capture the status code and
+// the number of bytes written, hijacked connections are recorded as switching
+// protocols.
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	written  int64
+	hijacked bool
+}
+
+// newResponseRecorder returns the existing recorder if an outer middleware has
+// already wrapped the http.ResponseWriter.
+func newResponseRecorder(rw http.ResponseWriter) *responseRecorder {
+	if rec, ok := rw.(*responseRecorder); ok {
+		return rec
+	}
+	return &responseRecorder{ResponseWriter: rw}
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.written += int64(n)
+	return n, err
+}
+
+// Status returns the status code sent to the client.
+func (r *responseRecorder) Status() int {
+	switch {
+	case r.status != 0:
+		return r.status
+	case r.hijacked:
+		return http.StatusSwitchingProtocols
+	default:
+		return http.StatusOK
+	}
+}
+
+func (r *responseRecorder) Flush() {
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack is implemented directly as the websocket upgrader doesn't use
+// http.ResponseController.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return c, brw, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// countReader counts the bytes read from the request body.
+type countReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
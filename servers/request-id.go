@@ -0,0 +1,62 @@
+package servers
+
+import (
+	"github.com/1f349/violet/utils"
+	"github.com/google/uuid"
+	"net/http"
+	"net/netip"
+)
+
+// maxRequestIdLength is the longest incoming request ID which is accepted
+const maxRequestIdLength = 128
+
+// setupRequestId is an internal function to create a middleware which accepts
+// the X-Request-Id header from trusted clients or generates a new ID. The ID
+// is forwarded to the upstream and returned to the client.
+func setupRequestId(trusted []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req, info := utils.WithRequestInfo(req)
+
+		id := req.Header.Get("X-Request-Id")
+		if id == "" || !validRequestId(id) || !trustedRemote(trusted, req.RemoteAddr) {
+			id = uuid.NewString()
+		}
+		info.RequestId = id
+		req.Header.Set("X-Request-Id", id)
+		rw.Header().Set("X-Request-Id", id)
+
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// validRequestId checks the ID is a reasonable length and only contains
+// printable ASCII characters without spaces
+func validRequestId(id string) bool {
+	if len(id) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// trustedRemote checks if the remote address is within the trusted prefixes
+func trustedRemote(trusted []netip.Prefix, remote string) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+	addrPort, err := netip.ParseAddrPort(remote)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package servers
+
+import (
+	"github.com/1f349/violet/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestSetupRequestId(t *testing.T) {
+	var upstreamId, infoId string
+	h := setupRequestId([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		upstreamId = req.Header.Get("X-Request-Id")
+		infoId = utils.GetRequestInfo(req).RequestId
+	}))
+
+	for _, i := range []struct {
+		remote string
+		id     string
+		keep   bool
+	}{
+		{"10.0.0.1:1447", "abc123", true},
+		{"[::ffff:10.0.0.1]:1447", "abc123", true},
+		{"10.0.0.1:1447", "", false},
+		{"10.0.0.1:1447", "bad id", false},
+		{"10.0.0.1:1447", strings.Repeat("a", 129), false},
+		{"127.0.0.1:1447", "abc123", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+		req.RemoteAddr = i.remote
+		if i.id != "" {
+			req.Header.Set("X-Request-Id", i.id)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		id := rec.Header().Get("X-Request-Id")
+		if i.keep {
+			assert.Equal(t, i.id, id)
+		} else {
+			assert.NotEqual(t, i.id, id)
+			assert.Len(t, id, 36)
+		}
+		assert.Equal(t, id, upstreamId)
+		assert.Equal(t, id, infoId)
+	}
+}
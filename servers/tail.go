@@ -0,0 +1,37 @@
+package servers
+
+import (
+	"github.com/1f349/violet/tail"
+	"github.com/1f349/violet/utils"
+	"net/http"
+	"time"
+)
+
+// setupTail is an internal function to create a middleware which publishes
+// each request to the live tail subscribers.
+func setupTail(t *tail.Tail, next http.Handler) http.Handler {
+	if t == nil {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req, info := utils.WithRequestInfo(req)
+		rec := newResponseRecorder(rw)
+
+		next.ServeHTTP(rec, req)
+
+		if !t.Active() {
+			return
+		}
+		t.Publish(tail.Event{
+			Time:      info.Start,
+			RequestId: info.RequestId,
+			Host:      utils.GetDomainWithoutPort(req.Host),
+			Method:    req.Method,
+			Path:      req.URL.Path,
+			Route:     info.Route,
+			Upstream:  info.Upstream,
+			Status:    rec.Status(),
+			Latency:   time.Since(info.Start).Seconds(),
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package servers
+
+import (
+	"github.com/1f349/violet/tail"
+	"github.com/1f349/violet/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetupTail(t *testing.T) {
+	tl := tail.New()
+	c, cancel := tl.Subscribe(nil, 1)
+	defer cancel()
+
+	h := setupTail(tl, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		info := utils.GetRequestInfo(req)
+		info.Route = "example.com/tail"
+		info.Upstream = "http://127.0.0.1:8080/hello"
+		rw.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com:8443/tail/hello", nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	e := <-c
+	assert.Equal(t, "example.com", e.Host)
+	assert.Equal(t, http.MethodGet, e.Method)
+	assert.Equal(t, "/tail/hello", e.Path)
+	assert.Equal(t, "example.com/tail", e.Route)
+	assert.Equal(t, "http://127.0.0.1:8080/hello", e.Upstream)
+	assert.Equal(t, http.StatusTeapot, e.Status)
+	assert.GreaterOrEqual(t, e.Latency, 0.0)
+}
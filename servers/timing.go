@@ -0,0 +1,20 @@
+package servers
+
+import (
+	"github.com/1f349/violet/utils"
+	"net/http"
+	"net/netip"
+)
+
+// setupTrustTiming is an internal function to create a middleware which marks
+// requests from trusted clients as allowed to receive upstream timings.
+func setupTrustTiming(trusted []netip.Prefix, next http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		req, info := utils.WithRequestInfo(req)
+		info.TrustTiming = trustedRemote(trusted, req.RemoteAddr)
+		next.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,26 @@
+package servers
+
+import (
+	"github.com/1f349/violet/utils"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestSetupTrustTiming(t *testing.T) {
+	var trusted bool
+	h := setupTrustTiming([]netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}, http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		trusted = utils.GetRequestInfo(req).TrustTiming
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.com", nil)
+	req.RemoteAddr = "10.0.0.1:1447"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, trusted)
+
+	req.RemoteAddr = "127.0.0.1:1447"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.False(t, trusted)
+}
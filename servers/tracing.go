@@ -0,0 +1,43 @@
+package servers
+
+import (
+	"github.com/1f349/violet/tracing"
+	"github.com/1f349/violet/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// setupTracing is an internal function to create a middleware which starts a
+// span for each request, the span is a child of the incoming traceparent.
+func setupTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		ctx := tracing.Propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := tracing.Tracer().Start(ctx, req.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(utils.GetDomainWithoutPort(req.Host)),
+			semconv.URLPath(req.URL.Path),
+		))
+		defer span.End()
+
+		req, info := utils.WithRequestInfo(req.WithContext(ctx))
+		rec := newResponseRecorder(rw)
+		next.ServeHTTP(rec, req)
+
+		status := rec.Status()
+		span.SetAttributes(
+			semconv.HTTPResponseStatusCode(status),
+			attribute.String("violet.request_id", info.RequestId),
+		)
+		if info.Route != "" {
+			span.SetName(req.Method + " " + info.Route)
+			span.SetAttributes(attribute.String("violet.route.src", info.Route))
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
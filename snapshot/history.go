@@ -0,0 +1,257 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/favicons"
+	"github.com/1f349/violet/utils"
+	"slices"
+	"time"
+)
+
+var ErrVersionNotFound = errors.New("configuration version not found")
+
+// Version describes a stored snapshot of the routing configuration
+type Version struct {
+	ID     int64     `json:"id"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Reason string    `json:"reason"`
+}
+
+// DefaultHistoryLimit is the number of versions kept if no limit is set
+const DefaultHistoryLimit = 1000
+
+// History stores a snapshot of the routing configuration after each change.
+// A nil History records nothing.
+type History struct {
+	db    *database.Queries
+	limit int64
+}
+
+// NewHistory creates a configuration history backed by the database which
+// keeps the newest limit versions, DefaultHistoryLimit is used if limit is not
+// positive
+func NewHistory(db *database.Queries, limit int) *History {
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	return &History{db: db, limit: int64(limit)}
+}
+
+// Record stores a snapshot of the current configuration if it is different
+// from the latest version. The id of the latest version is returned.
+func (h *History) Record(ctx context.Context, actor, reason string) (int64, error) {
+	if h == nil {
+		return 0, nil
+	}
+	var id int64
+	err := h.db.Transaction(ctx, func(tx *database.Queries) (err error) {
+		id, err = h.record(ctx, tx, actor, reason)
+		return err
+	})
+	return id, err
+}
+
+// Recorder returns a function which records the configuration using the
+// transaction, this is used for ImportOptions.Record. A nil History returns nil.
+func (h *History) Recorder(ctx context.Context, actor, reason string) func(tx *database.Queries) error {
+	if h == nil {
+		return nil
+	}
+	return func(tx *database.Queries) error {
+		_, err := h.record(ctx, tx, actor, reason)
+		return err
+	}
+}
+
+// record stores the version using the transaction and removes the versions
+// past the limit
+func (h *History) record(ctx context.Context, tx *database.Queries, actor, reason string) (int64, error) {
+	s, err := Export(ctx, tx, nil)
+	if err != nil {
+		return 0, err
+	}
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return 0, err
+	}
+
+	previous := &Snapshot{}
+	latest, err := tx.GetLatestConfigVersion(ctx)
+	switch {
+	case err == nil && latest.Snapshot == string(raw):
+		return latest.ID, nil
+	case err == nil:
+		if err := json.Unmarshal([]byte(latest.Snapshot), previous); err != nil {
+			return 0, err
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, err
+	}
+
+	// the changed domains decide which tokens can list the version
+	changes, _ := Plan(previous, s, true)
+	domains, err := json.Marshal(changedDomains(changes))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := tx.AddConfigVersion(ctx, database.AddConfigVersionParams{
+		Time:     time.Now().UTC(),
+		Actor:    actor,
+		Reason:   reason,
+		Snapshot: string(raw),
+		Domains:  string(domains),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.PruneConfigVersions(ctx, h.limit)
+}
+
+// changedDomains returns the sorted top level domains of the changed items.
+// Hosts without a public suffix such as localhost are used as they are.
+func changedDomains(changes []Change) []string {
+	domains := make([]string, 0, len(changes))
+	for _, c := range changes {
+		host := sourceHost(c.Key)
+		if fqdn, ok := utils.GetTopFqdn(host); ok {
+			host = fqdn
+		}
+		domains = append(domains, host)
+	}
+	slices.Sort(domains)
+	return slices.Compact(domains)
+}
+
+// List returns up to limit versions changing the domains newest first. Only
+// versions older than beforeId are returned if beforeId is not zero.
+func (h *History) List(ctx context.Context, domains []string, beforeId int64, limit int) ([]Version, error) {
+	if len(domains) == 0 {
+		return []Version{}, nil
+	}
+	rows, err := h.db.GetConfigVersions(ctx, database.GetConfigVersionsParams{Owned: domains, BeforeID: beforeId, Limit: int64(limit)})
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Version, 0, len(rows))
+	for _, row := range rows {
+		versions = append(versions, Version{ID: row.ID, Time: row.Time, Actor: row.Actor, Reason: row.Reason})
+	}
+	return versions, nil
+}
+
+// Get returns the version and the stored snapshot
+func (h *History) Get(ctx context.Context, id int64) (Version, *Snapshot, error) {
+	row, err := h.db.GetConfigVersion(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Version{}, nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return Version{}, nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal([]byte(row.Snapshot), &s); err != nil {
+		return Version{}, nil, err
+	}
+	return Version{ID: row.ID, Time: row.Time, Actor: row.Actor, Reason: row.Reason}, &s, nil
+}
+
+// Current exports the items visible to the filter from the current
+// configuration
+func (h *History) Current(ctx context.Context, filter Filter) (*Snapshot, error) {
+	return Export(ctx, h.db, filter)
+}
+
+// Rollback restores the items visible to the filter from the version in a
+// single transaction. Visible items created after the version are deleted.
+// The restored configuration is recorded as a new version in the same
+// transaction.
+func (h *History) Rollback(ctx context.Context, id int64, filter Filter, actor string) (*Result, error) {
+	_, s, err := h.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return Import(ctx, h.db, s.Visible(filter), filter, ImportOptions{
+		Prune:  true,
+		Record: h.Recorder(ctx, actor, fmt.Sprintf("rollback to version %d", id)),
+	})
+}
+
+// Visible returns a copy of the snapshot only containing the items visible to
+// the filter
+func (s *Snapshot) Visible(filter Filter) *Snapshot {
+	out := &Snapshot{
+		Domains:   []Domain{},
+		Routes:    []Route{},
+		Redirects: []Redirect{},
+		Favicons:  []favicons.FaviconUrls{},
+	}
+	for _, i := range s.Domains {
+		if filter.allows(i.Domain) {
+			out.Domains = append(out.Domains, i)
+		}
+	}
+	for _, i := range s.Routes {
+		if filter.allows(sourceHost(i.Src)) {
+			out.Routes = append(out.Routes, i)
+		}
+	}
+	for _, i := range s.Redirects {
+		if filter.allows(sourceHost(i.Src)) {
+			out.Redirects = append(out.Redirects, i)
+		}
+	}
+	for _, i := range s.Favicons {
+		if filter.allows(i.Host) {
+			out.Favicons = append(out.Favicons, i)
+		}
+	}
+	return out
+}
+
+// Difference is a changed item with the values before and after the change
+type Difference struct {
+	Change
+	Before any `json:"before,omitempty"`
+	After  any `json:"after,omitempty"`
+}
+
+// Diff returns the items which changed between the two snapshots
+func Diff(from, to *Snapshot) []Difference {
+	changes, _ := Plan(from, to, true)
+	return differences(changes, from, to)
+}
+
+// differences looks up the items before and after each change
+func differences(changes []Change, from, to *Snapshot) []Difference {
+	before, after := lookup(from), lookup(to)
+	diff := make([]Difference, 0, len(changes))
+	for _, c := range changes {
+		diff = append(diff, Difference{Change: c, Before: before[c.Type+" "+c.Key], After: after[c.Type+" "+c.Key]})
+	}
+	return diff
+}
+
+// lookup maps the type and key of each item in the snapshot to the item
+func lookup(s *Snapshot) map[string]any {
+	m := make(map[string]any)
+	for _, i := range s.Domains {
+		m["domain "+i.Domain] = i
+	}
+	for _, i := range s.Routes {
+		m["route "+i.Src] = i
+	}
+	for _, i := range s.Redirects {
+		m["redirect "+i.Src] = i
+	}
+	for _, i := range s.Favicons {
+		m["favicon "+i.Host] = i
+	}
+	return m
+}
@@ -0,0 +1,146 @@
+package snapshot
+
+import (
+	"context"
+	"github.com/1f349/violet"
+	"github.com/1f349/violet/database"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHistory_Record(t *testing.T) {
+	db, err := violet.InitDB("file:TestHistory_Record?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	h := NewHistory(db, 0)
+
+	first, err := h.Record(ctx, "alice", "startup")
+	assert.NoError(t, err)
+	assert.NotZero(t, first)
+
+	// an unchanged configuration isn't stored again
+	id, err := h.Record(ctx, "alice", "nothing")
+	assert.NoError(t, err)
+	assert.Equal(t, first, id)
+
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.com/a", Destination: "127.0.0.1:8080", Active: true}))
+	second, err := h.Record(ctx, "bob", "create route example.com/a")
+	assert.NoError(t, err)
+	assert.Greater(t, second, first)
+
+	// only versions changing the domains are listed
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "localhost/a", Destination: "127.0.0.1:8081", Active: true}))
+	third, err := h.Record(ctx, "carol", "create route")
+	assert.NoError(t, err)
+	versions, err := h.List(ctx, []string{"example.com"}, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 1) {
+		assert.Equal(t, second, versions[0].ID)
+		assert.Equal(t, "bob", versions[0].Actor)
+		assert.Equal(t, "create route example.com/a", versions[0].Reason)
+	}
+	versions, err = h.List(ctx, []string{"example.com", "localhost"}, 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, versions, 2)
+	assert.Equal(t, third, versions[0].ID)
+	versions, err = h.List(ctx, []string{"example.com"}, second, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+	versions, err = h.List(ctx, nil, 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+
+	_, s, err := h.Get(ctx, second)
+	assert.NoError(t, err)
+	assert.Equal(t, []Route{{Src: "example.com/a", Dst: "127.0.0.1:8080", Active: true}}, s.Routes)
+
+	_, _, err = h.Get(ctx, 1000)
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+
+	var nilHistory *History
+	id, err = nilHistory.Record(ctx, "alice", "ignored")
+	assert.NoError(t, err)
+	assert.Zero(t, id)
+}
+
+func TestHistory_Rollback(t *testing.T) {
+	db, err := violet.InitDB("file:TestHistory_Rollback?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	h := NewHistory(db, 0)
+
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.com/a", Destination: "127.0.0.1:8080", Active: true}))
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.org", Destination: "127.0.0.1:8081", Active: true}))
+	v1, err := h.Record(ctx, "alice", "startup")
+	assert.NoError(t, err)
+
+	// a bad change to both domains
+	_, err = db.RemoveRoute(ctx, database.RemoveRouteParams{Source: "example.com/a"})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.com/b", Destination: "127.0.0.1:9090", Active: true}))
+	assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: "example.org", Destination: "127.0.0.1:9091", Active: true}))
+	v2, err := h.Record(ctx, "bob", "bad change")
+	assert.NoError(t, err)
+
+	_, s1, err := h.Get(ctx, v1)
+	assert.NoError(t, err)
+	_, s2, err := h.Get(ctx, v2)
+	assert.NoError(t, err)
+	assert.Equal(t, []Difference{
+		{Change: Change{Type: "route", Key: "example.com/b", Action: ActionCreate}, After: Route{Src: "example.com/b", Dst: "127.0.0.1:9090", Active: true}},
+		{Change: Change{Type: "route", Key: "example.org", Action: ActionUpdate}, Before: Route{Src: "example.org", Dst: "127.0.0.1:8081", Active: true}, After: Route{Src: "example.org", Dst: "127.0.0.1:9091", Active: true}},
+		{Change: Change{Type: "route", Key: "example.com/a", Action: ActionDelete}, Before: Route{Src: "example.com/a", Dst: "127.0.0.1:8080", Active: true}},
+	}, Diff(s1, s2))
+
+	// only the visible items are restored
+	filter := Filter(func(host string) bool { return host == "example.com" })
+	r, err := h.Rollback(ctx, v1, filter, "carol")
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Type: "route", Key: "example.com/a", Action: ActionCreate},
+		{Type: "route", Key: "example.com/b", Action: ActionDelete},
+	}, r.Changes)
+
+	current, err := h.Current(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Route{
+		{Src: "example.com/a", Dst: "127.0.0.1:8080", Active: true},
+		{Src: "example.org", Dst: "127.0.0.1:9091", Active: true},
+	}, current.Routes)
+
+	// the rollback is recorded as a new version
+	versions, err := h.List(ctx, []string{"example.com"}, 0, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 1) {
+		assert.Greater(t, versions[0].ID, v2)
+		assert.Equal(t, "carol", versions[0].Actor)
+	}
+
+	_, err = h.Rollback(ctx, 1000, nil, "carol")
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
+
+func TestHistory_Limit(t *testing.T) {
+	db, err := violet.InitDB("file:TestHistory_Limit?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	h := NewHistory(db, 2)
+
+	var ids []int64
+	for _, i := range []string{"example.com/a", "example.com/b", "example.com/c"} {
+		assert.NoError(t, db.AddRoute(ctx, database.AddRouteParams{Source: i, Destination: "127.0.0.1:8080", Active: true}))
+		id, err := h.Record(ctx, "alice", "create route")
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	// the oldest version is removed
+	versions, err := h.List(ctx, []string{"example.com"}, 0, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, versions, 2) {
+		assert.Equal(t, ids[2], versions[0].ID)
+		assert.Equal(t, ids[1], versions[1].ID)
+	}
+	_, _, err = h.Get(ctx, ids[0])
+	assert.ErrorIs(t, err, ErrVersionNotFound)
+}
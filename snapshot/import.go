@@ -0,0 +1,371 @@
+package snapshot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/favicons"
+	"reflect"
+	"strings"
+)
+
+// ErrPartialPrune is returned when pruning is requested for a partial snapshot
+var ErrPartialPrune = errors.New("partial snapshot can't be imported with pruning")
+
+// ErrPlanChanged is returned when the changes differ from the expected plan
+var ErrPlanChanged = errors.New("configuration changed since the plan was made")
+
+// Action is the change made to a single item
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// Change describes an item which is created, updated or deleted by an import
+type Change struct {
+	Type   string `json:"type"` // domain, route, redirect or favicon
+	Key    string `json:"key"`
+	Action Action `json:"action"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s %s", c.Action, c.Type, c.Key)
+}
+
+// Result lists the changes made by an import
+type Result struct {
+	DryRun    bool     `json:"dry_run"`
+	Changes   []Change `json:"changes"`
+	Unchanged int      `json:"unchanged"`
+
+	// Differences contains the items before and after each change for the
+	// audit log
+	Differences []Difference `json:"-"`
+}
+
+// ValidationError lists every problem found in a snapshot
+type ValidationError []string
+
+func (v ValidationError) Error() string {
+	return "invalid snapshot: " + strings.Join(v, ", ")
+}
+
+// Validate checks every item in the snapshot and returns a ValidationError
+// containing all the problems found
+func (s *Snapshot) Validate(filter Filter) error {
+	var v ValidationError
+	checkHost := func(t, host string) {
+		switch {
+		case host == "" || strings.ContainsAny(host, ":/"):
+			v = append(v, fmt.Sprintf("%s %q: invalid host", t, host))
+		case !filter.allows(host):
+			v = append(v, fmt.Sprintf("%s %q: domain not allowed", t, host))
+		}
+	}
+	checkDuplicate := func(seen map[string]struct{}, t, key string) {
+		if _, ok := seen[key]; ok {
+			v = append(v, fmt.Sprintf("%s %q: duplicate", t, key))
+		}
+		seen[key] = struct{}{}
+	}
+
+	seen := make(map[string]struct{})
+	for _, i := range s.Domains {
+		checkHost("domain", i.Domain)
+		checkDuplicate(seen, "domain", i.Domain)
+	}
+
+	seen = make(map[string]struct{})
+	for _, i := range s.Routes {
+		checkHost("route", sourceHost(i.Src))
+		checkDuplicate(seen, "route", i.Src)
+		if i.Dst == "" {
+			v = append(v, fmt.Sprintf("route %q: missing destination", i.Src))
+		}
+		if i.WebsocketLimit < 0 {
+			v = append(v, fmt.Sprintf("route %q: negative websocket limit", i.Src))
+		}
+		if err := i.Labels.Validate(); err != nil {
+			v = append(v, fmt.Sprintf("route %q: %s", i.Src, err))
+		}
+	}
+
+	seen = make(map[string]struct{})
+	for _, i := range s.Redirects {
+		checkHost("redirect", sourceHost(i.Src))
+		checkDuplicate(seen, "redirect", i.Src)
+		if i.Dst == "" {
+			v = append(v, fmt.Sprintf("redirect %q: missing destination", i.Src))
+		}
+		if i.Code != 0 && (i.Code < 300 || i.Code > 399) {
+			v = append(v, fmt.Sprintf("redirect %q: invalid status code %d", i.Src, i.Code))
+		}
+		if err := i.Labels.Validate(); err != nil {
+			v = append(v, fmt.Sprintf("redirect %q: %s", i.Src, err))
+		}
+	}
+
+	seen = make(map[string]struct{})
+	for _, i := range s.Favicons {
+		checkHost("favicon", i.Host)
+		checkDuplicate(seen, "favicon", i.Host)
+		if i.Svg == "" && i.Png == "" && i.Ico == "" {
+			v = append(v, fmt.Sprintf("favicon %q: missing favicon url", i.Host))
+		}
+		for _, u := range []string{i.Svg, i.Png, i.Ico} {
+			if u != "" && !favicons.ValidUrl(u) {
+				v = append(v, fmt.Sprintf("favicon %q: invalid url %q", i.Host, u))
+			}
+		}
+	}
+
+	if len(v) > 0 {
+		return v
+	}
+	return nil
+}
+
+// ImportOptions changes how a snapshot is imported
+type ImportOptions struct {
+	// DryRun only reports the changes
+	DryRun bool
+
+	// Prune deletes the visible items missing from the sections included in
+	// the snapshot
+	Prune bool
+
+	// Record is called in the transaction after applying the changes, this is
+	// used to store the new configuration in the history
+	Record func(tx *database.Queries) error
+
+	// Expected is the plan from an earlier dry run, the import fails with
+	// ErrPlanChanged without making any changes unless the changes and the
+	// items before them still match
+	Expected []Difference
+}
+
+// Import validates the snapshot and then applies the changes in a single
+// transaction. Items missing from the snapshot are left unchanged unless
+// pruning is enabled. Partial snapshots can't be pruned as the missing items
+// were only left out of the export.
+func Import(ctx context.Context, db *database.Queries, s *Snapshot, filter Filter, opts ImportOptions) (*Result, error) {
+	if s.Partial && opts.Prune {
+		return nil, ErrPartialPrune
+	}
+	if err := s.Validate(filter); err != nil {
+		return nil, err
+	}
+
+	r := &Result{DryRun: opts.DryRun, Changes: []Change{}}
+	err := db.Transaction(ctx, func(tx *database.Queries) error {
+		// the snapshot is validated so only contains visible items
+		current, err := Export(ctx, tx, filter)
+		if err != nil {
+			return err
+		}
+		r.Changes, r.Unchanged = Plan(current, s, opts.Prune)
+		r.Differences = differences(r.Changes, current, s)
+		if opts.DryRun {
+			return nil
+		}
+		if opts.Expected != nil && !reflect.DeepEqual(opts.Expected, r.Differences) {
+			return ErrPlanChanged
+		}
+		if err := apply(ctx, tx, s, r.Changes); err != nil {
+			return err
+		}
+		if opts.Record != nil && len(r.Changes) > 0 {
+			return opts.Record(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Plan returns the changes needed to make current match next and the number
+// of items which already match. Items missing from next are only deleted when
+// pruning and the section is included in next. Inactive domains are treated as
+// deleted.
+func Plan(current, next *Snapshot, prune bool) (changes []Change, unchanged int) {
+	changes = []Change{}
+	add := func(found, equal bool, t, key string) {
+		switch {
+		case !found:
+			changes = append(changes, Change{Type: t, Key: key, Action: ActionCreate})
+		case !equal:
+			changes = append(changes, Change{Type: t, Key: key, Action: ActionUpdate})
+		default:
+			unchanged++
+		}
+	}
+
+	domains := keyed(current.Domains, func(d Domain) string { return d.Domain })
+	for _, i := range next.Domains {
+		c, ok := domains[i.Domain]
+		add(ok, c == i, "domain", i.Domain)
+	}
+	routes := keyed(current.Routes, func(r Route) string { return r.Src })
+	for _, i := range next.Routes {
+		c, ok := routes[i.Src]
+		add(ok, c.equal(i), "route", i.Src)
+	}
+	redirects := keyed(current.Redirects, func(r Redirect) string { return r.Src })
+	for _, i := range next.Redirects {
+		c, ok := redirects[i.Src]
+		add(ok, c.equal(i), "redirect", i.Src)
+	}
+	icons := keyed(current.Favicons, func(f favicons.FaviconUrls) string { return f.Host })
+	for _, i := range next.Favicons {
+		c, ok := icons[i.Host]
+		add(ok, c == i, "favicon", i.Host)
+	}
+
+	if !prune {
+		return changes, unchanged
+	}
+	remove := func(t, key string) {
+		changes = append(changes, Change{Type: t, Key: key, Action: ActionDelete})
+	}
+	if next.Domains != nil {
+		managed := keyed(next.Domains, func(d Domain) string { return d.Domain })
+		for _, i := range current.Domains {
+			if _, ok := managed[i.Domain]; !ok && i.Active {
+				remove("domain", i.Domain)
+			}
+		}
+	}
+	if next.Routes != nil {
+		managed := keyed(next.Routes, func(r Route) string { return r.Src })
+		for _, i := range current.Routes {
+			if _, ok := managed[i.Src]; !ok {
+				remove("route", i.Src)
+			}
+		}
+	}
+	if next.Redirects != nil {
+		managed := keyed(next.Redirects, func(r Redirect) string { return r.Src })
+		for _, i := range current.Redirects {
+			if _, ok := managed[i.Src]; !ok {
+				remove("redirect", i.Src)
+			}
+		}
+	}
+	if next.Favicons != nil {
+		managed := keyed(next.Favicons, func(f favicons.FaviconUrls) string { return f.Host })
+		for _, i := range current.Favicons {
+			if _, ok := managed[i.Host]; !ok {
+				remove("favicon", i.Host)
+			}
+		}
+	}
+	return changes, unchanged
+}
+
+func keyed[T any](items []T, key func(T) string) map[string]T {
+	m := make(map[string]T, len(items))
+	for _, i := range items {
+		m[key(i)] = i
+	}
+	return m
+}
+
+// apply writes the items from the snapshot listed in changes and deletes the
+// removed items
+func apply(ctx context.Context, tx *database.Queries, s *Snapshot, changes []Change) error {
+	changed := make(map[string]struct{}, len(changes))
+	for _, c := range changes {
+		if c.Action == ActionDelete {
+			if err := remove(ctx, tx, c); err != nil {
+				return err
+			}
+			continue
+		}
+		changed[c.Type+" "+c.Key] = struct{}{}
+	}
+	has := func(t, key string) bool {
+		_, ok := changed[t+" "+key]
+		return ok
+	}
+
+	for _, i := range s.Domains {
+		if !has("domain", i.Domain) {
+			continue
+		}
+		if err := tx.AddDomain(ctx, database.AddDomainParams{Domain: i.Domain, Active: i.Active}); err != nil {
+			return err
+		}
+	}
+	for _, i := range s.Routes {
+		if !has("route", i.Src) {
+			continue
+		}
+		err := tx.AddRoute(ctx, database.AddRouteParams{
+			Source:         i.Src,
+			Destination:    i.Dst,
+			Description:    i.Desc,
+			Flags:          i.Flags,
+			Active:         i.Active,
+			WebsocketLimit: i.WebsocketLimit,
+			Labels:         i.Labels,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, i := range s.Redirects {
+		if !has("redirect", i.Src) {
+			continue
+		}
+		err := tx.AddRedirect(ctx, database.AddRedirectParams{
+			Source:      i.Src,
+			Destination: i.Dst,
+			Description: i.Desc,
+			Flags:       i.Flags,
+			Code:        i.Code,
+			Active:      i.Active,
+			Labels:      i.Labels,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for _, i := range s.Favicons {
+		if !has("favicon", i.Host) {
+			continue
+		}
+		err := tx.UpdateFaviconCache(ctx, database.UpdateFaviconCacheParams{
+			Host: i.Host,
+			Svg:  sql.NullString{String: i.Svg, Valid: i.Svg != ""},
+			Png:  sql.NullString{String: i.Png, Valid: i.Png != ""},
+			Ico:  sql.NullString{String: i.Ico, Valid: i.Ico != ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the item for a delete change, domains are only deactivated
+func remove(ctx context.Context, tx *database.Queries, c Change) error {
+	var err error
+	switch c.Type {
+	case "domain":
+		err = tx.DeleteDomain(ctx, c.Key)
+	case "route":
+		_, err = tx.RemoveRoute(ctx, database.RemoveRouteParams{Source: c.Key})
+	case "redirect":
+		_, err = tx.RemoveRedirect(ctx, database.RemoveRedirectParams{Source: c.Key})
+	case "favicon":
+		_, err = tx.DeleteFavicon(ctx, c.Key)
+	}
+	return err
+}
@@ -0,0 +1,243 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/favicons"
+	"github.com/1f349/violet/target"
+	"github.com/1f349/violet/utils"
+	"gopkg.in/yaml.v3"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Snapshot contains the routing configuration stored in the database
+type Snapshot struct {
+	Domains   []Domain               `json:"domains" yaml:"domains"`
+	Routes    []Route                `json:"routes" yaml:"routes"`
+	Redirects []Redirect             `json:"redirects" yaml:"redirects"`
+	Favicons  []favicons.FaviconUrls `json:"favicons" yaml:"favicons"`
+
+	// Partial is set when items were left out of the snapshot, so importing
+	// it can't prune the missing items
+	Partial bool `json:"partial,omitempty" yaml:"partial,omitempty"`
+}
+
+// Domain is an allowed domain and its active state
+type Domain struct {
+	Domain string `json:"domain" yaml:"domain"`
+	Active bool   `json:"active" yaml:"active"`
+}
+
+// Route is the stored form of a target.Route
+type Route struct {
+	Src            string        `json:"src" yaml:"src"`
+	Dst            string        `json:"dst" yaml:"dst"`
+	Desc           string        `json:"desc,omitempty" yaml:"desc,omitempty"`
+	Flags          target.Flags  `json:"flags,omitempty" yaml:"flags,omitempty"`
+	WebsocketLimit int64         `json:"websocket_limit,omitempty" yaml:"websocket_limit,omitempty"`
+	Labels         target.Labels `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Active         bool          `json:"active" yaml:"active"`
+}
+
+func (r Route) equal(o Route) bool {
+	return r.Src == o.Src && r.Dst == o.Dst && r.Desc == o.Desc && r.Flags == o.Flags &&
+		r.WebsocketLimit == o.WebsocketLimit && maps.Equal(r.Labels, o.Labels) && r.Active == o.Active
+}
+
+// Redirect is the stored form of a target.Redirect
+type Redirect struct {
+	Src    string        `json:"src" yaml:"src"`
+	Dst    string        `json:"dst" yaml:"dst"`
+	Desc   string        `json:"desc,omitempty" yaml:"desc,omitempty"`
+	Flags  target.Flags  `json:"flags,omitempty" yaml:"flags,omitempty"`
+	Code   int64         `json:"code,omitempty" yaml:"code,omitempty"`
+	Labels target.Labels `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Active bool          `json:"active" yaml:"active"`
+}
+
+func (r Redirect) equal(o Redirect) bool {
+	return r.Src == o.Src && r.Dst == o.Dst && r.Desc == o.Desc && r.Flags == o.Flags &&
+		r.Code == o.Code && maps.Equal(r.Labels, o.Labels) && r.Active == o.Active
+}
+
+// Filter reports whether a host is visible, a nil Filter allows all hosts
+type Filter func(host string) bool
+
+func (f Filter) allows(host string) bool {
+	return f == nil || f(host)
+}
+
+// sourceHost returns the host of a route or redirect source
+func sourceHost(src string) string {
+	host, _ := utils.SplitHostPath(src)
+	return host
+}
+
+// Export reads the domains, routes, redirects and favicons visible to the
+// filter from the database
+func Export(ctx context.Context, db *database.Queries, filter Filter) (*Snapshot, error) {
+	s := &Snapshot{
+		Domains:   []Domain{},
+		Routes:    []Route{},
+		Redirects: []Redirect{},
+		Favicons:  []favicons.FaviconUrls{},
+	}
+
+	domainRows, err := db.GetAllDomains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range domainRows {
+		if filter.allows(row.Domain) {
+			s.Domains = append(s.Domains, Domain{Domain: row.Domain, Active: row.Active})
+		}
+	}
+
+	routeRows, err := db.GetAllRoutes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range routeRows {
+		if filter.allows(sourceHost(row.Source)) {
+			s.Routes = append(s.Routes, Route{
+				Src:            row.Source,
+				Dst:            row.Destination,
+				Desc:           row.Description,
+				Flags:          row.Flags,
+				WebsocketLimit: row.WebsocketLimit,
+				Labels:         row.Labels,
+				Active:         row.Active,
+			})
+		}
+	}
+
+	redirectRows, err := db.GetAllRedirects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range redirectRows {
+		if filter.allows(sourceHost(row.Source)) {
+			s.Redirects = append(s.Redirects, Redirect{
+				Src:    row.Source,
+				Dst:    row.Destination,
+				Desc:   row.Description,
+				Flags:  row.Flags,
+				Code:   row.Code,
+				Labels: row.Labels,
+				Active: row.Active,
+			})
+		}
+	}
+
+	faviconRows, err := db.GetFavicons(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range faviconRows {
+		if filter.allows(row.Host) {
+			s.Favicons = append(s.Favicons, favicons.FaviconUrls{
+				Host: row.Host,
+				Svg:  row.Svg.String,
+				Png:  row.Png.String,
+				Ico:  row.Ico.String,
+			})
+		}
+	}
+
+	// sort for stable output
+	slices.SortFunc(s.Routes, func(a, b Route) int { return strings.Compare(a.Src, b.Src) })
+	slices.SortFunc(s.Redirects, func(a, b Redirect) int { return strings.Compare(a.Src, b.Src) })
+	slices.SortFunc(s.Favicons, func(a, b favicons.FaviconUrls) int { return strings.Compare(a.Host, b.Host) })
+	return s, nil
+}
+
+// Format is the encoding used for a snapshot
+type Format string
+
+const (
+	FormatJson Format = "json"
+	FormatYaml Format = "yaml"
+)
+
+// ParseFormat returns the format with the name, an empty name defaults to
+// FormatJson
+func ParseFormat(a string) (Format, error) {
+	switch strings.ToLower(a) {
+	case "", "json":
+		return FormatJson, nil
+	case "yaml", "yml":
+		return FormatYaml, nil
+	}
+	return "", fmt.Errorf("unknown snapshot format: %s", a)
+}
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	if f == FormatYaml {
+		return "application/yaml"
+	}
+	return "application/json"
+}
+
+// Labelled returns a copy of the snapshot only containing the routes and
+// redirects with all the labels in the selector. Domains and favicons have no
+// labels so are left out. The copy is marked as partial.
+func (s *Snapshot) Labelled(selector target.Labels) *Snapshot {
+	out := &Snapshot{
+		Domains:   []Domain{},
+		Routes:    []Route{},
+		Redirects: []Redirect{},
+		Favicons:  []favicons.FaviconUrls{},
+		Partial:   true,
+	}
+	for _, i := range s.Routes {
+		if i.Labels.Matches(selector) {
+			out.Routes = append(out.Routes, i)
+		}
+	}
+	for _, i := range s.Redirects {
+		if i.Labels.Matches(selector) {
+			out.Redirects = append(out.Redirects, i)
+		}
+	}
+	return out
+}
+
+// Encode writes the snapshot in the format
+func (s *Snapshot) Encode(w io.Writer, f Format) error {
+	if f == FormatYaml {
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(s); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+// Decode reads a snapshot in the format
+func Decode(r io.Reader, f Format) (*Snapshot, error) {
+	var s Snapshot
+	if f == FormatYaml {
+		dec := yaml.NewDecoder(r)
+		dec.KnownFields(true)
+		if err := dec.Decode(&s); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return &s, nil
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
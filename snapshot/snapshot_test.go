@@ -0,0 +1,266 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"github.com/1f349/violet"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/favicons"
+	"github.com/1f349/violet/target"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testSnapshot = &Snapshot{
+	Domains: []Domain{{Domain: "example.com", Active: true}, {Domain: "example.org", Active: false}},
+	Routes: []Route{
+		{Src: "example.com/a", Dst: "127.0.0.1:8080", Desc: "first", Flags: target.FlagPre, WebsocketLimit: 5, Labels: target.Labels{"team": "payments"}, Active: true},
+		{Src: "example.org", Dst: "127.0.0.1:8081", Active: false},
+	},
+	Redirects: []Redirect{{Src: "www.example.com", Dst: "example.com", Code: 308, Labels: target.Labels{"team": "payments", "env": "staging"}, Active: true}},
+	Favicons:  []favicons.FaviconUrls{{Host: "example.com", Png: "https://example.com/logo.png"}},
+}
+
+func TestSnapshot_Encode(t *testing.T) {
+	for _, f := range []Format{FormatJson, FormatYaml} {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, testSnapshot.Encode(&buf, f))
+			s, err := Decode(&buf, f)
+			assert.NoError(t, err)
+			assert.Equal(t, testSnapshot, s)
+		})
+	}
+
+	_, err := Decode(bytes.NewBufferString("routes:\n  - src: example.com\n    target: a\n"), FormatYaml)
+	assert.Error(t, err)
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	db, err := violet.InitDB("file:TestImport?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// dry run doesn't write anything
+	r, err := Import(ctx, db, testSnapshot, nil, ImportOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.True(t, r.DryRun)
+	assert.Len(t, r.Changes, 6)
+	assert.Equal(t, Change{Type: "domain", Key: "example.com", Action: ActionCreate}, r.Changes[0])
+	s, err := Export(ctx, db, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, s.Routes)
+
+	r, err = Import(ctx, db, testSnapshot, nil, ImportOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, r.Changes, 6)
+	s, err = Export(ctx, db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, testSnapshot, s)
+
+	// only the modified items are changed
+	next := &Snapshot{
+		Routes:    []Route{{Src: "example.com/a", Dst: "127.0.0.1:9090", Active: true}},
+		Redirects: testSnapshot.Redirects,
+	}
+	r, err = Import(ctx, db, next, nil, ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{{Type: "route", Key: "example.com/a", Action: ActionUpdate}}, r.Changes)
+	assert.Equal(t, 1, r.Unchanged)
+	route, err := db.GetRoute(ctx, "example.com/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:9090", route.Destination)
+	assert.Equal(t, int64(2), route.Version)
+
+	// the filter limits visible items
+	onlyOrg := func(host string) bool { return host == "example.org" }
+	s, err = Export(ctx, db, onlyOrg)
+	assert.NoError(t, err)
+	assert.Equal(t, []Domain{{Domain: "example.org"}}, s.Domains)
+	assert.Equal(t, []Route{{Src: "example.org", Dst: "127.0.0.1:8081"}}, s.Routes)
+	assert.Empty(t, s.Redirects)
+	assert.Empty(t, s.Favicons)
+}
+
+func TestImport_Validate(t *testing.T) {
+	db, err := violet.InitDB("file:TestImport_Validate?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	s := &Snapshot{
+		Domains: []Domain{{Domain: "example.com:443"}},
+		Routes: []Route{
+			{Src: "example.com/a", Dst: "127.0.0.1:8080"},
+			{Src: "example.com/a", Dst: "127.0.0.1:8081"},
+			{Src: "example.net/b"},
+			{Src: "example.com/c", Dst: "127.0.0.1:8082", Labels: target.Labels{"team name": "payments"}},
+		},
+		Redirects: []Redirect{{Src: "www.example.com", Dst: "example.com", Code: 200, Labels: target.Labels{"team": "a,b"}}},
+		Favicons:  []favicons.FaviconUrls{{Host: "example.com", Svg: "ftp://example.com/logo.svg"}},
+	}
+	_, err = Import(context.Background(), db, s, func(host string) bool { return host != "example.net" }, ImportOptions{})
+	var v ValidationError
+	assert.ErrorAs(t, err, &v)
+	assert.Equal(t, ValidationError{
+		`domain "example.com:443": invalid host`,
+		`route "example.com/a": duplicate`,
+		`route "example.net": domain not allowed`,
+		`route "example.net/b": missing destination`,
+		`route "example.com/c": invalid label key 'team name'`,
+		`redirect "www.example.com": invalid status code 200`,
+		`redirect "www.example.com": invalid value for label 'team'`,
+		`favicon "example.com": invalid url "ftp://example.com/logo.svg"`,
+	}, v)
+
+	// nothing is written when the snapshot is invalid
+	rows, err := db.GetAllRoutes(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, rows)
+}
+
+func TestImport_Rollback(t *testing.T) {
+	db, err := violet.InitDB("file:TestImport_Rollback?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	err = db.Transaction(ctx, func(tx *database.Queries) error {
+		_, err := Import(ctx, tx, testSnapshot, nil, ImportOptions{})
+		assert.NoError(t, err)
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+
+	s, err := Export(ctx, db, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, s.Domains)
+	assert.Empty(t, s.Routes)
+}
+
+func TestPlan_Prune(t *testing.T) {
+	current := &Snapshot{
+		Domains:   []Domain{{Domain: "example.com", Active: true}, {Domain: "example.net", Active: true}, {Domain: "example.org"}},
+		Routes:    []Route{{Src: "example.com/a", Dst: "127.0.0.1:8080"}, {Src: "example.com/b", Dst: "127.0.0.1:8081"}},
+		Redirects: []Redirect{{Src: "www.example.com", Dst: "example.com"}},
+		Favicons:  []favicons.FaviconUrls{{Host: "example.com", Png: "https://example.com/logo.png"}},
+	}
+	next := &Snapshot{
+		Domains: []Domain{{Domain: "example.com", Active: true}},
+		Routes:  []Route{{Src: "example.com/a", Dst: "127.0.0.1:9090"}},
+	}
+
+	changes, unchanged := Plan(current, next, false)
+	assert.Equal(t, []Change{{Type: "route", Key: "example.com/a", Action: ActionUpdate}}, changes)
+	assert.Equal(t, 1, unchanged)
+
+	// inactive domains and missing sections are left alone
+	changes, unchanged = Plan(current, next, true)
+	assert.Equal(t, []Change{
+		{Type: "route", Key: "example.com/a", Action: ActionUpdate},
+		{Type: "domain", Key: "example.net", Action: ActionDelete},
+		{Type: "route", Key: "example.com/b", Action: ActionDelete},
+	}, changes)
+	assert.Equal(t, 1, unchanged)
+}
+
+func TestImport_Prune(t *testing.T) {
+	db, err := violet.InitDB("file:TestImport_Prune?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	_, err = Import(ctx, db, testSnapshot, nil, ImportOptions{})
+	assert.NoError(t, err)
+
+	// partial snapshots are never pruned
+	_, err = Import(ctx, db, testSnapshot.Labelled(target.Labels{"env": "staging"}), nil, ImportOptions{Prune: true})
+	assert.ErrorIs(t, err, ErrPartialPrune)
+	r, err := Import(ctx, db, testSnapshot.Labelled(target.Labels{"env": "staging"}), nil, ImportOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, r.Changes)
+
+	// hidden items are never deleted
+	next := &Snapshot{Routes: []Route{}, Favicons: []favicons.FaviconUrls{}}
+	r, err = Import(ctx, db, next, func(host string) bool { return host == "example.com" }, ImportOptions{Prune: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{
+		{Type: "route", Key: "example.com/a", Action: ActionDelete},
+		{Type: "favicon", Key: "example.com", Action: ActionDelete},
+	}, r.Changes)
+
+	s, err := Export(ctx, db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, testSnapshot.Domains, s.Domains)
+	assert.Equal(t, testSnapshot.Routes[1:], s.Routes)
+	assert.Equal(t, testSnapshot.Redirects, s.Redirects)
+	assert.Empty(t, s.Favicons)
+}
+
+func TestSnapshot_Labelled(t *testing.T) {
+	s := testSnapshot.Labelled(target.Labels{"team": "payments"})
+	assert.Equal(t, &Snapshot{
+		Domains:   []Domain{},
+		Routes:    []Route{testSnapshot.Routes[0]},
+		Redirects: testSnapshot.Redirects,
+		Favicons:  []favicons.FaviconUrls{},
+		Partial:   true,
+	}, s)
+
+	s = testSnapshot.Labelled(target.Labels{"env": "staging"})
+	assert.Empty(t, s.Routes)
+	assert.Equal(t, testSnapshot.Redirects, s.Redirects)
+
+	// changing only the labels updates the route
+	next := &Snapshot{Routes: []Route{testSnapshot.Routes[0], testSnapshot.Routes[1]}}
+	next.Routes[1].Labels = target.Labels{"team": "search"}
+	changes, unchanged := Plan(testSnapshot, next, false)
+	assert.Equal(t, []Change{{Type: "route", Key: "example.org", Action: ActionUpdate}}, changes)
+	assert.Equal(t, 1, unchanged)
+}
+
+func TestImport_Reconcile(t *testing.T) {
+	db, err := violet.InitDB("file:TestImport_Reconcile?mode=memory&cache=shared")
+	assert.NoError(t, err)
+	ctx := context.Background()
+	_, err = Import(ctx, db, &Snapshot{
+		Domains:   []Domain{{Domain: "example.org", Active: true}},
+		Routes:    []Route{{Src: "example.com/old", Dst: "127.0.0.1:8080", Active: true}},
+		Redirects: []Redirect{{Src: "www.example.com", Dst: "example.org", Active: true}},
+		Favicons:  []favicons.FaviconUrls{{Host: "example.com", Png: "https://example.com/logo.png"}},
+	}, nil, ImportOptions{})
+	assert.NoError(t, err)
+
+	// the plan from a dry run is applied without touching favicons
+	desired := &Snapshot{
+		Domains:   []Domain{{Domain: "example.com", Active: true}},
+		Routes:    []Route{{Src: "example.com/a", Dst: "127.0.0.1:8081", Active: true}},
+		Redirects: []Redirect{{Src: "www.example.com", Dst: "example.com", Code: 308, Active: true}},
+	}
+	plan, err := Import(ctx, db, desired, nil, ImportOptions{DryRun: true, Prune: true})
+	assert.NoError(t, err)
+
+	// a stale plan isn't applied
+	_, err = Import(ctx, db, &Snapshot{Routes: []Route{{Src: "example.com/old", Dst: "127.0.0.1:8082", Active: true}}}, nil, ImportOptions{})
+	assert.NoError(t, err)
+	_, err = Import(ctx, db, desired, nil, ImportOptions{Prune: true, Expected: plan.Differences})
+	assert.ErrorIs(t, err, ErrPlanChanged)
+	s, err := Export(ctx, db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []Route{{Src: "example.com/old", Dst: "127.0.0.1:8082", Active: true}}, s.Routes)
+
+	plan, err = Import(ctx, db, desired, nil, ImportOptions{DryRun: true, Prune: true})
+	assert.NoError(t, err)
+	r, err := Import(ctx, db, desired, nil, ImportOptions{Prune: true, Expected: plan.Differences})
+	assert.NoError(t, err)
+	assert.Equal(t, plan.Changes, r.Changes)
+
+	s, err = Export(ctx, db, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, desired.Routes, s.Routes)
+	assert.Equal(t, desired.Redirects, s.Redirects)
+	assert.Len(t, s.Favicons, 1)
+
+	// nothing is left to change
+	r, err = Import(ctx, db, desired, nil, ImportOptions{DryRun: true, Prune: true})
+	assert.NoError(t, err)
+	assert.Empty(t, r.Changes)
+}
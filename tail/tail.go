@@ -0,0 +1,109 @@
+package tail
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event contains the details of a single request sent to tail subscribers.
+type Event struct {
+	Time      time.Time `json:"time"`
+	RequestId string    `json:"request_id,omitempty"`
+	Host      string    `json:"host"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Route     string    `json:"route,omitempty"`    // source of the matched route or redirect
+	Upstream  string    `json:"upstream,omitempty"` // destination url of the proxied request
+	Status    int       `json:"status"`
+	Latency   float64   `json:"latency"` // seconds taken to respond
+}
+
+// Tail broadcasts request events to live subscribers. Events are dropped for
+// subscribers which fall behind so publishing never blocks a request.
+type Tail struct {
+	mu     sync.RWMutex
+	subs   map[*subscriber]struct{}
+	n      atomic.Int32
+	closed bool
+}
+
+type subscriber struct {
+	c     chan Event
+	match func(Event) bool
+}
+
+// New creates a Tail without any subscribers.
+func New() *Tail {
+	return &Tail{subs: make(map[*subscriber]struct{})}
+}
+
+// Active returns true if there are any subscribers, this allows the caller to
+// skip building events nobody is listening for. A nil Tail is never active.
+func (t *Tail) Active() bool {
+	return t != nil && t.n.Load() > 0
+}
+
+// Publish sends the event to each subscriber matching it.
+func (t *Tail) Publish(e Event) {
+	if !t.Active() {
+		return
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for s := range t.subs {
+		if s.match != nil && !s.match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			// drop the event for slow subscribers
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events matching the filter and a
+// function to remove the subscription. The channel is closed once the
+// subscription is removed or the Tail is closed.
+func (t *Tail) Subscribe(match func(Event) bool, buffer int) (<-chan Event, func()) {
+	s := &subscriber{c: make(chan Event, buffer), match: match}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		close(s.c)
+		return s.c, func() {}
+	}
+	t.subs[s] = struct{}{}
+	t.n.Add(1)
+
+	return s.c, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.subs[s]; ok {
+			t.remove(s)
+		}
+	}
+}
+
+// Close removes all subscribers and prevents new subscriptions, this ends the
+// streams of connected clients during shutdown.
+func (t *Tail) Close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for s := range t.subs {
+		t.remove(s)
+	}
+}
+
+// remove must be called with the lock held
+func (t *Tail) remove(s *subscriber) {
+	delete(t.subs, s)
+	t.n.Add(-1)
+	close(s.c)
+}
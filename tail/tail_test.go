@@ -0,0 +1,63 @@
+package tail
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTail_Publish(t *testing.T) {
+	tl := New()
+	assert.False(t, tl.Active())
+
+	all, cancelAll := tl.Subscribe(nil, 4)
+	hosts, cancelHosts := tl.Subscribe(func(e Event) bool { return e.Host == "example.com" }, 4)
+	assert.True(t, tl.Active())
+
+	tl.Publish(Event{Host: "example.com", Status: 200})
+	tl.Publish(Event{Host: "example.org", Status: 404})
+
+	assert.Equal(t, "example.com", (<-all).Host)
+	assert.Equal(t, "example.org", (<-all).Host)
+	assert.Equal(t, "example.com", (<-hosts).Host)
+	assert.Len(t, hosts, 0)
+
+	cancelAll()
+	_, ok := <-all
+	assert.False(t, ok)
+
+	// cancelling twice is safe
+	cancelAll()
+	cancelHosts()
+	assert.False(t, tl.Active())
+}
+
+func TestTail_Publish_Slow(t *testing.T) {
+	tl := New()
+	c, cancel := tl.Subscribe(nil, 1)
+	defer cancel()
+
+	// the second event is dropped instead of blocking
+	tl.Publish(Event{Path: "/a"})
+	tl.Publish(Event{Path: "/b"})
+	assert.Equal(t, "/a", (<-c).Path)
+	assert.Len(t, c, 0)
+}
+
+func TestTail_Close(t *testing.T) {
+	tl := New()
+	c, cancel := tl.Subscribe(nil, 1)
+	tl.Close()
+	_, ok := <-c
+	assert.False(t, ok)
+	cancel()
+
+	// subscribing after close returns a closed channel
+	c, _ = tl.Subscribe(nil, 1)
+	_, ok = <-c
+	assert.False(t, ok)
+
+	var nilTail *Tail
+	assert.False(t, nilTail.Active())
+	nilTail.Publish(Event{})
+	nilTail.Close()
+}
@@ -11,10 +11,12 @@ const (
 	FlagForwardAddr
 	FlagIgnoreCert
 	FlagWebsocket
+	FlagWebsocketTunnel
+	FlagServerTiming
 )
 
 var (
-	routeFlagMask    = FlagPre | FlagAbs | FlagCors | FlagSecureMode | FlagForwardHost | FlagForwardAddr | FlagIgnoreCert | FlagWebsocket
+	routeFlagMask    = FlagPre | FlagAbs | FlagCors | FlagSecureMode | FlagForwardHost | FlagForwardAddr | FlagIgnoreCert | FlagWebsocket | FlagWebsocketTunnel | FlagServerTiming
 	redirectFlagMask = FlagPre | FlagAbs
 )
 
@@ -0,0 +1,113 @@
+package target
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// labelKeyPattern matches the allowed label keys such as team or app/tier
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]{0,62})$`)
+
+// maxLabelValue is the longest allowed label value
+const maxLabelValue = 255
+
+// Labels are key/value pairs attached to routes and redirects for grouping
+// them, such as team=payments or env=staging
+type Labels map[string]string
+
+// Validate checks the keys and values are allowed
+func (l Labels) Validate() error {
+	for _, k := range slices.Sorted(maps.Keys(l)) {
+		if !labelKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key '%s'", k)
+		}
+		if len(l[k]) > maxLabelValue || strings.ContainsAny(l[k], ",=") {
+			return fmt.Errorf("invalid value for label '%s'", k)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the labels contain every key and value in the
+// selector
+func (l Labels) Matches(selector Labels) bool {
+	for k, v := range selector {
+		if a, ok := l[k]; !ok || a != v {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the labels as a selector sorted by key
+func (l Labels) String() string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(l)) {
+		if b.Len() > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(l[k])
+	}
+	return b.String()
+}
+
+// ParseSelector parses labels in the "team=payments,env=staging" format
+func ParseSelector(a string) (Labels, error) {
+	l := make(Labels)
+	if a == "" {
+		return l, nil
+	}
+	for _, i := range strings.Split(a, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(i), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label selector '%s'", i)
+		}
+		if _, ok := l[k]; ok {
+			return nil, fmt.Errorf("duplicate label selector '%s'", k)
+		}
+		l[k] = v
+	}
+	return l, l.Validate()
+}
+
+// Value stores the labels as a JSON object
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]string(l))
+	return string(b), err
+}
+
+// Scan reads the labels from a JSON object
+func (l *Labels) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	case nil:
+		*l = nil
+		return nil
+	default:
+		return errors.New("unsupported type for labels")
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	*l = m
+	return nil
+}
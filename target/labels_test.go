@@ -0,0 +1,61 @@
+package target
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLabels_Validate(t *testing.T) {
+	assert.NoError(t, Labels(nil).Validate())
+	assert.NoError(t, Labels{"team": "payments", "app.kubernetes.io/tier": "web", "env": ""}.Validate())
+	assert.Error(t, Labels{"": "a"}.Validate())
+	assert.Error(t, Labels{"-team": "a"}.Validate())
+	assert.Error(t, Labels{"team name": "a"}.Validate())
+	assert.Error(t, Labels{"team": "a,b"}.Validate())
+	assert.Error(t, Labels{"team": "a=b"}.Validate())
+}
+
+func TestParseSelector(t *testing.T) {
+	l, err := ParseSelector("team=payments, env=staging")
+	assert.NoError(t, err)
+	assert.Equal(t, Labels{"team": "payments", "env": "staging"}, l)
+	assert.Equal(t, "env=staging,team=payments", l.String())
+
+	l, err = ParseSelector("")
+	assert.NoError(t, err)
+	assert.Empty(t, l)
+
+	_, err = ParseSelector("team")
+	assert.Error(t, err)
+	_, err = ParseSelector("team=a,team=b")
+	assert.Error(t, err)
+	_, err = ParseSelector("=a")
+	assert.Error(t, err)
+}
+
+func TestLabels_Matches(t *testing.T) {
+	l := Labels{"team": "payments", "env": "staging"}
+	assert.True(t, l.Matches(nil))
+	assert.True(t, l.Matches(Labels{"team": "payments"}))
+	assert.True(t, l.Matches(Labels{"team": "payments", "env": "staging"}))
+	assert.False(t, l.Matches(Labels{"team": "search"}))
+	assert.False(t, l.Matches(Labels{"region": "eu"}))
+	assert.False(t, Labels(nil).Matches(Labels{"team": "payments"}))
+}
+
+func TestLabels_Scan(t *testing.T) {
+	v, err := Labels(nil).Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", v)
+
+	v, err = Labels{"team": "payments"}.Value()
+	assert.NoError(t, err)
+	var l Labels
+	assert.NoError(t, l.Scan(v))
+	assert.Equal(t, Labels{"team": "payments"}, l)
+
+	// an empty object is stored for missing labels
+	assert.NoError(t, l.Scan([]byte("{}")))
+	assert.Nil(t, l)
+	assert.Error(t, l.Scan(1))
+}
@@ -12,11 +12,12 @@ import (
 // Redirect is a target used by the router to manage redirecting the request
 // using the specified configuration.
 type Redirect struct {
-	Src   string `json:"src"`   // request source
-	Dst   string `json:"dst"`   // redirect destination
-	Desc  string `json:"desc"`  // description for admin panel use
-	Flags Flags  `json:"flags"` // extra flags
-	Code  int64  `json:"code"`  // status code used to redirect
+	Src    string `json:"src"`              // request source
+	Dst    string `json:"dst"`              // redirect destination
+	Desc   string `json:"desc"`             // description for admin panel use
+	Flags  Flags  `json:"flags"`            // extra flags
+	Code   int64  `json:"code"`             // status code used to redirect
+	Labels Labels `json:"labels,omitempty"` // labels for grouping redirects
 }
 
 type RedirectWithActive struct {
@@ -39,23 +40,18 @@ func (r Redirect) HasFlag(flag Flags) bool {
 	return r.Flags&flag != 0
 }
 
-// ServeHTTP responds with the redirect to the response writer provided.
-func (r Redirect) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	// default to redirecting with StatusFound if code is not set
-	code := r.Code
-	if r.Code == 0 {
-		code = http.StatusFound
-	}
-
+// RedirectUrl returns the redirect location for the remaining request path
+// after the redirect source has been removed.
+func (r Redirect) RedirectUrl(scheme, reqPath string) *url.URL {
 	// split the host and path
 	host, p := utils.SplitHostPath(r.Dst)
 
 	// if not Abs then join with the ending of the current path
 	if !r.Flags.HasFlag(FlagAbs) {
-		p = path.Join(p, req.URL.Path)
+		p = path.Join(p, reqPath)
 
 		// replace the trailing slash that path.Join() strips off
-		if strings.HasSuffix(req.URL.Path, "/") {
+		if strings.HasSuffix(reqPath, "/") {
 			p += "/"
 		}
 	}
@@ -66,11 +62,24 @@ func (r Redirect) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// create a new URL
-	u := &url.URL{
-		Scheme: req.URL.Scheme,
+	return &url.URL{
+		Scheme: scheme,
 		Host:   host,
 		Path:   p,
 	}
+}
+
+// ServeHTTP responds with the redirect to the response writer provided.
+func (r Redirect) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	utils.GetRequestInfo(req).Route = r.Src
+
+	// default to redirecting with StatusFound if code is not set
+	code := r.Code
+	if r.Code == 0 {
+		code = http.StatusFound
+	}
+
+	u := r.RedirectUrl(req.URL.Scheme, req.URL.Path)
 
 	// close the incoming body after use
 	if req.Body != nil {
@@ -3,19 +3,28 @@ package target
 import (
 	"fmt"
 	"github.com/1f349/violet/logger"
+	"github.com/1f349/violet/metrics"
 	"github.com/1f349/violet/proxy"
+	"github.com/1f349/violet/proxy/websocket"
+	"github.com/1f349/violet/tracing"
 	"github.com/1f349/violet/utils"
 	websocket2 "github.com/gorilla/websocket"
 	"github.com/rs/cors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/http/httpguts"
 	"io"
 	"maps"
 	"net"
 	"net/http"
-	"net/textproto"
+	"net/http/httptrace"
 	"net/url"
 	"path"
 	"strings"
+	"time"
 )
 
 var Logger = logger.Logger.WithPrefix("Violet Serve Route")
@@ -40,12 +49,14 @@ var serveApiCors = cors.New(cors.Options{
 // Route is a target used by the router to manage forwarding traffic to an
 // internal server using the specified configuration.
 type Route struct {
-	Src     string                 `json:"src"`   // request source
-	Dst     string                 `json:"dst"`   // proxy destination
-	Desc    string                 `json:"desc"`  // description for admin panel use
-	Flags   Flags                  `json:"flags"` // extra flags
-	Headers http.Header            `json:"-"`     // extra headers
-	Proxy   *proxy.HybridTransport `json:"-"`     // reverse proxy handler
+	Src            string                 `json:"src"`              // request source
+	Dst            string                 `json:"dst"`              // proxy destination
+	Desc           string                 `json:"desc"`             // description for admin panel use
+	Flags          Flags                  `json:"flags"`            // extra flags
+	WebsocketLimit int64                  `json:"websocket_limit"`  // maximum concurrent websocket connections, zero is unlimited
+	Labels         Labels                 `json:"labels,omitempty"` // labels for grouping routes
+	Headers        http.Header            `json:"-"`                // extra headers
+	Proxy          *proxy.HybridTransport `json:"-"`                // reverse proxy handler
 }
 
 type RouteWithActive struct {
@@ -85,9 +96,9 @@ func (r Route) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// internalServeHTTP is an internal method which handles configuring the request
-// for the reverse proxy handler.
-func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
+// UpstreamUrl returns the url of the internal server for the remaining request
+// path after the route source has been removed.
+func (r Route) UpstreamUrl(reqPath, rawQuery string) *url.URL {
 	// set the scheme and port using defaults if the port is 0
 	scheme := "http"
 	if r.HasFlag(FlagSecureMode) {
@@ -99,10 +110,10 @@ func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 	// if not Abs then join with the ending of the current path
 	if !r.HasFlag(FlagAbs) {
-		p = path.Join(p, req.URL.Path)
+		p = path.Join(p, reqPath)
 
 		// replace the trailing slash that path.Join() strips off
-		if strings.HasSuffix(req.URL.Path, "/") {
+		if strings.HasSuffix(reqPath, "/") {
 			p += "/"
 		}
 	}
@@ -113,18 +124,33 @@ func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	// create a new URL
-	u := &url.URL{
+	return &url.URL{
 		Scheme:   scheme,
 		Host:     host,
 		Path:     p,
-		RawQuery: req.URL.RawQuery,
+		RawQuery: rawQuery,
 	}
+}
+
+// internalServeHTTP is an internal method which handles configuring the request
+// for the reverse proxy handler.
+func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	info := utils.GetRequestInfo(req)
+	info.Route = r.Src
+	trace.SpanFromContext(req.Context()).AddEvent("route matched", trace.WithAttributes(
+		attribute.String("violet.route.src", r.Src),
+		attribute.String("violet.route.dst", r.Dst),
+	))
+
+	u := r.UpstreamUrl(req.URL.Path, req.URL.RawQuery)
 
 	// close the incoming body after use
 	if req.Body != nil {
 		defer req.Body.Close()
 	}
 
+	info.Upstream = u.String()
+
 	// create the internal request
 	req2, err := http.NewRequest(req.Method, u.String(), req.Body)
 	if err != nil {
@@ -142,6 +168,10 @@ func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		req2.Header[k] = v
 	}
 
+	// remove the hop-by-hop headers before adding the route headers so a
+	// client can't remove them by listing them in the Connection header
+	utils.RemoveHopByHopHeaders(req2.Header)
+
 	// if extra route headers are set
 	if r.Headers != nil {
 		// loop over headers
@@ -159,24 +189,57 @@ func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	req2.Header.Set("X-Violet-Loop-Detect", "1")
+
 	// switch to websocket handler
 	// internally the http hijack method is called
 	if r.HasFlag(FlagWebsocket) && websocket2.IsWebSocketUpgrade(req2) {
-		r.Proxy.ConnectWebsocket(rw, req2)
+		ctx, span := tracing.Tracer().Start(req.Context(), "websocket upgrade", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(semconv.URLFull(u.String())))
+		defer span.End()
+		tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(req2.Header))
+
+		d := websocket.Details{
+			Route:  r.Src,
+			Host:   req.Host,
+			Remote: req.RemoteAddr,
+			Limit:  r.WebsocketLimit,
+			OnHandshake: func(status int) {
+				// the span only covers the handshake, not the connection
+				info.UpstreamStatus = status
+				endSpan(span, status)
+			},
+		}
+		if r.HasFlag(FlagWebsocketTunnel) {
+			r.Proxy.TunnelWebsocket(rw, req2, d, r.HasFlag(FlagIgnoreCert))
+			return
+		}
+		r.Proxy.ConnectWebsocket(rw, req2, d)
 		return
 	}
 
-	req2.Header.Set("X-Violet-Loop-Detect", "1")
-
 	// serve request with reverse proxy
+	upstreamStart := time.Now()
+	info.RouteTime = upstreamStart.Sub(info.Start)
+	ctx, span := tracing.Tracer().Start(req.Context(), "upstream "+req2.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String(req2.Method),
+		semconv.URLFull(u.String()),
+	))
+	tracing.Propagator.Inject(ctx, propagation.HeaderCarrier(req2.Header))
+	timing := &upstreamTiming{start: upstreamStart}
+	req2 = req2.WithContext(httptrace.WithClientTrace(req2.Context(), timing.clientTrace()))
 	var resp *http.Response
 	if r.HasFlag(FlagIgnoreCert) {
 		resp, err = r.Proxy.InsecureRoundTrip(req2)
 	} else {
 		resp, err = r.Proxy.SecureRoundTrip(req2)
 	}
+	info.UpstreamTime = time.Since(upstreamStart)
 	if err != nil {
-		Logger.Warn("Error receiving internal round trip response", "route src", r.Src, "url", req2.URL.String(), "err", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "round trip failed")
+		span.End()
+		Logger.Warn("Error receiving internal round trip response", "route src", r.Src, "url", req2.URL.String(), "request id", info.RequestId, "err", err)
+		metrics.UpstreamErrors.WithLabelValues(r.Dst).Inc()
 		utils.RespondVioletError(rw, http.StatusBadGateway, "Error receiving internal round trip response")
 		return
 	}
@@ -185,15 +248,23 @@ func (r Route) internalServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	if resp.Body != nil {
 		defer resp.Body.Close()
 	}
+	info.UpstreamStatus = resp.StatusCode
+	endSpan(span, resp.StatusCode)
 
 	if resp.StatusCode == http.StatusLoopDetected {
-		Logger.Warn("Loop Detected", "method", req.Method, "url", req.URL, "url2", req2.URL.String())
+		Logger.Warn("Loop Detected", "method", req.Method, "url", req.URL, "url2", req2.URL.String(), "request id", info.RequestId)
 		utils.RespondVioletError(rw, http.StatusLoopDetected, "Error loop detected")
 		return
 	}
 
 	// copy headers and status code
+	utils.RemoveHopByHopHeaders(resp.Header)
 	copyHeader(rw.Header(), resp.Header)
+	if info.RequestId != "" {
+		// the upstream may echo the request id back
+		rw.Header().Set("X-Request-Id", info.RequestId)
+	}
+	r.writeTimingHeaders(rw.Header(), timing, info)
 	rw.WriteHeader(resp.StatusCode)
 
 	// copy body
@@ -217,25 +288,26 @@ func (r Route) internalReverseProxyMeta(rw http.ResponseWriter, req, req2 *http.
 		req2.Header = make(http.Header) // Issue 33142: historical behavior was to always allocate
 	}
 
-	reqUpType := upgradeType(req2.Header)
+	// the hop-by-hop headers are already removed from req2
+	reqUpType := utils.UpgradeType(req.Header)
 	if !asciiIsPrint(reqUpType) {
 		utils.RespondVioletError(rw, http.StatusBadRequest, fmt.Sprintf("Invalid protocol %s", reqUpType))
 		return true
 	}
-	removeHopByHopHeaders(req2.Header)
 
 	// Issue 21096: tell backend applications that care about trailer support
 	// that we support trailers. (We do, but we don't go out of our way to
 	// advertise that unless the incoming client request thought it was worth
 	// mentioning.) Note that we look at req.Header, not outreq.Header, since
-	// the latter has passed through removeHopByHopHeaders.
+	// the latter has passed through RemoveHopByHopHeaders.
 	if httpguts.HeaderValuesContainsToken(req.Header["Te"], "trailers") {
 		req2.Header.Set("Te", "trailers")
 	}
 
-	// After stripping all the hop-by-hop connection headers above, add back any
-	// necessary for protocol upgrades, such as for websockets.
-	if reqUpType != "" {
+	// After stripping all the hop-by-hop connection headers above, add back the
+	// websocket upgrade for websocket routes. Other upgrades can't be proxied so
+	// are sent as normal requests.
+	if r.HasFlag(FlagWebsocket) && strings.EqualFold(reqUpType, "websocket") {
 		req2.Header.Set("Connection", "Upgrade")
 		req2.Header.Set("Upgrade", reqUpType)
 	}
@@ -259,6 +331,15 @@ func (r Route) internalReverseProxyMeta(rw http.ResponseWriter, req, req2 *http.
 	return false
 }
 
+// endSpan records the upstream status code and ends the span.
+func endSpan(span trace.Span, status int) {
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
 // String outputs a debug string for the route.
 func (r Route) String() string {
 	return fmt.Sprintf("%#v", r)
@@ -273,14 +354,6 @@ func copyHeader(dst, src http.Header) {
 	}
 }
 
-// updateType returns the value of upgrade from http.Header
-func upgradeType(h http.Header) string {
-	if !httpguts.HeaderValuesContainsToken(h["Connection"], "Upgrade") {
-		return ""
-	}
-	return h.Get("Upgrade")
-}
-
 // IsPrint returns whether s is ASCII and printable according to
 // https://tools.ietf.org/html/rfc20#section-4.2.
 func asciiIsPrint(s string) bool {
@@ -291,38 +364,3 @@ func asciiIsPrint(s string) bool {
 	}
 	return true
 }
-
-// Hop-by-hop headers. These are removed when sent to the backend.
-// As of RFC 7230, hop-by-hop headers are required to appear in the
-// Connection header field. These are the headers defined by the
-// obsoleted RFC 2616 (section 13.5.1) and are used for backward
-// compatibility.
-var hopHeaders = []string{
-	"Connection",
-	"Proxy-Connection", // non-standard but still sent by libcurl and rejected by e.g. google
-	"Keep-Alive",
-	"Proxy-Authenticate",
-	"Proxy-Authorization",
-	"Te",      // canonicalized version of "TE"
-	"Trailer", // not Trailers per URL above; https://www.rfc-editor.org/errata_search.php?eid=4522
-	"Transfer-Encoding",
-	"Upgrade",
-}
-
-// removeHopByHopHeaders removes the hop-by-hop headers defined in hopHeaders
-func removeHopByHopHeaders(h http.Header) {
-	// RFC 7230, section 6.1: Remove headers listed in the "Connection" header.
-	for _, f := range h["Connection"] {
-		for sf := range strings.SplitSeq(f, ",") {
-			if sf = textproto.TrimString(sf); sf != "" {
-				h.Del(sf)
-			}
-		}
-	}
-	// RFC 2616, section 13.5.1: Remove a set of known hop-by-hop headers.
-	// This behavior is superseded by the RFC 7230 Connection header, but
-	// preserve it for backwards compatibility.
-	for _, f := range hopHeaders {
-		h.Del(f)
-	}
-}
@@ -4,12 +4,21 @@ import (
 	"bytes"
 	"github.com/1f349/violet/proxy"
 	"github.com/1f349/violet/proxy/websocket"
+	"github.com/1f349/violet/tracing"
+	"github.com/1f349/violet/utils"
+	websocket2 "github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 type proxyTester struct {
@@ -78,6 +87,30 @@ func TestRoute_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestRoute_ServeHTTP_HopHeaders(t *testing.T) {
+	for _, flags := range []Flags{0, FlagWebsocket} {
+		pt := &proxyTester{}
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "https://www.example.com/test", nil)
+		req.Header.Set("Connection", "keep-alive, Upgrade, X-Secret, X-Other")
+		req.Header.Set("Keep-Alive", "timeout=5")
+		req.Header.Set("Upgrade", "h2c")
+		req.Header.Set("Proxy-Authorization", "Basic abc")
+		req.Header.Set("X-Secret", "hidden")
+		req.Header.Set("X-Other", "hidden")
+		req.Header.Set("X-Kept", "yes")
+		i := &Route{Dst: "1.1.1.1:8080", Flags: flags, Headers: http.Header{"X-Other": {"route"}}, Proxy: pt.makeHybridTransport()}
+		i.ServeHTTP(res, req)
+
+		assert.True(t, pt.got)
+		for _, k := range []string{"Connection", "Keep-Alive", "Upgrade", "Proxy-Authorization", "X-Secret"} {
+			assert.Empty(t, pt.req.Header.Values(k), k)
+		}
+		assert.Equal(t, "route", pt.req.Header.Get("X-Other"))
+		assert.Equal(t, "yes", pt.req.Header.Get("X-Kept"))
+	}
+}
+
 func TestRoute_ServeHTTP_Cors(t *testing.T) {
 	pt := &proxyTester{}
 	res := httptest.NewRecorder()
@@ -112,3 +145,113 @@ func TestRoute_ServeHTTP_Body(t *testing.T) {
 	assert.Equal(t, 0, bytes.Compare(all, []byte{0x54}))
 	assert.NoError(t, pt.req.Body.Close())
 }
+
+func TestRoute_ServeHTTP_Tracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(prev)
+
+	pt := &proxyTester{}
+	res := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "https://www.example.com/test", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "vendor=value")
+	ctx := tracing.Propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	i := &Route{Dst: "1.1.1.1:8080/hello", Proxy: pt.makeHybridTransport()}
+	i.ServeHTTP(res, req.WithContext(ctx))
+
+	spans := recorder.Ended()
+	if !assert.Len(t, spans, 1) {
+		return
+	}
+	span := spans[0]
+	assert.Equal(t, "upstream GET", span.Name())
+	assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+
+	// the upstream receives the upstream span as the parent
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+span.SpanContext().SpanID().String()+"-01", pt.req.Header.Get("Traceparent"))
+	assert.Equal(t, "vendor=value", pt.req.Header.Get("Tracestate"))
+}
+
+func TestRoute_ServeHTTP_ServerTiming(t *testing.T) {
+	internal := httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("X-Violet-Upstream-Time", "0.000")
+		rw.WriteHeader(http.StatusOK)
+	}))
+	defer internal.Close()
+
+	i := &Route{Dst: internal.Listener.Addr().String(), Flags: FlagAbs | FlagSecureMode | FlagIgnoreCert | FlagServerTiming, Proxy: proxy.NewHybridTransport(websocket.NewServer())}
+
+	res := httptest.NewRecorder()
+	req, info := utils.WithRequestInfo(httptest.NewRequest(http.MethodGet, "https://www.example.com/test", nil))
+	i.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	timing := res.Header().Get("Server-Timing")
+	assert.Regexp(t, `^router;dur=[0-9.]+, connect;dur=[0-9.]+, tls;dur=[0-9.]+, ttfb;dur=[0-9.]+$`, timing)
+	assert.Greater(t, info.UpstreamTime, time.Duration(0))
+
+	// untrusted clients don't receive the upstream time
+	assert.Equal(t, "", res.Header().Get("X-Violet-Upstream-Time"))
+
+	// trusted clients receive the upstream time measured by violet
+	res = httptest.NewRecorder()
+	req, info = utils.WithRequestInfo(httptest.NewRequest(http.MethodGet, "https://www.example.com/test", nil))
+	info.TrustTiming = true
+	i.ServeHTTP(res, req)
+	assert.Regexp(t, `^[0-9]+\.[0-9]{3}$`, res.Header().Get("X-Violet-Upstream-Time"))
+	assert.NotEqual(t, "0.000", res.Header().Get("X-Violet-Upstream-Time"))
+
+	// the header is only added when enabled for the route
+	i.Flags &^= FlagServerTiming
+	res = httptest.NewRecorder()
+	i.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "https://www.example.com/test", nil))
+	assert.Equal(t, "", res.Header().Get("Server-Timing"))
+}
+
+func TestRoute_ServeHTTP_WebsocketTunnel(t *testing.T) {
+	internalUpgrader := websocket2.Upgrader{EnableCompression: true}
+	internalHandler := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		c, err := internalUpgrader.Upgrade(rw, req, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer c.Close()
+		mt, p, err := c.ReadMessage()
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NoError(t, c.WriteMessage(mt, p))
+	})
+
+	for _, secure := range []bool{false, true} {
+		var internal *httptest.Server
+		flags := FlagWebsocket | FlagWebsocketTunnel
+		if secure {
+			internal = httptest.NewTLSServer(internalHandler)
+			flags |= FlagSecureMode | FlagIgnoreCert
+		} else {
+			internal = httptest.NewServer(internalHandler)
+		}
+
+		i := &Route{Dst: internal.Listener.Addr().String(), Flags: flags, Proxy: proxy.NewHybridTransport(websocket.NewServer())}
+		srv := httptest.NewServer(i)
+
+		dialer := websocket2.Dialer{EnableCompression: true}
+		c, resp, err := dialer.Dial(strings.Replace(srv.URL, "http://", "ws://", 1), nil)
+		if assert.NoError(t, err) {
+			// the compression extension is negotiated with the internal server
+			assert.Contains(t, resp.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+
+			assert.NoError(t, c.WriteMessage(websocket2.TextMessage, []byte("hello")))
+			mt, p, err := c.ReadMessage()
+			assert.NoError(t, err)
+			assert.Equal(t, websocket2.TextMessage, mt)
+			assert.Equal(t, "hello", string(p))
+			_ = c.Close()
+		}
+
+		srv.Close()
+		internal.Close()
+	}
+}
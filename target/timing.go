@@ -0,0 +1,102 @@
+package target
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/1f349/violet/utils"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// upstreamTiming collects the timing of an upstream round trip, connect and TLS
+// durations are zero when an idle connection was reused. The trace hooks run on
+// transport goroutines, sometimes concurrently, so the fields are guarded by
+// the mutex.
+type upstreamTiming struct {
+	start time.Time
+
+	mu           sync.Mutex
+	connectStart time.Time
+	connect      time.Duration
+	tlsStart     time.Time
+	tls          time.Duration
+	firstByte    time.Duration
+}
+
+// clientTrace returns the httptrace hooks which fill in the timing.
+func (u *upstreamTiming) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		ConnectStart: func(string, string) {
+			u.mu.Lock()
+			u.connectStart = time.Now()
+			u.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			u.mu.Lock()
+			u.connect = time.Since(u.connectStart)
+			u.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			u.mu.Lock()
+			u.tlsStart = time.Now()
+			u.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			u.mu.Lock()
+			u.tls = time.Since(u.tlsStart)
+			u.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			u.mu.Lock()
+			u.firstByte = time.Since(u.start)
+			u.mu.Unlock()
+		},
+	}
+}
+
+// serverTiming formats the Server-Timing header value.
+func (u *upstreamTiming) serverTiming(lookup time.Duration) string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var b strings.Builder
+	writeTimingMetric(&b, "router", lookup)
+	if u.connect > 0 {
+		writeTimingMetric(&b, "connect", u.connect)
+	}
+	if u.tls > 0 {
+		writeTimingMetric(&b, "tls", u.tls)
+	}
+	writeTimingMetric(&b, "ttfb", u.firstByte)
+	return b.String()
+}
+
+func writeTimingMetric(b *strings.Builder, name string, d time.Duration) {
+	if b.Len() > 0 {
+		b.WriteString(", ")
+	}
+	b.WriteString(name)
+	b.WriteString(";dur=")
+	b.WriteString(formatMillis(d))
+}
+
+// formatMillis outputs the duration in milliseconds as used by Server-Timing.
+func formatMillis(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+}
+
+// writeTimingHeaders adds the Server-Timing header when enabled for the route
+// and the X-Violet-Upstream-Time header for trusted clients.
+func (r Route) writeTimingHeaders(header http.Header, u *upstreamTiming, info *utils.RequestInfo) {
+	if r.HasFlag(FlagServerTiming) {
+		header.Add("Server-Timing", u.serverTiming(info.LookupTime))
+	}
+	if info.TrustTiming {
+		header.Set("X-Violet-Upstream-Time", formatMillis(info.UpstreamTime))
+	} else {
+		// don't leak an upstream value to untrusted clients
+		header.Del("X-Violet-Upstream-Time")
+	}
+}
@@ -0,0 +1,36 @@
+package target
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpstreamTiming_Concurrent(t *testing.T) {
+	u := &upstreamTiming{start: time.Now()}
+	trace := u.clientTrace()
+
+	// concurrent dials call the hooks from several goroutines while the
+	// handler formats the header
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			trace.ConnectStart("tcp", "127.0.0.1:443")
+			trace.ConnectDone("tcp", "127.0.0.1:443", nil)
+			trace.TLSHandshakeStart()
+			trace.TLSHandshakeDone(tls.ConnectionState{}, nil)
+			trace.GotFirstResponseByte()
+		})
+		wg.Go(func() {
+			_ = u.serverTiming(time.Millisecond)
+		})
+	}
+	wg.Wait()
+
+	timing := u.serverTiming(time.Millisecond)
+	assert.True(t, strings.HasPrefix(timing, "router;dur=1.000, "), timing)
+	assert.Contains(t, timing, "ttfb;dur=")
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"github.com/1f349/violet/logger"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.41.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/url"
+)
+
+var Logger = logger.Logger.WithPrefix("Violet Tracing")
+
+// Propagator reads and writes the W3C traceparent and tracestate headers.
+var Propagator propagation.TextMapPropagator = propagation.TraceContext{}
+
+// Tracer returns the tracer used for proxy spans, spans are not recorded
+// unless Setup has been called.
+func Tracer() trace.Tracer {
+	return otel.Tracer("github.com/1f349/violet")
+}
+
+// Options configures the span exporter.
+type Options struct {
+	Endpoint    string            // OTLP/HTTP collector url, the path defaults to /v1/traces
+	Headers     map[string]string // extra headers sent to the collector
+	ServiceName string            // defaults to violet
+	SampleRatio float64           // ratio of new traces to sample, defaults to 1
+}
+
+// Setup creates a tracer provider which exports spans over OTLP/HTTP to the
+// collector endpoint and sets it as the global tracer provider. The returned
+// function flushes the remaining spans and stops the exporter.
+func Setup(opts Options) (func(ctx context.Context) error, error) {
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tracing endpoint: %w", err)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = "/v1/traces"
+	}
+
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(u.String()),
+		otlptracehttp.WithHeaders(opts.Headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create span exporter: %w", err)
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = "violet"
+	}
+	sampleRatio := opts.SampleRatio
+	if sampleRatio <= 0 {
+		sampleRatio = 1
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		Logger.Warn("Tracing error", "err", err)
+	}))
+	Logger.Info("Exporting spans", "endpoint", u.String())
+	return provider.Shutdown, nil
+}
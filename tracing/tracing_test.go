@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// collectorStub is a minimal OTLP/HTTP collector which stores the names of
+// the received spans
+func collectorStub(t *testing.T, names chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, "/v1/traces", req.URL.Path)
+		assert.Equal(t, "abc123", req.Header.Get("X-Collector-Token"))
+		raw, err := io.ReadAll(req.Body)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var export collectortrace.ExportTraceServiceRequest
+		if !assert.NoError(t, proto.Unmarshal(raw, &export)) {
+			return
+		}
+		for _, rs := range export.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					names <- span.Name
+				}
+			}
+		}
+		rw.Header().Set("Content-Type", "application/x-protobuf")
+		rw.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestSetup(t *testing.T) {
+	names := make(chan string, 10)
+	srv := collectorStub(t, names)
+	defer srv.Close()
+
+	prev := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prev)
+
+	stop, err := Setup(Options{
+		Endpoint: srv.URL,
+		Headers:  map[string]string{"X-Collector-Token": "abc123"},
+	})
+	assert.NoError(t, err)
+
+	_, span := Tracer().Start(context.Background(), "test span")
+	span.End()
+
+	// flush the span to the collector
+	assert.NoError(t, stop(context.Background()))
+	close(names)
+	var got []string
+	for name := range names {
+		got = append(got, name)
+	}
+	assert.Equal(t, []string{"test span"}, got)
+}
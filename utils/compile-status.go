@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CompileStatus is the result of the last compile of a Compilable.
+type CompileStatus struct {
+	Name     string    `json:"name"`
+	LastRun  time.Time `json:"last_run"` // zero if the compile has never run
+	Duration float64   `json:"duration"` // seconds
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+	Items    int       `json:"items"` // number of items loaded
+}
+
+// CompileReporter is a Compilable which records the result of each compile.
+type CompileReporter interface {
+	Compilable
+	CompileStatus() CompileStatus
+	OnFinish(fn func(CompileStatus))
+	WaitCompile(ctx context.Context, since time.Time) (CompileStatus, error)
+}
+
+// CompileTracker records the result of each compile and releases callers
+// waiting for a compile to finish. It is embedded into each Compilable.
+type CompileTracker struct {
+	mu     *sync.Mutex
+	status CompileStatus
+	done   chan struct{}
+	notify []func(CompileStatus)
+}
+
+// NewCompileTracker creates a tracker for the named Compilable
+func NewCompileTracker(name string) *CompileTracker {
+	return &CompileTracker{
+		mu:     &sync.Mutex{},
+		status: CompileStatus{Name: name},
+		done:   make(chan struct{}),
+	}
+}
+
+// FinishCompile records a compile which started at start and loaded the
+// number of items
+func (c *CompileTracker) FinishCompile(start time.Time, items int, err error) {
+	c.mu.Lock()
+	status, notify := c.finish(start, items, err), c.notify
+	c.mu.Unlock()
+
+	for _, fn := range notify {
+		fn(status)
+	}
+}
+
+// OnFinish adds a function called with the result of each compile
+func (c *CompileTracker) OnFinish(fn func(CompileStatus)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.notify = append(c.notify, fn)
+}
+
+// finish must be called with the lock held
+func (c *CompileTracker) finish(start time.Time, items int, err error) CompileStatus {
+	c.status.LastRun = start
+	c.status.Duration = time.Since(start).Seconds()
+	c.status.Success = err == nil
+	c.status.Error = ""
+	if err != nil {
+		c.status.Error = err.Error()
+	} else {
+		c.status.Items = items
+	}
+
+	// release the waiting callers
+	close(c.done)
+	c.done = make(chan struct{})
+	return c.status
+}
+
+// CompileStatus returns the result of the last compile
+func (c *CompileTracker) CompileStatus() CompileStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.status
+}
+
+// WaitCompile blocks until a compile which started at or after since has
+// finished. If the context ends first the returned status is marked as failed
+// so the result of an older compile isn't reported.
+func (c *CompileTracker) WaitCompile(ctx context.Context, since time.Time) (CompileStatus, error) {
+	for {
+		c.mu.Lock()
+		status, done := c.status, c.done
+		c.mu.Unlock()
+		if !status.LastRun.IsZero() && !status.LastRun.Before(since) {
+			return status, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return CompileStatus{Name: status.Name, Error: "timed out"}, ctx.Err()
+		case <-done:
+		}
+	}
+}
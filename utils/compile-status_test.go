@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCompileTracker_FinishCompile(t *testing.T) {
+	c := NewCompileTracker("test")
+	assert.Equal(t, CompileStatus{Name: "test"}, c.CompileStatus())
+
+	start := time.Now()
+	c.FinishCompile(start, 5, nil)
+	status := c.CompileStatus()
+	assert.Equal(t, start, status.LastRun)
+	assert.True(t, status.Success)
+	assert.Equal(t, 5, status.Items)
+	assert.Equal(t, "", status.Error)
+
+	// a failed compile keeps the previous items
+	c.FinishCompile(time.Now(), 0, errors.New("bad"))
+	status = c.CompileStatus()
+	assert.False(t, status.Success)
+	assert.Equal(t, 5, status.Items)
+	assert.Equal(t, "bad", status.Error)
+}
+
+func TestCompileTracker_WaitCompile(t *testing.T) {
+	c := NewCompileTracker("test")
+	c.FinishCompile(time.Now().Add(-time.Minute), 1, nil)
+
+	// the earlier compile doesn't count
+	since := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	status, err := c.WaitCompile(ctx, since)
+	cancel()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, CompileStatus{Name: "test", Error: "timed out"}, status)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		c.FinishCompile(since, 2, nil)
+	}()
+	status, err = c.WaitCompile(context.Background(), since)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, status.Items)
+}
+
+func TestCompileTracker_OnFinish(t *testing.T) {
+	c := NewCompileTracker("test")
+	var got []CompileStatus
+	c.OnFinish(func(status CompileStatus) { got = append(got, status) })
+	c.FinishCompile(time.Now(), 3, nil)
+	c.FinishCompile(time.Now(), 0, errors.New("bad"))
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, 3, got[0].Items)
+		assert.Equal(t, "bad", got[1].Error)
+	}
+}
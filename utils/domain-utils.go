@@ -70,6 +70,14 @@ func GetTopFqdn(domain string) (string, bool) {
 	return out, err == nil
 }
 
+// IsDomainOrSubdomain returns true if the host matches the domain or is any
+// subdomain of it.
+//
+// www.example.com, example.com => true
+func IsDomainOrSubdomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
 // SplitHostPath extracts the host/path from the input
 func SplitHostPath(a string) (host, path string) {
 	// check if source has path
@@ -65,6 +65,14 @@ func TestGetTopFqdn(t *testing.T) {
 	assert.Equal(t, "example.com", domain)
 }
 
+func TestIsDomainOrSubdomain(t *testing.T) {
+	assert.True(t, IsDomainOrSubdomain("example.com", "example.com"))
+	assert.True(t, IsDomainOrSubdomain("www.example.com", "example.com"))
+	assert.True(t, IsDomainOrSubdomain("*.example.com", "example.com"))
+	assert.False(t, IsDomainOrSubdomain("notexample.com", "example.com"))
+	assert.False(t, IsDomainOrSubdomain("example.com", "www.example.com"))
+}
+
 func TestSplitHostPath(t *testing.T) {
 	h, p := SplitHostPath("example.com/hello/world")
 	assert.Equal(t, "example.com", h)
@@ -1,6 +1,9 @@
 package fake
 
-import "github.com/1f349/violet/utils"
+import (
+	"github.com/1f349/violet/utils"
+	"time"
+)
 
 // Compilable implements utils.Compilable and stores if the Compile function
 // is called.
@@ -9,3 +12,23 @@ type Compilable struct{ Done bool }
 func (f *Compilable) Compile() { f.Done = true }
 
 var _ utils.Compilable = &Compilable{}
+
+// ReportingCompilable implements utils.CompileReporter and finishes each
+// compile in the background with Items and Err.
+type ReportingCompilable struct {
+	*utils.CompileTracker
+	Items int
+	Err   error
+}
+
+func NewReportingCompilable(name string) *ReportingCompilable {
+	return &ReportingCompilable{CompileTracker: utils.NewCompileTracker(name)}
+}
+
+func (f *ReportingCompilable) Compile() {
+	start := time.Now()
+	items, err := f.Items, f.Err
+	go f.FinishCompile(start, items, err)
+}
+
+var _ utils.CompileReporter = &ReportingCompilable{}
@@ -27,9 +27,11 @@ func GenSnakeOilProv() *mjwt.Issuer {
 	return issuer
 }
 
-func GenSnakeOilKey(perm string) string {
+func GenSnakeOilKey(perms ...string) string {
 	p := auth.NewPermStorage()
-	p.Set(perm)
+	for _, perm := range perms {
+		p.Set(perm)
+	}
 	val, err := SnakeOilProv.GenerateJwt("abc", "abc", nil, 5*time.Minute, auth.AccessTokenClaims{Perms: p})
 	if err != nil {
 		panic(err)
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	h := http.Header{
+		"Connection":          {"keep-alive, X-Secret"},
+		"Keep-Alive":          {"timeout=5"},
+		"Proxy-Authorization": {"Basic abc"},
+		"Upgrade":             {"websocket"},
+		"X-Secret":            {"hidden"},
+		"X-Kept":              {"yes"},
+	}
+	RemoveHopByHopHeaders(h)
+	assert.Equal(t, http.Header{"X-Kept": {"yes"}}, h)
+}
+
+func TestUpgradeType(t *testing.T) {
+	assert.Equal(t, "websocket", UpgradeType(http.Header{"Connection": {"keep-alive, Upgrade"}, "Upgrade": {"websocket"}}))
+	assert.Equal(t, "", UpgradeType(http.Header{"Upgrade": {"websocket"}}))
+}
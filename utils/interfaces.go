@@ -17,5 +17,6 @@ type AcmeChallengeProvider interface {
 
 type CertProvider interface {
 	GetCertForDomain(domain string) *tls.Certificate
+	CountCertsForDomain(domain string) int
 	Compile()
 }
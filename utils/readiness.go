@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Readiness reports whether the server should receive new traffic, the zero
+// value is ready. A nil Readiness is always ready.
+type Readiness struct {
+	draining atomic.Bool
+}
+
+// Drain marks the server as not ready so load balancers stop sending new
+// requests before the servers shut down.
+func (r *Readiness) Drain() {
+	r.draining.Store(true)
+}
+
+// IsReady returns false once Drain has been called.
+func (r *Readiness) IsReady() bool {
+	return r == nil || !r.draining.Load()
+}
+
+// ServeHTTP responds with 200 when ready and 503 while draining.
+func (r *Readiness) ServeHTTP(rw http.ResponseWriter, _ *http.Request) {
+	rw.Header().Set("Cache-Control", "no-store")
+	if !r.IsReady() {
+		http.Error(rw, "Not Ready", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(rw, "Ready", http.StatusOK)
+}
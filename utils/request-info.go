@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// RequestInfo contains details about how a request was handled, the router and
+// targets fill in the fields so middleware can report them after the response
+// has been written.
+type RequestInfo struct {
+	Start          time.Time     // time the request was received
+	RequestId      string        // value of the X-Request-Id header
+	Route          string        // source of the matched route or redirect
+	Upstream       string        // destination url of the proxied request
+	UpstreamStatus int           // status code returned by the upstream
+	LookupTime     time.Duration // time taken by the router to find the target
+	RouteTime      time.Duration // time taken before sending the upstream request
+	UpstreamTime   time.Duration // time waiting for the upstream response headers
+	TrustTiming    bool          // the client may receive the X-Violet-Upstream-Time header
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a copy of the request with an empty RequestInfo
+// attached to the context. If the request already has a RequestInfo then the
+// request is returned unchanged with the existing RequestInfo.
+func WithRequestInfo(req *http.Request) (*http.Request, *RequestInfo) {
+	if info, ok := req.Context().Value(requestInfoKey{}).(*RequestInfo); ok {
+		return req, info
+	}
+	info := &RequestInfo{Start: time.Now()}
+	return req.WithContext(context.WithValue(req.Context(), requestInfoKey{}, info)), info
+}
+
+// GetRequestInfo returns the RequestInfo attached to the request, if missing a
+// new RequestInfo is returned so the fields can always be set.
+func GetRequestInfo(req *http.Request) *RequestInfo {
+	if info, ok := req.Context().Value(requestInfoKey{}).(*RequestInfo); ok {
+		return info
+	}
+	return &RequestInfo{Start: time.Now()}
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetRequestInfo(t *testing.T) {
+	req := httptest.NewRequest("GET", "https://example.com", nil)
+	GetRequestInfo(req).Route = "example.com"
+
+	req, info := WithRequestInfo(req)
+	assert.Equal(t, "", info.Route)
+	assert.False(t, info.Start.IsZero())
+	GetRequestInfo(req).Route = "example.com"
+	assert.Equal(t, "example.com", info.Route)
+
+	// the existing info is reused
+	req2, info2 := WithRequestInfo(req)
+	assert.Same(t, req, req2)
+	assert.Same(t, info, info2)
+}
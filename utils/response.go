@@ -10,7 +10,14 @@ func RespondHttpStatus(rw http.ResponseWriter, status int) {
 	http.Error(rw, fmt.Sprintf("%d %s", status, http.StatusText(status)), status)
 }
 
+// RespondVioletError outputs the status code and text with the error message in
+// the X-Violet-Error header, the request ID is included in the body if the
+// X-Request-Id response header has been set.
 func RespondVioletError(rw http.ResponseWriter, status int, msg string) {
 	rw.Header().Set("X-Violet-Error", msg)
+	if id := rw.Header().Get("X-Request-Id"); id != "" {
+		http.Error(rw, fmt.Sprintf("%d %s\nRequest ID: %s", status, http.StatusText(status), id), status)
+		return
+	}
 	RespondHttpStatus(rw, status)
 }
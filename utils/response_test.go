@@ -30,3 +30,14 @@ func TestRespondVioletError(t *testing.T) {
 	assert.Equal(t, "418 I'm a teapot\n", string(a))
 	assert.Equal(t, "Hidden Error Message", res.Header.Get("X-Violet-Error"))
 }
+
+func TestRespondVioletError_RequestId(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.Header().Set("X-Request-Id", "abc123")
+	RespondVioletError(rec, http.StatusTeapot, "Hidden Error Message")
+	res := rec.Result()
+	assert.Equal(t, http.StatusTeapot, res.StatusCode)
+	a, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "418 I'm a teapot\nRequest ID: abc123\n", string(a))
+}
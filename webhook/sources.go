@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/certs"
+	"github.com/1f349/violet/proxy"
+	"github.com/1f349/violet/utils"
+	"sync"
+	"time"
+)
+
+// WatchAudit sends the routes and redirects created or deleted through the
+// API
+func (d *Dispatcher) WatchAudit(l *audit.Log) {
+	if d == nil {
+		return
+	}
+	l.OnRecord(func(e audit.Entry) {
+		switch {
+		case e.Type == "route" && e.Action == "create":
+			d.Send(EventRouteCreated, e)
+		case e.Type == "route" && e.Action == "delete":
+			d.Send(EventRouteDeleted, e)
+		case e.Type == "redirect" && e.Action == "create":
+			d.Send(EventRedirectCreated, e)
+		case e.Type == "redirect" && e.Action == "delete":
+			d.Send(EventRedirectDeleted, e)
+		}
+	})
+}
+
+// WatchCompile sends the status of each failed compile
+func (d *Dispatcher) WatchCompile(c utils.CompileReporter) {
+	if d == nil {
+		return
+	}
+	c.OnFinish(func(status utils.CompileStatus) {
+		if !status.Success {
+			d.Send(EventCompileFailed, status)
+		}
+	})
+}
+
+// WatchBackends sends the backends marked unhealthy by the transport
+func (d *Dispatcher) WatchBackends(h *proxy.HybridTransport) {
+	if d == nil {
+		return
+	}
+	h.OnBackendHealth(func(b proxy.BackendHealth) {
+		if !b.Healthy {
+			d.Send(EventBackendUnhealthy, b)
+		}
+	})
+}
+
+// WatchCerts sends the certificates expiring soon after each compile, each
+// certificate is only reported once
+func (d *Dispatcher) WatchCerts(c *certs.Certs) {
+	if d == nil {
+		return
+	}
+	var mu sync.Mutex
+	sent := make(map[certs.CertChange]struct{})
+	c.OnFinish(func(status utils.CompileStatus) {
+		if !status.Success {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		expiring := c.ExpiringBefore(time.Now().Add(d.opts.CertExpiry))
+		next := make(map[certs.CertChange]struct{}, len(expiring))
+		for _, i := range expiring {
+			next[i] = struct{}{}
+			if _, ok := sent[i]; !ok {
+				d.Send(EventCertExpiring, i)
+			}
+		}
+		// forget replaced certificates so the map doesn't grow forever
+		sent = next
+	})
+}
+
+// RateLimited counts a request rejected by the rate limiter
+func (d *Dispatcher) RateLimited() {
+	if d == nil {
+		return
+	}
+	d.rateLimited.Add(1)
+}
+
+// watchSpikes sends an event when the rate limited requests in an interval
+// reach the threshold, this is only sent again after a quiet interval
+func (d *Dispatcher) watchSpikes() {
+	spiking := false
+	for {
+		select {
+		case <-d.spikeTicker.C:
+			n := d.rateLimited.Swap(0)
+			if n < d.opts.RateLimitSpike {
+				spiking = false
+				continue
+			}
+			if !spiking {
+				spiking = true
+				d.Send(EventRateLimitSpike, struct {
+					Rejected uint64 `json:"rejected"`
+					Interval string `json:"interval"`
+				}{n, d.opts.SpikeInterval.String()})
+			}
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"github.com/1f349/violet"
+	"github.com/1f349/violet/audit"
+	"github.com/1f349/violet/utils/fake"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDispatcher_WatchAudit(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_WatchAudit?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret")
+	d := New(db, Options{Endpoints: []Endpoint{{Url: r.URL, Secret: "secret"}}})
+	defer d.Close()
+	log := audit.New(db)
+	d.WatchAudit(log)
+
+	// updates aren't sent
+	log.Record(context.Background(), "alice", "update", "route", "example.com/a", nil, nil)
+	log.Record(context.Background(), "alice", "delete", "route", "example.com/a", map[string]string{"dst": "127.0.0.1:8080"}, nil)
+	r.wait(t, 1)
+	d.wg.Wait()
+	assert.Len(t, r.payloads, 1)
+	assert.Equal(t, EventRouteDeleted, r.payloads[0].Event)
+	data := r.payloads[0].Data.(map[string]any)
+	assert.Equal(t, "alice", data["actor"])
+	assert.Equal(t, "example.com/a", data["key"])
+	assert.Equal(t, map[string]any{"dst": "127.0.0.1:8080"}, data["before"])
+}
+
+func TestDispatcher_WatchCompile(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_WatchCompile?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret")
+	d := New(db, Options{Endpoints: []Endpoint{{Url: r.URL, Secret: "secret"}}})
+	defer d.Close()
+	f := fake.NewReportingCompilable("router")
+	d.WatchCompile(f)
+
+	// only failed compiles are sent
+	f.Compile()
+	_, _ = f.WaitCompile(context.Background(), time.Time{})
+	f.Err = errors.New("invalid route")
+	f.Compile()
+	r.wait(t, 1)
+	d.wg.Wait()
+	assert.Len(t, r.payloads, 1)
+	assert.Equal(t, EventCompileFailed, r.payloads[0].Event)
+	assert.Equal(t, "invalid route", r.payloads[0].Data.(map[string]any)["error"])
+}
+
+func TestDispatcher_RateLimited(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_RateLimited?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret")
+	d := New(db, Options{
+		Endpoints:      []Endpoint{{Url: r.URL, Secret: "secret"}},
+		RateLimitSpike: 3,
+		SpikeInterval:  50 * time.Millisecond,
+	})
+	defer d.Close()
+
+	for range 5 {
+		d.RateLimited()
+	}
+	r.wait(t, 1)
+	assert.Equal(t, EventRateLimitSpike, r.payloads[0].Event)
+	assert.Equal(t, float64(5), r.payloads[0].Data.(map[string]any)["rejected"])
+
+	// a continuing spike is only sent once
+	for range 5 {
+		d.RateLimited()
+	}
+	time.Sleep(150 * time.Millisecond)
+	assert.Empty(t, r.received)
+}
+
+func TestDispatcher_Nil(t *testing.T) {
+	// webhooks are disabled with a nil dispatcher
+	var d *Dispatcher
+	assert.NotPanics(t, func() {
+		d.Send(EventPing, nil)
+		d.RateLimited()
+		d.WatchAudit(nil)
+		d.Close()
+	})
+}
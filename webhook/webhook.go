@@ -0,0 +1,310 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/1f349/violet/database"
+	"github.com/1f349/violet/logger"
+	"github.com/google/uuid"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var Logger = logger.Logger.WithPrefix("Violet Webhook")
+
+const (
+	EventPing             = "ping"
+	EventRouteCreated     = "route.created"
+	EventRouteDeleted     = "route.deleted"
+	EventRedirectCreated  = "redirect.created"
+	EventRedirectDeleted  = "redirect.deleted"
+	EventCompileFailed    = "compile.failed"
+	EventBackendUnhealthy = "backend.unhealthy"
+	EventCertExpiring     = "cert.expiring"
+	EventRateLimitSpike   = "rate_limit.spike"
+)
+
+// Endpoint is a url which receives the webhook payloads
+type Endpoint struct {
+	Url    string   `json:"url"`
+	Secret string   `json:"secret"` // key used to sign the payloads
+	Events []string `json:"events"` // events to send, all events are sent if empty
+}
+
+func (e Endpoint) wants(event string) bool {
+	return len(e.Events) == 0 || event == EventPing || slices.Contains(e.Events, event)
+}
+
+// Options configures the webhook deliveries, zero values use the defaults
+type Options struct {
+	Endpoints []Endpoint
+
+	// MaxAttempts is the number of times a payload is sent before giving up,
+	// this defaults to 5
+	MaxAttempts int
+
+	// Backoff is the delay before the first retry and is doubled for each
+	// following retry, this defaults to 10 seconds
+	Backoff time.Duration
+
+	// Timeout is the time allowed for each attempt, this defaults to 10 seconds
+	Timeout time.Duration
+
+	// CertExpiry is the time before a certificate expires when it is reported,
+	// this defaults to 14 days
+	CertExpiry time.Duration
+
+	// RateLimitSpike is the number of rate limited requests in an interval
+	// which is reported as a spike, this defaults to 100
+	RateLimitSpike uint64
+
+	// SpikeInterval is the length of each interval used to count rate limited
+	// requests, this defaults to 1 minute
+	SpikeInterval time.Duration
+
+	// KeepDeliveries is the number of delivery attempts kept in the log, this
+	// defaults to 1000
+	KeepDeliveries int
+}
+
+// Validate checks each endpoint has a url and a signing secret
+func (o Options) Validate() error {
+	for i, e := range o.Endpoints {
+		if e.Url == "" {
+			return fmt.Errorf("webhook endpoint %d: missing url", i)
+		}
+		if e.Secret == "" {
+			return fmt.Errorf("webhook endpoint %s: missing signing secret", e.Url)
+		}
+	}
+	return nil
+}
+
+// Payload is the JSON body sent to each endpoint
+type Payload struct {
+	ID    string    `json:"id"` // delivery id, this is the same for each attempt
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// Delivery is a single attempt to send a payload to an endpoint
+type Delivery struct {
+	ID       int64     `json:"id"`
+	Delivery string    `json:"delivery"`
+	Time     time.Time `json:"time"`
+	Url      string    `json:"url"`
+	Event    string    `json:"event"`
+	Attempt  int64     `json:"attempt"`
+	Status   int64     `json:"status,omitempty"` // response status code, zero if there was no response
+	Error    string    `json:"error,omitempty"`
+}
+
+// Dispatcher sends the payloads for each event to the configured endpoints
+// and keeps a log of the deliveries. A nil Dispatcher sends nothing.
+type Dispatcher struct {
+	db     *database.Queries
+	opts   Options
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// mu guards closed so no deliveries are started after Close waits for
+	// the running ones
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+
+	// rateLimited counts the rate limited requests in the current interval
+	rateLimited atomic.Uint64
+	spikeTicker *time.Ticker
+}
+
+// New creates a dispatcher which logs deliveries to the database. Close must
+// be called to stop the background tasks.
+func New(db *database.Queries, opts Options) *Dispatcher {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.CertExpiry <= 0 {
+		opts.CertExpiry = 14 * 24 * time.Hour
+	}
+	if opts.RateLimitSpike == 0 {
+		opts.RateLimitSpike = 100
+	}
+	if opts.SpikeInterval <= 0 {
+		opts.SpikeInterval = time.Minute
+	}
+	if opts.KeepDeliveries <= 0 {
+		opts.KeepDeliveries = 1000
+	}
+
+	d := &Dispatcher{
+		db:          db,
+		opts:        opts,
+		client:      &http.Client{Timeout: opts.Timeout},
+		spikeTicker: time.NewTicker(opts.SpikeInterval),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	go d.watchSpikes()
+	return d
+}
+
+// Send delivers the event to each endpoint which wants it in the background
+func (d *Dispatcher) Send(event string, data any) {
+	if d == nil {
+		return
+	}
+	p := Payload{ID: uuid.NewString(), Event: event, Time: time.Now().UTC(), Data: data}
+	body, err := json.Marshal(p)
+	if err != nil {
+		Logger.Warn("Failed to encode payload", "event", event, "err", err)
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	for _, e := range d.opts.Endpoints {
+		if !e.wants(event) {
+			continue
+		}
+		d.wg.Go(func() {
+			d.deliver(e, p, body)
+		})
+	}
+}
+
+// Deliveries returns up to limit delivery attempts newest first. Only
+// attempts older than beforeId are returned if beforeId is not zero.
+func (d *Dispatcher) Deliveries(ctx context.Context, beforeId int64, limit int) ([]Delivery, error) {
+	rows, err := d.db.GetWebhookDeliveries(ctx, database.GetWebhookDeliveriesParams{BeforeID: beforeId, Limit: int64(limit)})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(rows))
+	for _, row := range rows {
+		deliveries = append(deliveries, Delivery(row))
+	}
+	return deliveries, nil
+}
+
+// Close stops the background tasks and cancels the pending retries
+func (d *Dispatcher) Close() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+	d.cancel()
+	d.spikeTicker.Stop()
+	d.wg.Wait()
+}
+
+// deliver sends the payload to the endpoint until it is accepted or the
+// attempts run out
+func (d *Dispatcher) deliver(e Endpoint, p Payload, body []byte) {
+	backoff := d.opts.Backoff
+	for attempt := 1; attempt <= d.opts.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-d.ctx.Done():
+				return
+			}
+		}
+
+		status, err := d.post(e, p, body)
+		d.log(e, p, attempt, status, err)
+		if err == nil {
+			return
+		}
+		if !retryable(status) {
+			Logger.Warn("Webhook delivery rejected", "url", e.Url, "event", p.Event, "status", status)
+			return
+		}
+	}
+	Logger.Warn("Webhook delivery failed", "url", e.Url, "event", p.Event, "attempts", d.opts.MaxAttempts)
+}
+
+// post sends a single attempt and returns the response status code
+func (d *Dispatcher) post(e Endpoint, p Payload, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, e.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Violet-Webhook")
+	req.Header.Set("X-Violet-Event", p.Event)
+	req.Header.Set("X-Violet-Delivery", p.ID)
+	req.Header.Set("X-Violet-Timestamp", timestamp)
+	req.Header.Set("X-Violet-Signature", Sign(e.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (d *Dispatcher) log(e Endpoint, p Payload, attempt, status int, err error) {
+	params := database.AddWebhookDeliveryParams{
+		Delivery: p.ID,
+		Time:     time.Now().UTC(),
+		Url:      e.Url,
+		Event:    p.Event,
+		Attempt:  int64(attempt),
+		Status:   int64(status),
+	}
+	if err != nil {
+		params.Error = err.Error()
+	}
+	if err := d.db.AddWebhookDelivery(context.Background(), params); err != nil {
+		Logger.Warn("Failed to log webhook delivery", "err", err)
+	}
+	if err := d.db.PruneWebhookDeliveries(context.Background(), int64(d.opts.KeepDeliveries)); err != nil {
+		Logger.Warn("Failed to prune webhook deliveries", "err", err)
+	}
+}
+
+// retryable reports whether a failed attempt with the status code should be
+// sent again, a zero status code means there was no response
+func retryable(status int) bool {
+	return status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+}
+
+// Sign returns the X-Violet-Signature header value for the body. Receivers
+// should compare this with hmac.Equal after computing it with their copy of
+// the secret.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,222 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/1f349/violet"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is a local webhook endpoint which records the verified payloads
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	codes    []int // response codes for the first requests, then 200
+	payloads []Payload
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, secret string, codes ...int) *receiver {
+	r := &receiver{codes: codes, received: make(chan struct{}, 10)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, Sign(secret, req.Header.Get("X-Violet-Timestamp"), body), req.Header.Get("X-Violet-Signature"))
+
+		var p Payload
+		assert.NoError(t, json.Unmarshal(body, &p))
+		assert.Equal(t, p.Event, req.Header.Get("X-Violet-Event"))
+		assert.Equal(t, p.ID, req.Header.Get("X-Violet-Delivery"))
+
+		r.mu.Lock()
+		code := http.StatusOK
+		if len(r.codes) > 0 {
+			code, r.codes = r.codes[0], r.codes[1:]
+		}
+		r.payloads = append(r.payloads, p)
+		r.mu.Unlock()
+		rw.WriteHeader(code)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) wait(t *testing.T, n int) {
+	for range n {
+		select {
+		case <-r.received:
+		case <-time.After(2 * time.Second):
+			assert.FailNow(t, "missing webhook delivery")
+		}
+	}
+}
+
+func TestDispatcher_Send(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_Send?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret", http.StatusInternalServerError, http.StatusTooManyRequests)
+	d := New(db, Options{
+		Endpoints: []Endpoint{{Url: r.URL, Secret: "secret"}},
+		Backoff:   time.Millisecond,
+	})
+	defer d.Close()
+
+	d.Send(EventRouteCreated, map[string]string{"src": "example.com"})
+	r.wait(t, 3)
+	d.wg.Wait()
+
+	// each attempt sends the same payload
+	assert.Len(t, r.payloads, 3)
+	assert.Equal(t, r.payloads[0].ID, r.payloads[2].ID)
+	assert.Equal(t, EventRouteCreated, r.payloads[0].Event)
+	assert.Equal(t, map[string]any{"src": "example.com"}, r.payloads[0].Data)
+
+	deliveries, err := d.Deliveries(context.Background(), 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 3)
+	assert.Equal(t, int64(3), deliveries[0].Attempt)
+	assert.Equal(t, int64(http.StatusOK), deliveries[0].Status)
+	assert.Empty(t, deliveries[0].Error)
+	assert.Equal(t, int64(1), deliveries[2].Attempt)
+	assert.Equal(t, int64(http.StatusInternalServerError), deliveries[2].Status)
+	assert.Equal(t, "unexpected status code: 500", deliveries[2].Error)
+	assert.Equal(t, r.URL, deliveries[2].Url)
+
+	// older attempts are listed after the before id
+	deliveries, err = d.Deliveries(context.Background(), deliveries[1].ID, 10)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+}
+
+func TestDispatcher_Send_Rejected(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_Send_Rejected?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret", http.StatusBadRequest)
+	d := New(db, Options{
+		Endpoints: []Endpoint{{Url: r.URL, Secret: "secret"}},
+		Backoff:   time.Millisecond,
+	})
+	defer d.Close()
+
+	// client errors are not retried
+	d.Send(EventCompileFailed, nil)
+	r.wait(t, 1)
+	d.wg.Wait()
+	deliveries, err := d.Deliveries(context.Background(), 0, 10)
+	assert.NoError(t, err)
+	assert.Len(t, deliveries, 1)
+}
+
+func TestDispatcher_Send_Events(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_Send_Events?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "other")
+	d := New(db, Options{Endpoints: []Endpoint{{Url: r.URL, Secret: "other", Events: []string{EventCertExpiring}}}})
+	defer d.Close()
+
+	// only the listed events and pings are sent
+	d.Send(EventRouteDeleted, nil)
+	d.Send(EventCertExpiring, nil)
+	d.Send(EventPing, nil)
+	r.wait(t, 2)
+	d.wg.Wait()
+	assert.ElementsMatch(t, []string{EventCertExpiring, EventPing}, []string{r.payloads[0].Event, r.payloads[1].Event})
+}
+
+func TestDispatcher_Close(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_Close?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret", http.StatusServiceUnavailable)
+	d := New(db, Options{
+		Endpoints: []Endpoint{{Url: r.URL, Secret: "secret"}},
+		Backoff:   time.Hour,
+	})
+
+	// pending retries are cancelled
+	d.Send(EventPing, nil)
+	r.wait(t, 1)
+	done := make(chan struct{})
+	go func() {
+		d.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "close waited for the retry")
+	}
+
+	// nothing is sent after closing
+	d.Send(EventPing, nil)
+	assert.Empty(t, r.received)
+}
+
+func TestDispatcher_Close_Concurrent(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_Close_Concurrent?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret")
+	r.received = make(chan struct{}, 100)
+	d := New(db, Options{Endpoints: []Endpoint{{Url: r.URL, Secret: "secret"}}, MaxAttempts: 1})
+
+	// sending while closing never starts a delivery after Close returns
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			d.Send(EventPing, nil)
+		})
+	}
+	d.Close()
+	logged, err := d.Deliveries(context.Background(), 0, 100)
+	assert.NoError(t, err)
+	wg.Wait()
+	d.Send(EventPing, nil)
+	time.Sleep(50 * time.Millisecond)
+	deliveries, err := d.Deliveries(context.Background(), 0, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, len(logged), len(deliveries))
+}
+
+func TestDispatcher_KeepDeliveries(t *testing.T) {
+	db, err := violet.InitDB("file:TestDispatcher_KeepDeliveries?mode=memory&cache=shared")
+	assert.NoError(t, err)
+
+	r := newReceiver(t, "secret")
+	d := New(db, Options{Endpoints: []Endpoint{{Url: r.URL, Secret: "secret"}}, KeepDeliveries: 2})
+	defer d.Close()
+
+	for range 3 {
+		d.Send(EventPing, nil)
+		r.wait(t, 1)
+	}
+
+	// the oldest delivery is removed once the third is logged
+	assert.Eventually(t, func() bool {
+		deliveries, err := d.Deliveries(context.Background(), 0, 10)
+		return err == nil && len(deliveries) == 2 && deliveries[0].ID > 2
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestOptions_Validate(t *testing.T) {
+	assert.NoError(t, Options{}.Validate())
+	assert.NoError(t, Options{Endpoints: []Endpoint{{Url: "https://example.com/hook", Secret: "secret"}}}.Validate())
+	assert.Error(t, Options{Endpoints: []Endpoint{{Url: "https://example.com/hook"}}}.Validate())
+	assert.Error(t, Options{Endpoints: []Endpoint{{Secret: "secret"}}}.Validate())
+}
+
+func TestSign(t *testing.T) {
+	// the timestamp is signed to stop payloads being replayed later
+	assert.Equal(t, "sha256=49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686", Sign("secret", "1700000000", []byte(`{"a":1}`)))
+	assert.NotEqual(t, Sign("secret", "1700000000", []byte(`{"a":1}`)), Sign("secret", "1700000001", []byte(`{"a":1}`)))
+}